@@ -0,0 +1,487 @@
+// Package bench measures the client and the proxy together. It runs synthetic game traffic through the
+// multipath client and a local proxy over loopback, to a fake game server echoing every packet back to the
+// game client, and reports the latency they add, the throughput and the allocations per packet.
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/proxy"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+	"github.com/SergioFloresCorrea/lol-multipath/udpmultipath"
+)
+
+const (
+	region          = "BENCH" // served by the proxy, its upstream latency is measured against the game server
+	minSize         = 8       // the sequence number
+	defaultDuration = 10 * time.Second
+	lossTimeout     = time.Second
+
+	// a shorter direct run is enough to compare with
+	directPackets  = 5000
+	directDuration = 2 * time.Second
+)
+
+// Options configures a run. The zero value sends 100-byte packets over 2 paths, as fast as they come
+// back, for 10 seconds.
+type Options struct {
+	Paths    int           // paths every packet is sent over, all to the one proxy; 0 means 2
+	Rate     float64       // packets per second; 0 sends as fast as the replies come back, with Window packets in flight
+	Window   int           // packets in flight when Rate is 0; 0 means 32
+	Size     int           // bytes per packet, at least 8; 0 means 100
+	Duration time.Duration // how long to send for at most; 0 means 10s, or no limit if Packets is set
+	Packets  int           // packets to send at most; 0 sends for Duration
+	Auth     bool          // authenticate the packets with a pre-shared key
+	Tunnel   bool          // encrypt the packets, see package tunnel
+	Logger   *slog.Logger  // logger of the client and the proxy; nil discards their logs
+}
+
+func (opts *Options) validate() error {
+	if opts.Paths <= 0 {
+		opts.Paths = 2
+	}
+	if opts.Window <= 0 {
+		opts.Window = 32
+	}
+	if opts.Size == 0 {
+		opts.Size = 100
+	}
+	if opts.Size < minSize {
+		return fmt.Errorf("packets must be at least %d bytes, got %d", minSize, opts.Size)
+	}
+	if opts.Rate < 0 || opts.Packets < 0 || opts.Duration < 0 {
+		return errors.New("the rate, the number of packets and the duration can't be negative")
+	}
+	if opts.Duration == 0 && opts.Packets == 0 {
+		opts.Duration = defaultDuration
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	return nil
+}
+
+// Percentiles of the round trips of a run.
+type Percentiles struct {
+	P50, P90, P99, P999, Max time.Duration
+}
+
+func percentiles(rtts []time.Duration) Percentiles {
+	if len(rtts) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(rtts)
+	at := func(q float64) time.Duration {
+		return rtts[min(int(q*float64(len(rtts))), len(rtts)-1)]
+	}
+	return Percentiles{P50: at(0.5), P90: at(0.9), P99: at(0.99), P999: at(0.999), Max: rtts[len(rtts)-1]}
+}
+
+// Result of a run. The round trips go from the client's ingress to the game server and back to the game
+// client, the direct ones straight from a socket to the game server and back; the difference is what the
+// client and the proxy add.
+type Result struct {
+	Options    Options
+	Sent       int
+	Received   int // packets whose reply came back
+	Duplicates int // replies beyond the first to a packet
+	Elapsed    time.Duration
+	Latency    Percentiles
+	Direct     Percentiles
+
+	// Heap allocations per packet sent of the whole process, the client, the proxy and the fake game
+	// server and client, beyond those of the direct run.
+	AllocsPerPacket float64
+	BytesPerPacket  float64
+}
+
+// Lost returns how many packets got no reply.
+func (r Result) Lost() int {
+	return r.Sent - r.Received
+}
+
+// Throughput returns the packets per second whose reply came back.
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Received) / r.Elapsed.Seconds()
+}
+
+// Added returns the latency added by the client and the proxy, at every percentile.
+func (r Result) Added() Percentiles {
+	return Percentiles{
+		P50:  r.Latency.P50 - r.Direct.P50,
+		P90:  r.Latency.P90 - r.Direct.P90,
+		P99:  r.Latency.P99 - r.Direct.P99,
+		P999: r.Latency.P999 - r.Direct.P999,
+		Max:  r.Latency.Max - r.Direct.Max,
+	}
+}
+
+// Writes a human readable report of the result.
+func (r Result) Write(w io.Writer) error {
+	rate := "as fast as replies come back"
+	if r.Options.Rate > 0 {
+		rate = fmt.Sprintf("%g packets/s", r.Options.Rate)
+	}
+	row := func(name string, p Percentiles) string {
+		us := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+		return fmt.Sprintf("%-9s %10v %10v %10v %10v %10v\n", name, us(p.P50), us(p.P90), us(p.P99), us(p.P999), us(p.Max))
+	}
+	_, err := fmt.Fprintf(w, "%d-byte packets over %d paths, %s\n"+
+		"sent %d, received %d, lost %d, duplicates %d in %v\n"+
+		"throughput %.0f packets/s, %.2f Mbit/s\n"+
+		"allocations %.1f per packet, %.0f bytes per packet\n\n"+
+		"%-9s %10s %10s %10s %10s %10s\n%s%s%s",
+		r.Options.Size, r.Options.Paths, rate,
+		r.Sent, r.Received, r.Lost(), r.Duplicates, r.Elapsed.Round(time.Millisecond),
+		r.Throughput(), r.Throughput()*float64(r.Options.Size)*8/1e6,
+		r.AllocsPerPacket, r.BytesPerPacket,
+		"", "p50", "p90", "p99", "p99.9", "max",
+		row("latency", r.Latency), row("direct", r.Direct), row("added", r.Added()))
+	return err
+}
+
+// Run starts a fake game server, a proxy and the client, then measures the round trips of the traffic
+// described by `opts` through them, and straight to the game server for comparison.
+func Run(ctx context.Context, opts Options) (Result, error) {
+	if err := opts.validate(); err != nil {
+		return Result{}, err
+	}
+	l, err := start(ctx, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer l.close()
+
+	direct, err := l.direct()
+	if err != nil {
+		return Result{}, err
+	}
+	defer direct.Close()
+	directOpts := opts
+	directOpts.Packets, directOpts.Duration = directPackets, directDuration
+	if opts.Packets > 0 {
+		directOpts.Packets = min(opts.Packets, directPackets)
+	}
+	if opts.Duration > 0 {
+		directOpts.Duration = min(opts.Duration, directDuration)
+	}
+	base := measure(ctx, directOpts, direct, func(pkt []byte) error {
+		_, err := direct.Write(pkt)
+		return err
+	})
+
+	res := l.measure(ctx, opts)
+	res.Direct = base.Latency
+	res.AllocsPerPacket -= base.AllocsPerPacket
+	res.BytesPerPacket -= base.BytesPerPacket
+	return res, ctx.Err()
+}
+
+// The client, the proxy and the fake game server, running.
+type loop struct {
+	game    *net.UDPConn
+	client  *net.UDPConn // where the game client listens, the session's ClientAddr
+	packets chan []byte  // the client's ingress
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	errs    chan error
+}
+
+// Paths are told apart by their local IP, so each gets a made-up one from 192.0.2.0/24; they are
+// all dialed from 127.0.0.1.
+type loopbackDialer struct{}
+
+func (loopbackDialer) DialPath(local net.IP, remote string) (net.Conn, error) {
+	return net.Dial("udp", remote)
+}
+
+func listenLoopback() (*net.UDPConn, error) {
+	return net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+}
+
+// Starts the fake game server, the proxy and the client, and returns once the client sends over every path.
+func start(ctx context.Context, opts Options) (*loop, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	l := &loop{packets: make(chan []byte, opts.Window), cancel: cancel, errs: make(chan error, 2)}
+	var err error
+	if l.game, err = listenLoopback(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if l.client, err = listenLoopback(); err != nil {
+		l.game.Close()
+		cancel()
+		return nil, err
+	}
+	go echo(l.game)
+
+	proxyOpts := proxy.Options{
+		ListenAddr:      "127.0.0.1:0",
+		PingListenAddr:  "127.0.0.1:0",
+		Regions:         []string{region},
+		UpstreamTargets: map[string]string{region: "udp://" + l.game.LocalAddr().String()},
+		Logger:          opts.Logger,
+	}
+	cfg := udpmultipath.Config{
+		Server:          region,
+		ThresholdFactor: 2,
+		UpdateInterval:  time.Hour,
+		Timeout:         time.Second,
+		ProbeInterval:   time.Second,
+		MaxConnections:  opts.Paths,
+		Controller:      udpmultipath.NewController(),
+		Logger:          opts.Logger,
+		Dialer:          loopbackDialer{},
+	}
+	if opts.Auth {
+		key, err := keys.Generate("bench")
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		proxyOpts.Keys = keys.NewRing([]keys.Key{key})
+		cfg.Key = &key
+	}
+	var proxyKey, clientKey tunnel.Keypair
+	if opts.Tunnel {
+		if proxyKey, err = tunnel.GenerateKeypair(); err == nil {
+			clientKey, err = tunnel.GenerateKeypair()
+		}
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		proxyOpts.TunnelKey = &proxyKey
+		proxyOpts.TunnelClients = keys.NewRing([]keys.Key{{Name: "bench", Secret: clientKey.Public[:]}})
+	}
+
+	srv, err := proxy.NewServer(proxyOpts)
+	if err == nil {
+		err = srv.Listen()
+	}
+	if err != nil {
+		l.close()
+		return nil, fmt.Errorf("starting the proxy: %w", err)
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.errs <- srv.Serve(ctx)
+	}()
+
+	proxyAddr := srv.Addr().String()
+	if opts.Tunnel {
+		cfg.Tunnel = &udpmultipath.TunnelConfig{Key: clientKey, ProxyKeys: map[string][tunnel.KeySize]byte{proxyAddr: proxyKey.Public}}
+	}
+	localIPs := make([]net.IP, opts.Paths)
+	for i := range localIPs {
+		localIPs[i] = net.IPv4(192, 0, 2, byte(i+1))
+	}
+	session := udpmultipath.GameSession{
+		ID:         "bench",
+		GameAddr:   l.game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: l.client.LocalAddr().(*net.UDPAddr),
+		ClientIPs:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.errs <- cfg.MultipathProxy(ctx, session, localIPs, []string{proxyAddr}, []string{srv.PingAddr().String()}, l.packets)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for selected(cfg.Controller) < opts.Paths {
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			l.close()
+			return nil, fmt.Errorf("the client selected %d of its %d paths", selected(cfg.Controller), opts.Paths)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return l, nil
+}
+
+func selected(ctl *udpmultipath.Controller) int {
+	n := 0
+	for _, p := range ctl.Status().Paths {
+		if p.State == udpmultipath.PathSelected {
+			n++
+		}
+	}
+	return n
+}
+
+// Echoes every datagram back to its sender, until `conn` is closed.
+func echo(conn *net.UDPConn) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		_, _ = conn.WriteToUDPAddrPort(buf[:n], from)
+	}
+}
+
+// Stops the client and the proxy and closes the sockets. Returns the first error they stopped with.
+func (l *loop) close() error {
+	l.cancel()
+	l.wg.Wait()
+	close(l.errs)
+	var err error
+	for e := range l.errs {
+		if err == nil {
+			err = e
+		}
+	}
+	if l.client != nil {
+		l.client.Close()
+	}
+	if l.game != nil {
+		l.game.Close()
+	}
+	return err
+}
+
+// Returns a socket connected straight to the game server.
+func (l *loop) direct() (*net.UDPConn, error) {
+	return net.DialUDP("udp", nil, l.game.LocalAddr().(*net.UDPAddr))
+}
+
+// Measures the traffic of l.opts through the client, whose ingress copies every packet like WinDivert does.
+func (l *loop) measure(ctx context.Context, opts Options) Result {
+	return measure(ctx, opts, l.client, func(pkt []byte) error {
+		select {
+		case l.packets <- append([]byte(nil), pkt...):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// Sends the traffic of `opts` with `send` and reads the replies from `replies`. Every packet starts
+// with its sequence number, the rest is padding.
+func measure(ctx context.Context, opts Options, replies *net.UDPConn, send func([]byte) error) Result {
+	var (
+		mu       sync.Mutex
+		sentAt   []time.Time
+		rtts     []time.Duration
+		answered []bool
+		dupes    int
+		last     time.Time // when the last reply came
+	)
+	window := make(chan struct{}, opts.Window) // a slot per packet in flight, when Rate is 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := replies.Read(buf)
+			now := time.Now()
+			if err != nil {
+				return
+			}
+			if n < minSize {
+				continue
+			}
+			seq := binary.BigEndian.Uint64(buf)
+			mu.Lock()
+			if seq < uint64(len(sentAt)) {
+				if answered[seq] {
+					dupes++
+				} else {
+					answered[seq] = true
+					rtts = append(rtts, now.Sub(sentAt[seq]))
+					last = now
+					select {
+					case <-window:
+					default:
+					}
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	pkt := make([]byte, opts.Size)
+	begin := time.Now()
+	for seq := 0; opts.Packets == 0 || seq < opts.Packets; seq++ {
+		if opts.Duration > 0 && time.Since(begin) >= opts.Duration || ctx.Err() != nil {
+			break
+		}
+		if opts.Rate > 0 {
+			if wait := time.Until(begin.Add(time.Duration(float64(seq) / opts.Rate * float64(time.Second)))); wait > 0 {
+				time.Sleep(wait)
+			}
+		} else {
+			select {
+			case window <- struct{}{}:
+			case <-time.After(lossTimeout):
+				// the packets in flight were lost, don't wait for them anymore
+				for len(window) > 0 {
+					<-window
+				}
+				window <- struct{}{}
+			}
+		}
+		binary.BigEndian.PutUint64(pkt, uint64(seq))
+		mu.Lock()
+		sentAt = append(sentAt, time.Now())
+		answered = append(answered, false)
+		mu.Unlock()
+		if err := send(pkt); err != nil {
+			mu.Lock()
+			sentAt, answered = sentAt[:seq], answered[:seq]
+			mu.Unlock()
+			break
+		}
+	}
+
+	// wait for the last replies
+	res := Result{Options: opts}
+	deadline := time.Now().Add(lossTimeout)
+	for {
+		mu.Lock()
+		res.Sent, res.Received = len(sentAt), len(rtts)
+		mu.Unlock()
+		if res.Received == res.Sent || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	runtime.ReadMemStats(&after)
+
+	replies.SetReadDeadline(time.Now())
+	<-done
+	replies.SetReadDeadline(time.Time{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	res.Received, res.Duplicates = len(rtts), dupes
+	res.Elapsed = last.Sub(begin)
+	res.Latency = percentiles(rtts)
+	if res.Sent > 0 {
+		res.AllocsPerPacket = float64(after.Mallocs-before.Mallocs) / float64(res.Sent)
+		res.BytesPerPacket = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Sent)
+	}
+	return res
+}
@@ -0,0 +1,72 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	for _, opts := range []Options{
+		{Paths: 2, Packets: 300},
+		{Paths: 1, Rate: 500, Packets: 100, Size: 1200, Auth: true},
+		{Paths: 3, Packets: 200, Tunnel: true},
+	} {
+		t.Run(fmt.Sprintf("%d paths auth %v tunnel %v", opts.Paths, opts.Auth, opts.Tunnel), func(t *testing.T) {
+			res, err := Run(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			// loopback doesn't lose packets, and the proxy forwards one copy of each
+			if res.Sent != opts.Packets || res.Received != res.Sent || res.Duplicates != 0 {
+				t.Errorf("sent %d, received %d with %d duplicates; want %d once each", res.Sent, res.Received, res.Duplicates, opts.Packets)
+			}
+			if res.Latency.P50 <= 0 || res.Latency.P50 > res.Latency.Max || res.Direct.P50 <= 0 {
+				t.Errorf("latency %+v, direct %+v", res.Latency, res.Direct)
+			}
+			var out bytes.Buffer
+			if err := res.Write(&out); err != nil || !strings.Contains(out.String(), "added") {
+				t.Errorf("Write = %v:\n%s", err, out.String())
+			}
+		})
+	}
+}
+
+// Measures the full loop: every packet goes through the client's fan-out and the proxy to the game
+// server, and its reply back to the game client, with 32 packets in flight.
+func BenchmarkLoop(b *testing.B) {
+	for _, opts := range []Options{{Paths: 1}, {Paths: 2}, {Paths: 4}, {Paths: 2, Auth: true}, {Paths: 2, Tunnel: true}} {
+		name := fmt.Sprintf("paths=%d", opts.Paths)
+		if opts.Auth {
+			name += "/auth"
+		}
+		if opts.Tunnel {
+			name += "/tunnel"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts.Packets = b.N
+			if err := opts.validate(); err != nil {
+				b.Fatal(err)
+			}
+			l, err := start(context.Background(), opts)
+			if err != nil {
+				b.Fatalf("start: %v", err)
+			}
+			defer l.close()
+
+			b.SetBytes(int64(opts.Size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			res := l.measure(context.Background(), opts)
+			b.StopTimer()
+			if res.Sent != b.N {
+				b.Fatalf("sent %d packets, want %d", res.Sent, b.N)
+			}
+			b.ReportMetric(float64(res.Latency.P50.Microseconds()), "p50-µs")
+			b.ReportMetric(float64(res.Latency.P99.Microseconds()), "p99-µs")
+			b.ReportMetric(float64(res.Lost()), "lost")
+		})
+	}
+}
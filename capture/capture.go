@@ -0,0 +1,172 @@
+// Package capture writes pcapng captures of a session's traffic, to open in Wireshark: an interface block per
+// path the datagrams were sent or received on, plus blocks for the game traffic itself. The datagrams are wrapped
+// in the IPv4 or IPv6 and UDP headers of the addresses they went between, the interfaces use the raw IP link type.
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Interface is an interface block of a capture.
+type Interface struct {
+	Name        string // e.g. "path 0"
+	Description string // e.g. "10.0.0.2 → 192.0.2.10:9029"
+}
+
+func (i Interface) ng() pcapgo.NgInterface {
+	return pcapgo.NgInterface{Name: i.Name, Description: i.Description, LinkType: layers.LinkTypeRaw, TimestampResolution: 9}
+}
+
+// Writer writes the datagrams of a capture. It is safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	ng     *pcapgo.NgWriter
+	closer io.Closer
+	buf    gopacket.SerializeBuffer
+	n      int   // interfaces
+	err    error // first error, later writes are dropped
+}
+
+// Creates a writer to `w` with the interface blocks of `interfaces`, there must be at least one.
+// Their index in the slice is their index in the capture. The packets are buffered, see Flush.
+func NewWriter(w io.Writer, interfaces []Interface) (*Writer, error) {
+	if len(interfaces) == 0 {
+		return nil, errors.New("a capture needs at least one interface")
+	}
+	ng, err := pcapgo.NewNgWriterInterface(w, interfaces[0].ng(), pcapgo.NgWriterOptions{
+		SectionInfo: pcapgo.NgSectionInfo{Application: "lol-multipath"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cw := &Writer{ng: ng, buf: gopacket.NewSerializeBuffer(), n: 1}
+	for _, i := range interfaces[1:] {
+		if _, err := cw.AddInterface(i); err != nil {
+			return nil, err
+		}
+	}
+	return cw, nil
+}
+
+// Creates the capture of the session `id` in `dir`, named after its start time and ID.
+func Create(dir, id string, at time.Time, interfaces []Interface) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s.pcapng", at.UTC().Format("20060102T150405Z"), sanitize(id))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, interfaces)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// Keeps the characters of a session ID that are safe in a file name.
+func sanitize(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// Adds an interface block; returns its index.
+func (w *Writer) AddInterface(i Interface) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	var id int
+	if id, w.err = w.ng.AddInterface(i.ng()); w.err == nil {
+		w.n++
+	}
+	return id, w.err
+}
+
+// Writes a datagram carrying `payload` from `src` to `dst`, captured at `at` on the interface `iface`.
+// Once a write failed, the following ones are dropped and return the same error.
+func (w *Writer) WritePacket(iface int, at time.Time, src, dst netip.AddrPort, payload []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if iface < 0 || iface >= w.n {
+		return fmt.Errorf("no interface %d, the capture has %d", iface, w.n)
+	}
+	data, err := w.datagram(src, dst, payload)
+	if err != nil {
+		return err // this packet can't be written, the next ones may
+	}
+	w.err = w.ng.WritePacket(gopacket.CaptureInfo{Timestamp: at, CaptureLength: len(data), Length: len(data), InterfaceIndex: iface}, data)
+	return w.err
+}
+
+// Returns the IP datagram of a UDP packet, in w.buf.
+func (w *Writer) datagram(src, dst netip.AddrPort, payload []byte) ([]byte, error) {
+	udp := &layers.UDP{SrcPort: layers.UDPPort(src.Port()), DstPort: layers.UDPPort(dst.Port())}
+	var ip gopacket.SerializableLayer
+	srcIP, dstIP := orUnspecified(src.Addr()), orUnspecified(dst.Addr())
+	if srcIP.Is4() && dstIP.Is4() {
+		s, d := srcIP.As4(), dstIP.As4()
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: s[:], DstIP: d[:]}
+		udp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	} else {
+		s, d := srcIP.As16(), dstIP.As16()
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: s[:], DstIP: d[:]}
+		udp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	}
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(w.buf, opts, ip, udp, gopacket.Payload(payload)); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+func orUnspecified(addr netip.Addr) netip.Addr {
+	if !addr.IsValid() {
+		return netip.IPv4Unspecified()
+	}
+	return addr.Unmap()
+}
+
+// Writes the buffered packets.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.ng.Flush()
+	}
+	return w.err
+}
+
+// Flushes the packets and closes the file of a writer made by Create.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		err = errors.Join(err, w.closer.Close())
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package capture
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b, []Interface{{Name: "game", Description: "intercepted"}, {Name: "path 0"}})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	v6, err := w.AddInterface(Interface{Name: "path 1"})
+	if err != nil || v6 != 2 {
+		t.Fatalf("AddInterface = %d, %v", v6, err)
+	}
+
+	at := time.Date(2024, 5, 1, 20, 0, 0, 123456789, time.UTC)
+	client := netip.MustParseAddrPort("10.0.0.2:5000")
+	game := netip.MustParseAddrPort("192.0.2.1:5100")
+	proxy6 := netip.MustParseAddrPort("[2001:db8::10]:9029")
+	writes := []struct {
+		iface    int
+		src, dst netip.AddrPort
+		payload  string
+	}{
+		{0, client, game, "hello"},
+		{1, netip.MustParseAddrPort("[::ffff:10.0.0.2]:40000"), netip.MustParseAddrPort("198.51.100.7:9029"), "sealed hello"},
+		{2, netip.MustParseAddrPort("[2001:db8::2]:40001"), proxy6, "sealed hello again"},
+	}
+	for i, wr := range writes {
+		if err := w.WritePacket(wr.iface, at.Add(time.Duration(i)*time.Millisecond), wr.src, wr.dst, []byte(wr.payload)); err != nil {
+			t.Fatalf("WritePacket %d: %v", i, err)
+		}
+	}
+	if err := w.WritePacket(3, at, client, game, nil); err == nil {
+		t.Error("WritePacket to a missing interface succeeded")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := pcapgo.NewNgReader(&b, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatalf("NewNgReader: %v", err)
+	}
+	for i, wr := range writes {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatalf("ReadPacketData %d: %v", i, err)
+		}
+		if ci.InterfaceIndex != wr.iface || !ci.Timestamp.Equal(at.Add(time.Duration(i)*time.Millisecond)) {
+			t.Errorf("packet %d captured on %d at %v", i, ci.InterfaceIndex, ci.Timestamp)
+		}
+		first := layers.LayerTypeIPv4
+		if i == 2 {
+			first = layers.LayerTypeIPv6
+		}
+		packet := gopacket.NewPacket(data, first, gopacket.Default)
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok {
+			t.Fatalf("packet %d has no UDP layer: %v", i, packet)
+		}
+		if int(udp.SrcPort) != int(wr.src.Port()) || int(udp.DstPort) != int(wr.dst.Port()) || string(udp.Payload) != wr.payload {
+			t.Errorf("packet %d = %v → %v %q", i, udp.SrcPort, udp.DstPort, udp.Payload)
+		}
+		if flow := packet.NetworkLayer().NetworkFlow(); flow.Dst().String() != wr.dst.Addr().Unmap().String() {
+			t.Errorf("packet %d goes to %v, want %v", i, flow.Dst(), wr.dst.Addr())
+		}
+	}
+	if r.NInterfaces() != 3 {
+		t.Errorf("read %d interfaces, want 3", r.NInterfaces())
+	}
+	if i, _ := r.Interface(1); i.Name != "path 0" || i.LinkType != layers.LinkTypeRaw {
+		t.Errorf("interface 1 = %+v", i)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	w, err := Create(dir, "game/1", at, []Interface{{Name: "game"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "20240501T200000Z-game_1.pcapng")); err != nil {
+		t.Error(err)
+	}
+	if _, err := Create(dir, "game/1", at, []Interface{{Name: "game"}}); err == nil {
+		t.Error("Create overwrote an existing capture")
+	}
+	if _, err := NewWriter(&bytes.Buffer{}, nil); err == nil {
+		t.Error("NewWriter without interfaces succeeded")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+
+	"github.com/SergioFloresCorrea/lol-multipath/bench"
+)
+
+// Runs synthetic game traffic through the client and a local proxy over loopback and reports the latency
+// they add, the throughput and the allocations per packet.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	var opts bench.Options
+	fs.IntVar(&opts.Paths, "paths", 2, "paths every packet is sent over")
+	fs.Float64Var(&opts.Rate, "rate", 0, "packets per second, 0 sends as fast as the replies come back")
+	fs.IntVar(&opts.Window, "window", 32, "packets in flight when -rate is 0")
+	fs.IntVar(&opts.Size, "size", 100, "bytes per packet")
+	fs.DurationVar(&opts.Duration, "duration", 0, "how long to send for at most (default 10s, or no limit with -packets)")
+	fs.IntVar(&opts.Packets, "packets", 0, "packets to send at most")
+	fs.BoolVar(&opts.Auth, "auth", false, "authenticate the packets with a pre-shared key")
+	fs.BoolVar(&opts.Tunnel, "tunnel", false, "encrypt the packets")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := bench.Run(ctx, opts)
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	return res.Write(os.Stdout)
+}
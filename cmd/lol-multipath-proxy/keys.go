@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+)
+
+// Subcommands, by name.
+var commands = map[string]func(args []string) error{
+	"keygen": keygen,
+	"pair":   pair,
+	"rotate": rotate,
+	"revoke": revoke,
+	"bench":  runBench,
+}
+
+// Generates the static keypair of a proxy.
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "proxy.key", "file the private key is written to, pass it to -tunnel-key")
+	fs.Parse(args)
+
+	kp, err := tunnel.GenerateKeypair()
+	if err != nil {
+		return err
+	}
+	if err := tunnel.SaveKeypair(*out, kp); err != nil {
+		return err
+	}
+	fmt.Printf("Private key written to %s\nPublic key, give it to the clients: %x\n", *out, kp.Public)
+	return nil
+}
+
+// Flags shared by pair and rotate.
+type pairFlags struct {
+	name          *string
+	proxyKey      *string
+	proxyAddr     *string
+	outDir        *string
+	keyFile       *string
+	tunnelClients *string
+}
+
+func newPairFlags(fs *flag.FlagSet) pairFlags {
+	return pairFlags{
+		name:          fs.String("name", "", "(required) name of the client, shown in logs and stats"),
+		proxyKey:      fs.String("proxy-key", "", "private key file of the proxy (see keygen), its public key goes in the client snippet"),
+		proxyAddr:     fs.String("proxy-addr", "", "listen address of the proxy as the client reaches it (e.g. \"203.0.113.7:9029\")"),
+		outDir:        fs.String("out-dir", ".", "directory the client's key files are written to"),
+		keyFile:       fs.String("keyfile", "", "the proxy's -keyfile, the client's pre-shared key is added to it"),
+		tunnelClients: fs.String("tunnel-clients", "", "the proxy's -tunnel-clients, the client's public key is added to it"),
+	}
+}
+
+// Generates the keys of a new client: its pre-shared key and its tunnel keypair are written to
+// -out-dir for the client, and their proxy entries appended to the proxy's files, or printed.
+func pair(args []string) error {
+	fs := flag.NewFlagSet("pair", flag.ExitOnError)
+	f := newPairFlags(fs)
+	fs.Parse(args)
+	if *f.name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	_, err := f.issue()
+	return err
+}
+
+// Like pair, for a client that already has keys. Once the new keys are written, its other entries in the
+// proxy's files expire after -overlap, so it keeps working until it is restarted with the new keys.
+func rotate(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	f := newPairFlags(fs)
+	overlap := fs.Duration("overlap", 24*time.Hour, "how long the old keys are still accepted")
+	fs.Parse(args)
+	if *f.name == "" || (*f.keyFile == "" && *f.tunnelClients == "") {
+		slog.Error("-name and at least one of -keyfile and -tunnel-clients are required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	issued, err := f.issue()
+	if err != nil {
+		return err
+	}
+	until := time.Now().Add(*overlap).Truncate(time.Second)
+	for _, path := range []string{*f.keyFile, *f.tunnelClients} {
+		if path == "" {
+			continue
+		}
+		n, err := keys.Expire(path, *f.name, until, issued...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d keys of %s in %s expire at %s\n", n, *f.name, path, until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Removes a client from the proxy's files. A running proxy picks it up within -reload-interval.
+func revoke(args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	name := fs.String("name", "", "(required) name of the client")
+	keyFile := fs.String("keyfile", "", "the proxy's -keyfile")
+	tunnelClients := fs.String("tunnel-clients", "", "the proxy's -tunnel-clients")
+	fs.Parse(args)
+	if *name == "" || (*keyFile == "" && *tunnelClients == "") {
+		slog.Error("-name and at least one of -keyfile and -tunnel-clients are required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range []string{*keyFile, *tunnelClients} {
+		if path == "" {
+			continue
+		}
+		n, err := keys.Remove(path, *name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d keys of %s from %s\n", n, *name, path)
+	}
+	return nil
+}
+
+// Generates and hands out a new pre-shared key and tunnel keypair for the client. Returns the proxy
+// entries of both.
+func (f pairFlags) issue() ([]keys.Key, error) {
+	proxyPublic := "<proxy public key>"
+	if *f.proxyKey != "" {
+		proxyKp, err := tunnel.LoadKeypair(*f.proxyKey)
+		if err != nil {
+			return nil, err
+		}
+		proxyPublic = fmt.Sprintf("%x", proxyKp.Public)
+	}
+
+	psk, err := keys.Generate(*f.name)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := tunnel.GenerateKeypair()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(*f.outDir, 0o700); err != nil {
+		return nil, err
+	}
+	pskPath, keyPath, err := f.clientFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeNew(pskPath, psk.String()+"\n"); err != nil {
+		return nil, err
+	}
+	if err := tunnel.SaveKeypair(keyPath, kp); err != nil {
+		return nil, err
+	}
+
+	tunnelEntry := keys.Key{Name: *f.name, Secret: kp.Public[:]}
+	for _, entry := range []struct {
+		path string
+		key  keys.Key
+		what string
+	}{{*f.keyFile, psk, "-keyfile"}, {*f.tunnelClients, tunnelEntry, "-tunnel-clients"}} {
+		if entry.path == "" {
+			fmt.Printf("Add to the proxy's %s:\n  %s\n", entry.what, entry.key)
+			continue
+		}
+		if err := keys.Append(entry.path, entry.key); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Added %s to %s\n", *f.name, entry.path)
+	}
+
+	proxyAddr := *f.proxyAddr
+	if proxyAddr == "" {
+		proxyAddr = "<proxy listen address>"
+	}
+	fmt.Printf("Client key files written to %s and %s. Run the client with:\n  -keyfile %s -tunnel-key %s -proxy-public-keys %s=%s\n",
+		pskPath, keyPath, filepath.Base(pskPath), filepath.Base(keyPath), proxyAddr, proxyPublic)
+	return []keys.Key{psk, tunnelEntry}, nil
+}
+
+// Returns the paths of the client's key files, which must not exist yet.
+func (f pairFlags) clientFiles() (psk, key string, err error) {
+	psk = filepath.Join(*f.outDir, *f.name+".psk")
+	key = filepath.Join(*f.outDir, *f.name+".key")
+	for _, path := range []string{psk, key} {
+		if _, err := os.Stat(path); err == nil {
+			return "", "", fmt.Errorf("%s already exists, pick another -out-dir", path)
+		}
+	}
+	return psk, key, nil
+}
+
+// Writes `content` to a new file readable only by its owner.
+func writeNew(path, content string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
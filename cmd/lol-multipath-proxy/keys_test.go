@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+)
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "clients.psk")
+	if err := pair([]string{"-name", "alice", "-out-dir", filepath.Join(dir, "alice"), "-keyfile", keyFile}); err != nil {
+		t.Fatalf("pair: %v", err)
+	}
+	before, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// alice's key files are already in -out-dir: nothing is issued and her keys don't expire
+	if err := rotate([]string{"-name", "alice", "-out-dir", filepath.Join(dir, "alice"), "-keyfile", keyFile}); err == nil {
+		t.Fatalf("rotate into an -out-dir with alice's keys succeeded")
+	}
+	if after, _ := os.ReadFile(keyFile); string(after) != string(before) {
+		t.Errorf("keyfile after a failed rotation = %q; want %q", after, before)
+	}
+
+	if err := rotate([]string{"-name", "alice", "-out-dir", filepath.Join(dir, "alice-2"), "-keyfile", keyFile}); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	got, err := keys.LoadFile(keyFile)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("LoadFile = %+v, %v", got, err)
+	}
+	if got[0].Expires.IsZero() || !got[1].Expires.IsZero() {
+		t.Errorf("keys after rotation = %+v; want the old one expiring and the new one not", got)
+	}
+}
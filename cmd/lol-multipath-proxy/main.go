@@ -0,0 +1,262 @@
+// Command lol-multipath-proxy runs a standalone proxy for the multipath client, meant to be deployed
+// as a long-running service on a VPS close to Riot's game servers.
+//
+// It also manages the keys of the clients: keygen, pair, rotate and revoke, and measures the client and
+// the proxy under a synthetic load over loopback: bench. Run one with -h for its flags.
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/proxy"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fatal("command failed", "command", os.Args[1], logging.KeyError, err)
+			}
+			return
+		}
+	}
+
+	listenAddr := flag.String("listen-addr", "", "(required) UDP address game packets are relayed through (e.g. \":9029\")")
+	pingListenAddr := flag.String("ping-listen-addr", "", "(required) UDP address pings are answered on (e.g. \":10001\")")
+	servers := flag.String("servers", "", "(required) comma-separated league servers this proxy serves, the first one is the default (e.g. \"NA,LAN\")")
+	upstreamTargetsCSV := flag.String("upstream-targets", "", "comma-separated per-server overrides of how the latency to the game server is measured, the scheme picks the probe: http(s), tcp, udp or icmp (e.g. \"NA=tcp://192.0.2.10:5100\")")
+	upstreamInterval := flag.Duration("upstream-interval", 5*time.Second, "interval at which the upstream latency of every server is sampled")
+	upstreamSamples := flag.Int("upstream-samples", 5, "number of upstream samples the reported latency is the median of")
+	cleanupInterval := flag.Duration("cleanup-interval", 1*time.Second, "how long to wait before cleaning the packet cache involved in the deduplicating package process")
+	maxPacketSize := flag.Int("max-packet-size", 4096, "datagrams bigger than this many bytes are dropped")
+	maxSessions := flag.Int("max-sessions", 64, "maximum number of simultaneous sessions")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "sessions without traffic for this long are closed")
+	logFile := flag.String("log-file", "", "append logs to this file instead of stderr")
+	captureDir := flag.String("capture-dir", "", "directory a pcapng capture of every session is written to, with an interface per client path")
+	keyFile := flag.String("keyfile", "", "file with the pre-shared keys of the authorized clients; every packet must then be authenticated with one of them")
+	maxClockSkew := flag.Duration("max-clock-skew", 1*time.Minute, "authenticated packets sealed further than this from the proxy's clock are rejected")
+	tunnelKeyFile := flag.String("tunnel-key", "", "file with the hex-encoded private key of the proxy; enables the encrypted tunnel from the clients, see -tunnel-clients; what is sent back to them isn't encrypted")
+	tunnelClientsFile := flag.String("tunnel-clients", "", "keyfile with the hex-encoded public keys of the clients allowed to open an encrypted tunnel")
+	reloadInterval := flag.Duration("reload-interval", 10*time.Second, "how often -keyfile and -tunnel-clients are checked for changes, 0 disables reloading")
+	gameProfiles := flag.String("game-profiles", "lol", "comma-separated game profiles whose servers sessions may relay to, built-in or from -destinations; \"any\" allows every destination")
+	destinationsFile := flag.String("destinations", "", "file with extra or replacement game profiles, one \"<profile> <cidr> <port or range>\" per line")
+	maxDestinations := flag.Int("max-destinations", 4, "distinct game servers a single client may have sessions with at once")
+	clientPPS := flag.Float64("client-pps", 1000, "packets per second a single client may send, 0 is unlimited")
+	clientBPS := flag.Float64("client-bps", 1<<20, "bytes per second a single client may send, 0 is unlimited")
+	sessionPPS := flag.Float64("session-pps", 500, "packets per second relayed for a single session, 0 is unlimited")
+	sessionBPS := flag.Float64("session-bps", 512<<10, "bytes per second relayed for a single session, 0 is unlimited")
+	globalPPS := flag.Float64("global-pps", 0, "packets per second the proxy accepts in total, 0 is unlimited")
+	globalBPS := flag.Float64("global-bps", 0, "bytes per second the proxy accepts in total, 0 is unlimited")
+	banAfter := flag.Int("ban-after", 50, "source IPs exceeding a limit or failing authentication this many times within -ban-window are banned, 0 never bans")
+	banWindow := flag.Duration("ban-window", 10*time.Second, "window the strikes of -ban-after are counted over")
+	banDuration := flag.Duration("ban-duration", 5*time.Minute, "how long a ban lasts")
+	adminAddr := flag.String("admin-addr", "", "TCP address /healthz, /readyz, the JSON /stats and /metrics are served on (e.g. \"127.0.0.1:9100\"), disabled by default")
+	pingWorkers := flag.Int("ping-workers", 4, "pings answered concurrently")
+	gameAddr := flag.String("game-addr", "", "static session: Riot's game server address (IP:PORT)")
+	clientAddr := flag.String("client-addr", "", "static session: address the game client listens on (IP:PORT)")
+	clientIPs := flag.String("client-ips", "", "static session: comma-separated IPs the client sends from (default: any)")
+	logFlags := logging.RegisterFlags(flag.CommandLine, logging.RedactNone)
+
+	flag.Parse()
+
+	if *listenAddr == "" || *pingListenAddr == "" || *servers == "" {
+		slog.Error("-listen-addr, -ping-listen-addr and -servers are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logOut := os.Stderr
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			fatal("failed to open log file", logging.KeyError, err)
+		}
+		defer f.Close()
+		logOut = f
+	}
+	logger, err := logFlags.Logger(logOut)
+	if err != nil {
+		fatal("invalid logging flags", logging.KeyError, err)
+	}
+	slog.SetDefault(logger)
+
+	upstreamTargets, err := proxy.ParseUpstreamTargets(*upstreamTargetsCSV)
+	if err != nil {
+		fatal("invalid -upstream-targets", logging.KeyError, err)
+	}
+
+	var ring *keys.Ring
+	if *keyFile != "" {
+		authorized, err := keys.LoadFile(*keyFile)
+		if err != nil {
+			fatal("failed to load keys", logging.KeyError, err)
+		}
+		ring = keys.NewRing(authorized)
+		slog.Info("authenticating packets", "clients", ring.Len())
+	}
+
+	var tunnelKey *tunnel.Keypair
+	var tunnelClients *keys.Ring
+	if *tunnelKeyFile != "" {
+		kp, err := tunnel.LoadKeypair(*tunnelKeyFile)
+		if err != nil {
+			fatal("failed to load the tunnel key", logging.KeyError, err)
+		}
+		tunnelKey = &kp
+		if *tunnelClientsFile == "" {
+			fatal("-tunnel-key needs -tunnel-clients")
+		}
+		authorized, err := keys.LoadFile(*tunnelClientsFile)
+		if err != nil {
+			fatal("failed to load the tunnel clients", logging.KeyError, err)
+		}
+		tunnelClients = keys.NewRing(authorized)
+		slog.Info("accepting encrypted tunnels", "clients", tunnelClients.Len())
+	}
+
+	profiles := maps.Clone(proxy.GameProfiles)
+	if *destinationsFile != "" {
+		loaded, err := proxy.LoadDestinations(*destinationsFile)
+		if err != nil {
+			fatal("failed to load destinations", logging.KeyError, err)
+		}
+		maps.Copy(profiles, loaded)
+	}
+	var destinations []proxy.DestinationRule
+	for _, name := range strings.Split(*gameProfiles, ",") {
+		if name = strings.TrimSpace(name); name == "any" {
+			destinations = nil
+			slog.Warn("sessions may relay to any destination, anyone allowed to open one can use this proxy as a UDP reflector")
+			break
+		}
+		rules, ok := profiles[name]
+		if !ok {
+			fatal("unknown game profile", "profile", name)
+		}
+		destinations = append(destinations, rules...)
+	}
+
+	limits := proxy.Limits{
+		Client:      proxy.Rate{Packets: *clientPPS, Bytes: *clientBPS},
+		Session:     proxy.Rate{Packets: *sessionPPS, Bytes: *sessionBPS},
+		Global:      proxy.Rate{Packets: *globalPPS, Bytes: *globalBPS},
+		BanAfter:    *banAfter,
+		BanWindow:   *banWindow,
+		BanDuration: *banDuration,
+	}
+
+	var regions []string
+	for _, server := range strings.Split(*servers, ",") {
+		regions = append(regions, strings.ToUpper(strings.TrimSpace(server)))
+	}
+
+	srv, err := proxy.NewServer(proxy.Options{
+		ListenAddr:       *listenAddr,
+		PingListenAddr:   *pingListenAddr,
+		Regions:          regions,
+		UpstreamTargets:  upstreamTargets,
+		UpstreamInterval: *upstreamInterval,
+		UpstreamSamples:  *upstreamSamples,
+		CleanupInterval:  *cleanupInterval,
+		MaxPacketSize:    *maxPacketSize,
+		MaxSessions:      *maxSessions,
+		IdleTimeout:      *idleTimeout,
+		Keys:             ring,
+		MaxClockSkew:     *maxClockSkew,
+		TunnelKey:        tunnelKey,
+		TunnelClients:    tunnelClients,
+		Destinations:     destinations,
+		MaxDestinations:  *maxDestinations,
+		Limits:           limits,
+		PingWorkers:      *pingWorkers,
+		AdminAddr:        *adminAddr,
+		Logger:           logger,
+		CaptureDir:       *captureDir,
+	})
+	if err != nil {
+		fatal("invalid options", logging.KeyError, err)
+	}
+
+	if err := srv.Listen(); err != nil {
+		fatal("failed to listen", logging.KeyError, err)
+	}
+
+	if (*gameAddr == "") != (*clientAddr == "") {
+		fatal("-game-addr and -client-addr must be given together")
+	}
+	if *gameAddr != "" {
+		game, err := net.ResolveUDPAddr("udp", *gameAddr)
+		if err != nil {
+			fatal("invalid -game-addr", logging.KeyError, err)
+		}
+		client, err := net.ResolveUDPAddr("udp", *clientAddr)
+		if err != nil {
+			fatal("invalid -client-addr", logging.KeyError, err)
+		}
+		var ips []net.IP
+		for _, s := range strings.Split(*clientIPs, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			ip := net.ParseIP(s)
+			if ip == nil {
+				fatal("invalid -client-ips entry", "entry", s)
+			}
+			ips = append(ips, ip)
+		}
+		if err := srv.OpenSession(proxy.Session{ID: "static", GameAddr: game, ClientAddr: client, ClientIPs: ips}); err != nil {
+			fatal("failed to open the static session", logging.KeyError, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *reloadInterval > 0 {
+		for _, watched := range []struct {
+			path string
+			ring *keys.Ring
+		}{{*keyFile, ring}, {*tunnelClientsFile, tunnelClients}} {
+			if watched.ring == nil {
+				continue
+			}
+			keys.Watch(ctx, watched.path, *reloadInterval, watched.ring, func(n int, err error) {
+				if err != nil {
+					slog.Warn("failed to reload keys, keeping the previous ones", "keyfile", watched.path, logging.KeyError, err)
+					return
+				}
+				slog.Info("reloaded keys", "keys", n, "keyfile", watched.path)
+			})
+		}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		slog.Info("interrupt received, shutting down…")
+		cancel()
+	}()
+
+	if err := srv.Serve(ctx); err != nil {
+		fatal("proxy failed", logging.KeyError, err)
+	}
+}
+
+// Logs `msg` as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
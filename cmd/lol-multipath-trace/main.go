@@ -0,0 +1,86 @@
+// Command lol-multipath-trace summarizes the session traces the client records with -trace-dir: the packets,
+// the paths they went over, the write errors, pings, probes and selections.
+//
+//	lol-multipath-trace [-records] TRACE...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/trace"
+)
+
+func main() {
+	listRecords := flag.Bool("records", false, "also list every record, one per line")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-records] TRACE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for i, path := range flag.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("== %s\n", path)
+		records, err := trace.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		}
+		if *listRecords {
+			for _, r := range records {
+				fmt.Println(describe(r))
+			}
+			fmt.Println()
+		}
+		if err := trace.Summarize(records).Format(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Returns a line describing `r`.
+func describe(r trace.Record) string {
+	at := r.Time().Format("15:04:05.000000")
+	switch {
+	case r.Session != nil:
+		return fmt.Sprintf("%s session %s, game server %s, %d paths", at, r.Session.ID, r.Session.GameAddr, len(r.Session.Paths))
+	case r.Packet != nil:
+		line := fmt.Sprintf("%s packet %dB sent on %v", at, r.Packet.Size, r.Packet.Sent)
+		for _, e := range r.Packet.Errors {
+			line += fmt.Sprintf(", path %d failed: %s", e.Path, e.Err)
+		}
+		return line
+	case r.Ping != nil:
+		if r.Ping.Lost {
+			return fmt.Sprintf("%s ping path %d lost: %s", at, r.Ping.Path, r.Ping.Err)
+		}
+		return fmt.Sprintf("%s ping path %d %dms (rtt %s, upstream %s)", at, r.Ping.Path, r.Ping.PingMs,
+			time.Duration(r.Ping.RTTUs)*time.Microsecond, time.Duration(r.Ping.UpstreamUs)*time.Microsecond)
+	case r.Probe != nil:
+		if r.Probe.Up {
+			return fmt.Sprintf("%s probe path %d up", at, r.Probe.Path)
+		}
+		return fmt.Sprintf("%s probe path %d still down: %s", at, r.Probe.Path, r.Probe.Err)
+	case r.Selection != nil:
+		changed := ""
+		if r.Selection.Changed {
+			changed = " (changed)"
+		}
+		return fmt.Sprintf("%s selection candidates %v active %v%s", at, r.Selection.Candidates, r.Selection.Active, changed)
+	}
+	return at + " unknown record"
+}
@@ -1,6 +1,7 @@
 package connection
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -28,15 +29,23 @@ func GetUDPConnection(interval time.Duration) (ConnectionUDP, error) {
 		return ConnectionUDP{}, fmt.Errorf("powershell failed: %v\noutput: %s", err, output)
 	}
 
-	if len(output) > 0 {
-		var endpoint ConnectionUDP
-		if err := json.Unmarshal(output, &endpoint); err != nil {
-			return ConnectionUDP{}, fmt.Errorf("couldn't find the process %v", leagueProcessName)
-		}
-		return endpoint, nil
-	}
+	return decodeUDPEndpoint(output)
+}
 
-	return ConnectionUDP{}, fmt.Errorf("couldn't find any connection")
+// Decodes the endpoint printed by the powershell command of GetUDPConnection. The port ends up in
+// a capture filter and in the session, so it must be a valid one.
+func decodeUDPEndpoint(output []byte) (ConnectionUDP, error) {
+	if len(bytes.TrimSpace(output)) == 0 {
+		return ConnectionUDP{}, fmt.Errorf("couldn't find any connection")
+	}
+	var endpoint ConnectionUDP
+	if err := json.Unmarshal(output, &endpoint); err != nil {
+		return ConnectionUDP{}, fmt.Errorf("couldn't find the process %v", leagueProcessName)
+	}
+	if endpoint.LocalPort <= 0 || endpoint.LocalPort > 65535 {
+		return ConnectionUDP{}, fmt.Errorf("invalid port %d for the process %v", endpoint.LocalPort, leagueProcessName)
+	}
+	return endpoint, nil
 }
 
 func CheckIfLeagueIsActive() bool {
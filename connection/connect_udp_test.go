@@ -0,0 +1,29 @@
+package connection
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func FuzzDecodeUDPEndpoint(f *testing.F) {
+	f.Add([]byte("{\r\n    \"LocalAddress\":  \"0.0.0.0\",\r\n    \"LocalPort\":  52011\r\n}\r\n"))
+	f.Add([]byte(`{"LocalAddress": "::", "LocalPort": 0}`))
+	f.Add([]byte(`[{"LocalAddress": "0.0.0.0", "LocalPort": 52011}, {"LocalAddress": "::", "LocalPort": 52012}]`))
+	f.Add([]byte("\r\n"))
+	f.Fuzz(func(t *testing.T, output []byte) {
+		endpoint, err := decodeUDPEndpoint(output)
+		if err != nil {
+			return
+		}
+		if endpoint.LocalPort <= 0 || endpoint.LocalPort > 65535 {
+			t.Fatalf("decoded %q with port %d", output, endpoint.LocalPort)
+		}
+		b, err := json.Marshal(endpoint)
+		if err != nil {
+			t.Fatalf("Marshal(%+v): %v", endpoint, err)
+		}
+		if again, err := decodeUDPEndpoint(b); err != nil || again != endpoint {
+			t.Fatalf("re-encoded %+v as %s, decoded %+v, %v", endpoint, b, again, err)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package connection
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"strings"
 
@@ -52,7 +53,7 @@ func GetRiotUDPAddressAndPort(port int, localIPv4 []net.IP) (string, string, err
 
 outer:
 	for index, device := range possibleDevices {
-		fmt.Printf("Using interface: %s\n", device)
+		slog.Debug("capturing on device", "device", device)
 		handle, err := pcap.OpenLive(device, 1024*64, false, timeout)
 		if err != nil {
 			return "", "", fmt.Errorf("failed to open device: %w", err)
@@ -68,7 +69,7 @@ outer:
 				switch err {
 				case pcap.NextErrorTimeoutExpired:
 					handle.Close()
-					fmt.Printf("Timeout on %s, trying next device\n", device)
+					slog.Debug("capture timed out, trying the next device", "device", device)
 					continue outer
 				case io.EOF:
 					handle.Close()
@@ -88,7 +89,7 @@ outer:
 				udp := udpLayer.(*layers.UDP)
 				dstPort := strings.Split(udp.DstPort.String(), "(")[0]
 				result := fmt.Sprintf("%s:%s", dstIP, dstPort)
-				fmt.Printf("Captured destination: %s->%s\n", origIP, result)
+				slog.Info("captured the game's destination", "src", origIP, "game", result)
 				return result, possibleIPs[index], nil
 			}
 		}
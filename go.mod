@@ -13,5 +13,7 @@ require golang.zx2c4.com/wireguard/windows v0.5.3 // indirect
 require (
 	github.com/cespare/xxhash v1.1.0
 	github.com/lysShub/divert-go v0.0.0-20250418062248-28e4462def61
-	golang.org/x/sys v0.16.0
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.38.0
+	golang.org/x/sys v0.31.0
 )
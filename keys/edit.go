@@ -0,0 +1,167 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Generates a key with a random 32-byte secret.
+func Generate(name string) (Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+	return Key{Name: name, Secret: secret}, nil
+}
+
+// Appends `key` to the keyfile at `path`, creating it if needed.
+func Append(path string, key Key) error {
+	if strings.ContainsAny(key.Name, " \t\n#") || key.Name == "" {
+		return fmt.Errorf("invalid key name %q", key.Name)
+	}
+	existing, err := LoadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, other := range existing {
+		if other.ID() == key.ID() {
+			return fmt.Errorf("%s: same secret as %s", path, other.Name)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, key); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Makes the keys of `name` in the keyfile at `path` expire at `at`, unless they expire sooner or are one
+// of `keep`, e.g. the keys replacing them. Returns how many keys were changed.
+func Expire(path, name string, at time.Time, keep ...Key) (int, error) {
+	changed := 0
+	_, err := rewrite(path, name, func(key Key) (Key, bool) {
+		for _, k := range keep {
+			if k.ID() == key.ID() {
+				return key, true
+			}
+		}
+		if key.Expires.IsZero() || key.Expires.After(at) {
+			key.Expires = at
+			changed++
+		}
+		return key, true
+	})
+	return changed, err
+}
+
+// Removes the keys of `name` from the keyfile at `path`. Returns how many were removed.
+func Remove(path, name string) (int, error) {
+	return rewrite(path, name, func(Key) (Key, bool) { return Key{}, false })
+}
+
+// Rewrites the keyfile at `path`, passing the keys of `name` through `edit`, which returns the new key
+// and whether to keep it. Comments and other keys are left untouched. The file is replaced atomically,
+// so a proxy reloading it never sees half of it.
+func rewrite(path, name string, edit func(Key) (Key, bool)) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := Parse(bytes.NewReader(data)); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var out strings.Builder
+	changed := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			out.WriteString(line)
+			continue
+		}
+		key, _ := parseLine(text)
+		if key.Name != name {
+			out.WriteString(line)
+			continue
+		}
+		changed++
+		if key, keep := edit(key); keep {
+			out.WriteString(key.String() + "\n")
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(out.String()); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return changed, os.Rename(tmp.Name(), path)
+}
+
+// Reloads the keyfile at `path` into `ring` in the background whenever its contents change from what
+// they are now, checking every `interval` until ctx is done. A file that fails to load leaves the ring
+// as is. `report` is called after every reload with the number of keys loaded or the error.
+func Watch(ctx context.Context, path string, interval time.Duration, ring *Ring, report func(n int, err error)) {
+	last, _ := os.ReadFile(path)
+	go watch(ctx, path, interval, ring, report, last)
+}
+
+func watch(ctx context.Context, path string, interval time.Duration, ring *Ring, report func(n int, err error), last []byte) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if last != nil {
+				report(0, err) // once, not at every tick until it's back
+			}
+			last = nil
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		keys, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			report(0, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		ring.Replace(keys)
+		report(len(keys), nil)
+	}
+}
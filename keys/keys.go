@@ -0,0 +1,161 @@
+// Package keys loads the pre-shared keys clients authenticate their packets to the proxies with.
+//
+// A keyfile has one key per line, a name followed by the hex-encoded secret:
+//
+//	# comments and blank lines are ignored
+//	alice 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	bob   5feceb66ffc86f38d952786c6d696c79c2dbc239dd4e91b46729d73a27fb57e9 expires=2026-11-01T00:00:00Z
+//
+// The proxy accepts every key in its keyfile until it expires; the client signs with the first key of its own.
+// Keys being rotated out get an expiry, so the old and the new key of a client are both accepted for a while.
+package keys
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+// MinSecretSize is the shortest secret accepted.
+const MinSecretSize = 16
+
+const expiresPrefix = "expires="
+
+// Key is a pre-shared key.
+type Key struct {
+	Name    string // who the key belongs to, only used in logs and stats
+	Secret  []byte
+	Expires time.Time // the key isn't accepted from then on; zero never expires
+}
+
+// Reports whether the key has expired at `now`.
+func (k Key) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// Returns the key as a keyfile line.
+func (k Key) String() string {
+	line := k.Name + " " + hex.EncodeToString(k.Secret)
+	if !k.Expires.IsZero() {
+		line += " " + expiresPrefix + k.Expires.UTC().Format(time.RFC3339)
+	}
+	return line
+}
+
+// Returns the ID the key is sent as.
+func (k Key) ID() protocol.KeyID {
+	return protocol.KeyIDOf(k.Secret)
+}
+
+// Parses keys in the keyfile format.
+func Parse(r io.Reader) ([]Key, error) {
+	var keys []Key
+	seen := make(map[protocol.KeyID]string)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := parseLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if other, ok := seen[key.ID()]; ok {
+			return nil, fmt.Errorf("line %d: same secret as %s", line, other)
+		}
+		seen[key.ID()] = key.Name
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+func parseLine(text string) (Key, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 && len(fields) != 3 {
+		return Key{}, fmt.Errorf("want \"<name> <hex secret> [expires=<RFC 3339 time>]\"")
+	}
+	secret, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return Key{}, err
+	}
+	if len(secret) < MinSecretSize {
+		return Key{}, fmt.Errorf("the secret must be at least %d bytes", MinSecretSize)
+	}
+	key := Key{Name: fields[0], Secret: secret}
+	if len(fields) == 3 {
+		expires, ok := strings.CutPrefix(fields[2], expiresPrefix)
+		if !ok {
+			return Key{}, fmt.Errorf("unknown field %q", fields[2])
+		}
+		if key.Expires, err = time.Parse(time.RFC3339, expires); err != nil {
+			return Key{}, err
+		}
+	}
+	return key, nil
+}
+
+// Loads the keys of the keyfile at `path`.
+func LoadFile(path string) ([]Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// Ring is a set of keys looked up by ID. It is safe for concurrent use.
+type Ring struct {
+	mu   sync.RWMutex
+	byID map[protocol.KeyID]Key
+}
+
+// Creates a ring holding `keys`.
+func NewRing(keys []Key) *Ring {
+	r := &Ring{}
+	r.Replace(keys)
+	return r
+}
+
+// Returns the key with ID `id`, unless it has expired.
+func (r *Ring) Lookup(id protocol.KeyID) (Key, bool) {
+	r.mu.RLock()
+	key, ok := r.byID[id]
+	r.mu.RUnlock()
+	if !ok || key.Expired(time.Now()) {
+		return Key{}, false
+	}
+	return key, true
+}
+
+// Returns how many keys the ring holds.
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byID)
+}
+
+// Replaces every key of the ring with `keys`.
+func (r *Ring) Replace(keys []Key) {
+	byID := make(map[protocol.KeyID]Key, len(keys))
+	for _, key := range keys {
+		byID[key.ID()] = key
+	}
+	r.mu.Lock()
+	r.byID = byID
+	r.mu.Unlock()
+}
@@ -0,0 +1,163 @@
+package keys
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secretA = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+const secretB = "ffeeddccbbaa99887766554433221100"
+
+func TestParse(t *testing.T) {
+	keys, err := Parse(strings.NewReader("# authorized clients\n\nalice " + secretA + "\n  bob\t" + secretB + "  \n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(keys) != 2 || keys[0].Name != "alice" || keys[1].Name != "bob" || len(keys[0].Secret) != 32 {
+		t.Fatalf("keys = %+v", keys)
+	}
+
+	ring := NewRing(keys)
+	if got, ok := ring.Lookup(keys[1].ID()); !ok || got.Name != "bob" {
+		t.Errorf("Lookup(bob) = %+v, %v", got, ok)
+	}
+	ring.Replace(keys[:1])
+	if _, ok := ring.Lookup(keys[1].ID()); ok || ring.Len() != 1 {
+		t.Errorf("bob is still in the ring after Replace")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing secret": "alice\n",
+		"extra field":    "alice " + secretA + " extra\n",
+		"not hex":        "alice zz\n",
+		"short secret":   "alice 0011\n",
+		"duplicate":      "alice " + secretA + "\nbob " + secretA + "\n",
+	}
+	for name, input := range tests {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("alice "+secretA+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadFile(path)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("LoadFile = %+v, %v", keys, err)
+	}
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestExpires(t *testing.T) {
+	keys, err := Parse(strings.NewReader("alice " + secretA + " expires=2026-01-02T03:04:05Z\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if !keys[0].Expires.Equal(want) || !keys[0].Expired(want) || keys[0].Expired(want.Add(-time.Second)) {
+		t.Errorf("Expires = %v; want %v", keys[0].Expires, want)
+	}
+	if got := keys[0].String(); got != "alice "+secretA+" expires=2026-01-02T03:04:05Z" {
+		t.Errorf("String() = %q", got)
+	}
+
+	ring := NewRing([]Key{{Name: "old", Secret: []byte(secretA[:32]), Expires: time.Now().Add(-time.Second)}})
+	if _, ok := ring.Lookup(Key{Secret: []byte(secretA[:32])}.ID()); ok {
+		t.Errorf("Lookup returned an expired key")
+	}
+	if _, err := Parse(strings.NewReader("alice " + secretA + " expires=tomorrow\n")); err == nil {
+		t.Errorf("expected an error for a bad expiry")
+	}
+}
+
+func TestRotateAndRevoke(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# authorized clients\nalice "+secretA+"\nbob "+secretB+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := Generate("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(path, fresh); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := Append(path, fresh); err == nil {
+		t.Errorf("appending the same secret twice should fail")
+	}
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if n, err := Expire(path, "alice", until, fresh); err != nil || n != 1 {
+		t.Fatalf("Expire = %d, %v", n, err)
+	}
+	// already expiring sooner
+	if n, err := Expire(path, "alice", until.Add(time.Hour), fresh); err != nil || n != 0 {
+		t.Fatalf("Expire again = %d, %v", n, err)
+	}
+
+	keys, err := LoadFile(path)
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("LoadFile = %+v, %v", keys, err)
+	}
+	if !keys[0].Expires.Equal(until) || !keys[2].Expires.IsZero() || keys[2].Name != "alice" {
+		t.Errorf("keys after rotation = %+v", keys)
+	}
+
+	if n, err := Remove(path, "alice"); err != nil || n != 2 {
+		t.Fatalf("Remove = %d, %v", n, err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "# authorized clients\nbob "+secretB+"\n" {
+		t.Errorf("keyfile after Remove = %q", data)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("alice "+secretA+"\nbob "+secretB+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := NewRing(keys)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan error, 10)
+	Watch(ctx, path, 10*time.Millisecond, ring, func(n int, err error) { reloads <- err })
+
+	if _, err := Remove(path, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-reloads; err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := ring.Lookup(keys[1].ID()); ok || ring.Len() != 1 {
+		t.Errorf("bob is still accepted after being removed")
+	}
+
+	// a broken file keeps the last good keys
+	if err := os.WriteFile(path, []byte("alice\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-reloads; err == nil {
+		t.Errorf("expected a reload error")
+	}
+	if _, ok := ring.Lookup(keys[0].ID()); !ok {
+		t.Errorf("alice was dropped by a broken reload")
+	}
+}
@@ -0,0 +1,256 @@
+// Package logging sets up the log/slog loggers of the client and the proxy: their level, their text or JSON
+// output and the redaction of the IP addresses they log, so logs can be shared safely.
+//
+// Redaction is applied by the handler to every logged value that is or holds an IP address: net.IP,
+// netip.Addr, netip.AddrPort, net.Addr, []net.IP, and the IPv4 addresses found in strings, errors and
+// messages. IPv6 addresses are only recognized when they are a whole value. Ports are kept.
+//
+// Log records use the same keys for the same things across packages, see the Key constants.
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Keys of the attributes shared across packages.
+const (
+	KeySession   = "session"   // ID of a game session
+	KeyPath      = "path"      // ID of a client path, as in the client's status API
+	KeyProxy     = "proxy"     // proxy listen address
+	KeyInterface = "interface" // local address a path sends from
+	KeyClient    = "client"    // name of an authenticated client, or its source address
+	KeyError     = "err"
+)
+
+// Redaction is how IP addresses are logged.
+type Redaction string
+
+const (
+	RedactNone Redaction = "none" // as is
+	RedactMask Redaction = "mask" // the last octet of IPv4 addresses and the last 64 bits of IPv6 ones replaced by x
+	RedactHash Redaction = "hash" // replaced by a keyed hash, the same address gets the same hash within a log
+)
+
+// Parses a redaction policy name.
+func ParseRedaction(s string) (Redaction, error) {
+	switch r := Redaction(strings.ToLower(s)); r {
+	case RedactNone, RedactMask, RedactHash:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown redaction %q, want none, mask or hash", s)
+}
+
+// Options configures New.
+type Options struct {
+	Level     slog.Leveler
+	JSON      bool // JSON lines instead of key=value text
+	Redaction Redaction
+	HashKey   []byte // key of RedactHash; nil picks a random one, so hashes can't be matched across runs
+}
+
+// Creates a logger writing to `w` as configured by opts.
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	redactor, err := NewRedactor(opts.Redaction, opts.HashKey)
+	if err != nil {
+		return nil, err
+	}
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level, ReplaceAttr: redactor.ReplaceAttr}
+	if opts.JSON {
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	}
+	return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+}
+
+// Parses a level name: debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// Redactor applies a redaction policy to log values.
+type Redactor struct {
+	policy Redaction
+	key    []byte
+}
+
+// Creates a redactor applying `policy`. A nil key for RedactHash picks a random one.
+func NewRedactor(policy Redaction, key []byte) (*Redactor, error) {
+	if policy == "" {
+		policy = RedactNone
+	}
+	if _, err := ParseRedaction(string(policy)); err != nil {
+		return nil, err
+	}
+	if policy == RedactHash && key == nil {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Redactor{policy: policy, key: key}, nil
+}
+
+// Redacts an address.
+func (r *Redactor) Addr(addr netip.Addr) string {
+	addr = addr.Unmap()
+	switch r.policy {
+	case RedactMask:
+		if addr.Is4() {
+			b := addr.As4()
+			return fmt.Sprintf("%d.%d.%d.x", b[0], b[1], b[2])
+		}
+		prefix := netip.PrefixFrom(addr, 64).Masked().Addr().String()
+		return strings.TrimSuffix(prefix, "::") + "::x"
+	case RedactHash:
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write(addr.AsSlice())
+		return "ip-" + hex.EncodeToString(mac.Sum(nil)[:6])
+	}
+	return addr.String()
+}
+
+// Runs of digits and dots long enough to hold an IPv4 address. Word boundaries aren't required, so
+// "port1.2.3.4" and "v192.0.2.1" are redacted too.
+var ipv4Pattern = regexp.MustCompile(`[0-9.]{7,}`)
+
+// Redacts the addresses in `s`: all of it if it's an address or an address and port, the IPv4 addresses in it otherwise.
+func (r *Redactor) Text(s string) string {
+	if r.policy == RedactNone {
+		return s
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return r.Addr(addr)
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		if ap.Addr().Is6() && !ap.Addr().Is4In6() {
+			return fmt.Sprintf("[%s]:%d", r.Addr(ap.Addr()), ap.Port())
+		}
+		return fmt.Sprintf("%s:%d", r.Addr(ap.Addr()), ap.Port())
+	}
+	return ipv4Pattern.ReplaceAllStringFunc(s, r.run)
+}
+
+// Redacts the IPv4 addresses in a run of digits and dots, wherever they start: both addresses in
+// "1.2.3.4.5.6.7.8" and the one in "999.1.2.3.4".
+func (r *Redactor) run(s string) string {
+	fields := strings.Split(s, ".")
+	var out []string
+	for i := 0; i < len(fields); i++ {
+		if i+4 <= len(fields) {
+			if addr, err := netip.ParseAddr(strings.Join(fields[i:i+4], ".")); err == nil {
+				out = append(out, r.Addr(addr))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, fields[i])
+	}
+	return strings.Join(out, ".")
+}
+
+// Redacts the value of `a` if it is or holds an address, to be used as slog.HandlerOptions.ReplaceAttr.
+func (r *Redactor) ReplaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if r.policy == RedactNone || (len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey)) {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.Text(v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case net.IP:
+			a.Value = slog.StringValue(r.Text(x.String()))
+		case []net.IP:
+			ips := make([]string, len(x))
+			for i, ip := range x {
+				ips[i] = r.Text(ip.String())
+			}
+			a.Value = slog.AnyValue(ips)
+		case netip.Addr:
+			a.Value = slog.StringValue(r.Addr(x))
+		case netip.AddrPort:
+			a.Value = slog.StringValue(r.Text(x.String()))
+		case net.Addr:
+			a.Value = slog.StringValue(r.Text(x.String()))
+		case error:
+			a.Value = slog.StringValue(r.Text(x.Error()))
+		}
+	}
+	return a
+}
+
+// Writer forwards writes to another writer that can be replaced while loggers write to it, e.g. to show the
+// log somewhere else while a dashboard owns the terminal.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Creates a writer forwarding to `w`.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Forwards the writes to `w` from now on; returns the writer they went to.
+func (w *Writer) Set(to io.Writer) io.Writer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prev := w.w
+	w.w = to
+	return prev
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// Flags are the command-line flags configuring a logger, see RegisterFlags.
+type Flags struct {
+	level, format, redact, hashKey *string
+}
+
+// Registers -log-level, -log-format, -log-redact and -log-hash-key on `fs`.
+func RegisterFlags(fs *flag.FlagSet, redaction Redaction) *Flags {
+	return &Flags{
+		level:   fs.String("log-level", "info", "minimum level of the logged messages: debug, info, warn or error"),
+		format:  fs.String("log-format", "text", "log format: text or json"),
+		redact:  fs.String("log-redact", string(redaction), "how IP addresses are logged: none, mask (192.0.2.x) or hash (a keyed hash, the same address gets the same hash)"),
+		hashKey: fs.String("log-hash-key", "", "key of -log-redact hash, to match hashes across runs; random if empty"),
+	}
+}
+
+// Creates the logger the flags configure, writing to `w`.
+func (f *Flags) Logger(w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(*f.level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -log-level: %w", err)
+	}
+	if *f.format != "text" && *f.format != "json" {
+		return nil, fmt.Errorf("invalid -log-format %q, want text or json", *f.format)
+	}
+	redaction, err := ParseRedaction(*f.redact)
+	if err != nil {
+		return nil, err
+	}
+	opts := Options{Level: level, JSON: *f.format == "json", Redaction: redaction}
+	if *f.hashKey != "" {
+		opts.HashKey = []byte(*f.hashKey)
+	}
+	return New(w, opts)
+}
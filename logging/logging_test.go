@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestRedactorText(t *testing.T) {
+	mask, _ := NewRedactor(RedactMask, nil)
+	none, _ := NewRedactor(RedactNone, nil)
+	tests := []struct {
+		in, mask string
+	}{
+		{"192.0.2.17", "192.0.2.x"},
+		{"192.0.2.17:5100", "192.0.2.x:5100"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::x"},
+		{"[2001:db8::1]:9029", "[2001:db8::x]:9029"},
+		{"192.168.1.2/24", "192.168.1.x/24"},
+		{"write udp 10.0.0.2:53000->203.0.113.7:9029: refused", "write udp 10.0.0.x:53000->203.0.113.x:9029: refused"},
+		{"no addresses, 1.2.3 or 12:30:45", "no addresses, 1.2.3 or 12:30:45"},
+		{"peer=v192.0.2.17 via 999.10.0.0.2", "peer=v192.0.2.x via 999.10.0.0.x"},
+		{"1.2.3.4.5.6.7.8", "1.2.3.x.5.6.7.x"},
+	}
+	for _, tc := range tests {
+		if got := mask.Text(tc.in); got != tc.mask {
+			t.Errorf("mask %q = %q; want %q", tc.in, got, tc.mask)
+		}
+		if got := none.Text(tc.in); got != tc.in {
+			t.Errorf("none %q = %q", tc.in, got)
+		}
+	}
+
+	hash, _ := NewRedactor(RedactHash, []byte("key"))
+	a, b := hash.Text("192.0.2.17:5100"), hash.Text("192.0.2.17:6000")
+	if !strings.HasPrefix(a, "ip-") || strings.Contains(a, "192.0.2") || a[:strings.LastIndex(a, ":")] != b[:strings.LastIndex(b, ":")] {
+		t.Errorf("hashes of one address = %q, %q; want the same hash", a, b)
+	}
+	other, _ := NewRedactor(RedactHash, []byte("other key"))
+	if other.Text("192.0.2.17") == hash.Text("192.0.2.17") {
+		t.Errorf("hashes with different keys match")
+	}
+}
+
+func TestLoggerRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{JSON: true, Redaction: RedactMask, Level: slog.LevelDebug})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Info("recovered 10.0.0.2",
+		KeyInterface, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 53000},
+		"ips", []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(192, 168, 1, 9)},
+		"game", netip.MustParseAddrPort("192.0.2.1:5100"),
+		"ip", net.IPv4(198, 51, 100, 4),
+		KeyError, errors.New("dial 203.0.113.7: timeout"),
+		"count", 3)
+	out := buf.String()
+	for _, leak := range []string{"10.0.0.2", "192.168.1.9", "192.0.2.1", "198.51.100.4", "203.0.113.7"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("log leaks %s: %s", leak, out)
+		}
+	}
+	for _, want := range []string{`"msg":"recovered 10.0.0.x"`, `"interface":"10.0.0.x:53000"`, `"ips":["10.0.0.x","192.168.1.x"]`, `"game":"192.0.2.x:5100"`, `"err":"dial 203.0.113.x: timeout"`, `"count":3`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %s: %s", want, out)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := ParseRedaction("HASH"); err != nil {
+		t.Errorf("ParseRedaction(HASH): %v", err)
+	}
+	if _, err := ParseRedaction("scramble"); err == nil {
+		t.Errorf("ParseRedaction(scramble) succeeded")
+	}
+	if level, err := ParseLevel("warn"); err != nil || level != slog.LevelWarn {
+		t.Errorf("ParseLevel(warn) = %v, %v", level, err)
+	}
+}
+
+// Returns the IPv4 addresses in `s` that aren't part of a longer number.
+func ipv4s(s string) map[string]bool {
+	found := make(map[string]bool)
+	isDigit := func(i int) bool { return i >= 0 && i < len(s) && s[i] >= '0' && s[i] <= '9' }
+	for i := range s {
+		for j := i + 7; j <= min(i+15, len(s)); j++ {
+			if isDigit(i-1) || isDigit(j) {
+				continue
+			}
+			if addr, err := netip.ParseAddr(s[i:j]); err == nil && addr.Is4() {
+				found[s[i:j]] = true
+			}
+		}
+	}
+	return found
+}
+
+func FuzzRedactorText(f *testing.F) {
+	f.Add("192.0.2.17:5100")
+	f.Add("[2001:db8::1]:9029")
+	f.Add("write udp 10.0.0.2:53000->203.0.113.7:9029: refused")
+	f.Add("::ffff:192.0.2.1")
+	f.Add("version 14.10.588.1234, 1.2.3")
+	mask, _ := NewRedactor(RedactMask, nil)
+	hash, _ := NewRedactor(RedactHash, []byte("key"))
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, r := range []*Redactor{mask, hash} {
+			out := r.Text(s)
+			left := ipv4s(out)
+			for addr := range ipv4s(s) {
+				if left[addr] {
+					t.Fatalf("%s redacted %q to %q, which still has %s", r.policy, s, out, addr)
+				}
+			}
+		}
+	})
+}
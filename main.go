@@ -5,16 +5,27 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/SergioFloresCorrea/lol-multipath/connection"
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/metrics"
+	"github.com/SergioFloresCorrea/lol-multipath/proxy"
+	"github.com/SergioFloresCorrea/lol-multipath/tui"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
 	"github.com/SergioFloresCorrea/lol-multipath/udpmultipath"
+	"github.com/SergioFloresCorrea/lol-multipath/webui"
 )
 
 func main() {
@@ -24,15 +35,37 @@ func main() {
 	thresholdFactor := flag.Float64("threshold-factor", 1.4, "exclude connections whose ping exceeds thresholdFactorxthe lowest observed ping. Must be greater than 1.0")
 	updateInterval := flag.Duration("update-interval", 30*time.Second, "interval at which to refresh each connection's ping metrics")
 	probeInterval := flag.Duration("probe-interval", 10*time.Second, "interval at which to probe for down connections")
+	probeDelay := flag.Duration("probe-delay", 10*time.Second, "how long a connection stays down before it is probed")
 	timeout := flag.Duration("timeout", 1*time.Second, "ping response timeout")
-	cleanupInterval := flag.Duration("cleanup-interval", 1*time.Second, "how long to wait before cleaning the packet cache involved in the deduplicating package process")
+	cleanupInterval := flag.Duration("cleanup-interval", 1*time.Second, "how long the -local-proxies wait before cleaning the packet cache involved in the deduplicating package process")
 	maxConnections := flag.Int("max-connections", 2, "maximum number of connections for multipath routing")
 	dynamicMode := flag.Bool("dynamic", false, "enable periodic proxy reselection")
+	keyFile := flag.String("keyfile", "", "file with the pre-shared key (the first one) every packet to the proxies is authenticated with")
+	tunnelKeyFile := flag.String("tunnel-key", "", "file with the hex-encoded private key of the client; encrypts what is sent to the proxies listed in -proxy-public-keys, their replies to the game client aren't encrypted")
+	proxyPublicKeysCSV := flag.String("proxy-public-keys", "", "comma-separated hex-encoded public keys of the proxies, by listen address (e.g. \"A:9029=ab12…,B:9030=cd34…\")")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve Prometheus metrics of the paths on, at /metrics (e.g. \"127.0.0.1:9100\"); disabled if empty")
+	controlAddr := flag.String("control-addr", "", "address to serve the JSON status and control API on (e.g. \"127.0.0.1:9101\"); disabled if empty")
+	webAddr := flag.String("web-addr", "", "address to serve the read-only web dashboard on (e.g. \"0.0.0.0:8080\" to watch from the LAN); disabled if empty")
+	traceDir := flag.String("trace-dir", "", "directory a trace of every game session is recorded to, read it with lol-multipath-trace; disabled if empty")
+	captureDir := flag.String("capture-dir", "", "directory a pcapng capture of every game session is written to, with an interface per path; disabled if empty")
+	tuiMode := flag.Bool("tui", false, "show a full-screen dashboard of the paths instead of the scrolling log")
+	logFlags := logging.RegisterFlags(flag.CommandLine, logging.RedactMask)
+	localProxies := flag.Bool("local-proxies", false, "run a proxy in this process on every -proxy-listen-addr instead of dialing proxies running elsewhere, for testing")
+	upstreamTargetsCSV := flag.String("upstream-targets", "", "comma-separated per-server overrides of how the -local-proxies measure their latency to the game server, the scheme picks the probe: http(s), tcp, udp or icmp (e.g. \"NA=tcp://192.0.2.10:5100,EUW=icmp://192.0.2.20\")")
 
 	flag.Parse()
 
+	// Every log goes through logOut, so the dashboard can take the terminal over.
+	logOut := logging.NewWriter(os.Stderr)
+	logger, err := logFlags.Logger(logOut)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
 	if *proxyListenCSV == "" {
-		log.Printf("Error: -proxy-listen-addr is required")
+		slog.Error("-proxy-listen-addr is required")
 		flag.Usage()
 		os.Exit(2)
 	}
@@ -41,48 +74,90 @@ func main() {
 	proxyPingAddrs := parseCSV(*proxyPingCSV)
 
 	if len(proxyListenAddrs) != len(proxyPingAddrs) {
-		log.Fatalf("need same number of --proxy-listen-addr (%d) and --proxy-ping-listen-addr (%d)", len(proxyListenAddrs), len(proxyPingAddrs))
+		fatal("need same number of -proxy-listen-addr and -proxy-ping-listen-addr", "listen", len(proxyListenAddrs), "ping", len(proxyPingAddrs))
 	} else if len(proxyListenAddrs) == 0 {
-		log.Printf("need at least one proxy for the script to be of use.")
-		os.Exit(1)
+		fatal("need at least one proxy for the script to be of use")
 	}
 
 	if *server == "" {
-		log.Printf("Error: -server is required")
+		slog.Error("-server is required")
 		flag.Usage()
 		os.Exit(2)
 	}
 
 	if *thresholdFactor <= 1.0 {
-		log.Fatalf("please input a threshold factor greater than 1.0")
+		slog.Error("please input a threshold factor greater than 1.0")
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	RAND, _ := randomHex(5)
 	cfg := udpmultipath.Config{
-		Rand:            RAND,
 		Server:          strings.ToUpper(*server),
 		UpdateInterval:  *updateInterval,
 		ProbeInterval:   *probeInterval,
+		ProbeDelay:      *probeDelay,
 		ThresholdFactor: *thresholdFactor,
 		Timeout:         *timeout,
-		CleanupInterval: *cleanupInterval,
 		MaxConnections:  *maxConnections,
 		Dynamic:         *dynamicMode,
+		TraceDir:        *traceDir,
+		CaptureDir:      *captureDir,
 	}
 
-	cfg.GenerateServerMap()
+	upstreamTargets, err := proxy.ParseUpstreamTargets(*upstreamTargetsCSV)
+	if err != nil {
+		fatal("invalid -upstream-targets", logging.KeyError, err)
+	}
+
+	var clientKeys []keys.Key
+	if *keyFile != "" {
+		clientKeys, err = keys.LoadFile(*keyFile)
+		if err != nil {
+			fatal("failed to load keys", logging.KeyError, err)
+		}
+		if len(clientKeys) == 0 {
+			fatal("the keyfile has no keys", "keyfile", *keyFile)
+		}
+		cfg.Key = &clientKeys[0]
+	}
+
+	if *tunnelKeyFile != "" {
+		kp, err := tunnel.LoadKeypair(*tunnelKeyFile)
+		if err != nil {
+			fatal("failed to load the tunnel key", logging.KeyError, err)
+		}
+		proxyKeys, err := parseProxyKeys(*proxyPublicKeysCSV)
+		if err != nil {
+			fatal("invalid -proxy-public-keys", logging.KeyError, err)
+		}
+		cfg.Tunnel = &udpmultipath.TunnelConfig{Key: kp, ProxyKeys: proxyKeys}
+	}
 
 	// Create a global context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if *metricsAddr != "" {
+		cfg.Metrics = metrics.NewRegistry()
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", cfg.Metrics.Handler())
+		serveHTTP(ctx, "metrics", *metricsAddr, mux)
+	}
+	if *controlAddr != "" || *tuiMode || *webAddr != "" {
+		cfg.Controller = udpmultipath.NewController()
+	}
+	if *controlAddr != "" {
+		serveHTTP(ctx, "status and control API", *controlAddr, cfg.Controller.Handler())
+	}
+	if *webAddr != "" {
+		serveHTTP(ctx, "web dashboard", *webAddr, webui.Handler(cfg.Controller))
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigs
-		log.Println("interrupt received, shutting down…")
+		slog.Info("interrupt received, shutting down…")
 		cancel()
 	}()
 
@@ -91,7 +166,7 @@ func main() {
 		res, err := connection.WaitForLeagueAndResolve(ctx, 5*time.Second)
 		if err != nil {
 			cancel()
-			log.Fatalf("failed to resolve league process: %v", err)
+			fatal("failed to resolve league process", logging.KeyError, err)
 		}
 		resultCh <- res
 	}()
@@ -101,7 +176,7 @@ func main() {
 			for {
 				active := connection.CheckIfLeagueIsActive()
 				if !active {
-					log.Println("League process has exited. Shutting down...")
+					slog.Info("League process has exited. Shutting down...")
 					os.Exit(0)
 				}
 				time.Sleep(10 * time.Second)
@@ -109,79 +184,183 @@ func main() {
 		}()
 	*/
 	udpConn := <-resultCh
-	log.Println("UDP Done!")
-	log.Println("Local Port:", udpConn.LocalPort)
+	slog.Info("found the game's UDP socket", "local_port", udpConn.LocalPort)
 
-	packetChan := make(chan []byte)
+	// Buffered so a slow write doesn't stall the capture, see the intercept queue depth metric.
+	packetChan := make(chan []byte, 64)
 
 	localIPv4, err := udpmultipath.GetLocalAddresses()
 	if err != nil {
-		log.Fatalf("%v\n", err)
-		os.Exit(1)
+		fatal("failed to list the local interfaces", logging.KeyError, err)
 	}
 	if len(localIPv4) == 0 {
-		log.Fatalf("No local interfaces with IPv4 could be found.")
-		os.Exit(1)
+		fatal("no local interfaces with IPv4 could be found")
 	}
 
-	log.Printf("Local Interface IPv4 addresses: %v", redactIPs(localIPv4))
+	slog.Info("local interfaces", "ips", localIPv4)
 
 	RiotIPPort, RiotLocalIP, err := connection.GetRiotUDPAddressAndPort(udpConn.LocalPort, localIPv4)
 	if err != nil {
-		log.Fatalf("%v\n", err)
-		os.Exit(1)
+		fatal("failed to find Riot's game server", logging.KeyError, err)
 	}
 	riotIP, riotPort, err := net.SplitHostPort(RiotIPPort)
 	if err != nil {
-		log.Fatalf("%v\n", err)
-		os.Exit(1)
+		fatal("failed to find Riot's game server", logging.KeyError, err)
 	}
 
 	remoteIPv4 := net.ParseIP(riotIP)
-	log.Printf("Found Riot IP and Port: %v, %v", riotIP, riotPort)
+	slog.Info("found Riot's game server", "game", RiotIPPort)
 
 	if err = udpmultipath.InterceptOngoingConnection(ctx, udpConn.LocalPort, packetChan); err != nil {
-		log.Fatalf("Couldn't intercept ongoing packets from the client: %v\n", err)
-		os.Exit(1)
+		fatal("couldn't intercept ongoing packets from the client", logging.KeyError, err)
 	}
 
-	centralCh := make(chan udpmultipath.ProxyConfig)
+	riotPortInt, err := strconv.Atoi(riotPort)
+	if err != nil {
+		fatal("remote port must be a numeric string", "port", riotPort)
+	}
+	sessionID, err := randomHex(8)
+	if err != nil {
+		fatal("failed to pick a session ID", logging.KeyError, err)
+	}
+	session := udpmultipath.GameSession{
+		ID:         sessionID,
+		GameAddr:   &net.UDPAddr{IP: remoteIPv4, Port: riotPortInt},
+		ClientAddr: &net.UDPAddr{IP: net.ParseIP(RiotLocalIP), Port: udpConn.LocalPort},
+		ClientIPs:  localIPv4,
+	}
 
-	proxyChs := make([]chan udpmultipath.ProxyConfig, len(proxyListenAddrs))
-	for i := range proxyListenAddrs {
-		proxyChs[i] = make(chan udpmultipath.ProxyConfig)
+	var servers []*proxy.Server
+	if *localProxies {
+		servers = startLocalProxies(ctx, cfg, clientKeys, upstreamTargets, *cleanupInterval, proxyListenAddrs, proxyPingAddrs)
 	}
 
-	// If the servers are already up, you may omit this loop!
-	for i, listen := range proxyListenAddrs {
-		ping := proxyPingAddrs[i]
-		cfgCh := proxyChs[i]
-		go func(listen, ping string, proxyConfigCh chan udpmultipath.ProxyConfig) {
-			// for testing only, ideally, you would have already setup these servers
-			if err := cfg.ProxyServer(ctx, proxyConfigCh, listen, ping); err != nil {
-				log.Fatalf("%v\n", err)
-				os.Exit(1)
-			}
-		}(listen, ping, cfgCh)
+	stopDashboard := func() {}
+	if *tuiMode {
+		stopDashboard = runDashboard(ctx, logOut, cfg.Controller, servers)
+		defer stopDashboard()
+	}
+
+	err = cfg.MultipathProxy(ctx, session, localIPv4, proxyListenAddrs, proxyPingAddrs, packetChan)
+	if err != nil {
+		stopDashboard()
+		fatal("couldn't make a multipath connection", logging.KeyError, err)
 	}
 
-	go broadcast(centralCh, proxyChs)
+}
+
+// Starts a proxy in this process on every listen address, for testing without proxies running elsewhere
+// (see cmd/lol-multipath-proxy). Like remote ones, they get the session over the control channel.
+func startLocalProxies(ctx context.Context, cfg udpmultipath.Config, clientKeys []keys.Key, upstreamTargets map[string]string,
+	cleanupInterval time.Duration, proxyListenAddrs, proxyPingAddrs []string) []*proxy.Server {
+	var ring *keys.Ring
+	if cfg.Key != nil {
+		ring = keys.NewRing(clientKeys[:1])
+	}
+	// The in-process proxies share a throwaway keypair and only let this client in.
+	var tunnelKey *tunnel.Keypair
+	var tunnelClients *keys.Ring
+	if cfg.Tunnel != nil {
+		kp, err := tunnel.GenerateKeypair()
+		if err != nil {
+			fatal("failed to generate the proxies' tunnel key", logging.KeyError, err)
+		}
+		tunnelKey = &kp
+		tunnelClients = keys.NewRing([]keys.Key{{Name: "local", Secret: cfg.Tunnel.Key.Public[:]}})
+		for _, listen := range proxyListenAddrs {
+			cfg.Tunnel.ProxyKeys[listen] = kp.Public
+		}
+	}
 
-	centralCh <- udpmultipath.ProxyConfig{
-		RemoteIP:   remoteIPv4,
-		RemotePort: riotPort,
-		ClientIP:   RiotLocalIP,
-		ClientPort: udpConn.LocalPort,
+	var servers []*proxy.Server
+	for i, listen := range proxyListenAddrs {
+		srv, err := proxy.NewServer(proxy.Options{
+			ListenAddr:      listen,
+			PingListenAddr:  proxyPingAddrs[i],
+			Regions:         []string{cfg.Server},
+			UpstreamTargets: upstreamTargets,
+			CleanupInterval: cleanupInterval,
+			Keys:            ring,
+			TunnelKey:       tunnelKey,
+			TunnelClients:   tunnelClients,
+		})
+		if err != nil {
+			fatal("failed to create a local proxy", logging.KeyError, err)
+		}
+		if err := srv.Listen(); err != nil {
+			fatal("failed to start a local proxy", logging.KeyError, err)
+		}
+		servers = append(servers, srv)
+		go func() {
+			if err := srv.Serve(ctx); err != nil {
+				fatal("local proxy failed", logging.KeyProxy, listen, logging.KeyError, err)
+			}
+		}()
 	}
+	return servers
+}
 
-	close(centralCh)
+// Logs `msg` as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
 
-	err = cfg.MultipathProxy(ctx, localIPv4, proxyListenAddrs, proxyPingAddrs, packetChan)
+// Serves `handler` on the TCP address `addr` until ctx is done.
+func serveHTTP(ctx context.Context, what, addr string, handler http.Handler) {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("Couldn't make a multipath connection %v\n", err)
-		os.Exit(1)
+		fatal("failed to listen", "for", what, logging.KeyError, err)
 	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "for", what, logging.KeyError, err)
+		}
+	}()
+	slog.Info("serving over HTTP", "for", what, "url", "http://"+ln.Addr().String())
+}
 
+// Shows the dashboard instead of the log until ctx is done or the returned function is called, which
+// restores the terminal and the log output. Only the in-process proxies' duplicates are known.
+func runDashboard(ctx context.Context, logOut *logging.Writer, ctl *udpmultipath.Controller, servers []*proxy.Server) func() {
+	logs := tui.NewLogBuffer(200)
+	prev := logOut.Set(logs)
+	dashboard := &tui.Dashboard{Snapshot: func() tui.Snapshot {
+		snap := tui.Snapshot{Status: ctl.Status(), Logs: logs.Lines()}
+		if len(servers) > 0 {
+			var dups uint64
+			for _, srv := range servers {
+				for _, session := range srv.Sessions() {
+					dups += session.Duplicates
+				}
+			}
+			snap.Duplicates = &dups
+		}
+		return snap
+	}}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := dashboard.Run(ctx, os.Stdout); err != nil {
+			logOut.Set(prev)
+			slog.Error("dashboard failed", logging.KeyError, err)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+			logOut.Set(prev)
+		})
+	}
 }
 
 func parseCSV(s string) []string {
@@ -195,24 +374,21 @@ func parseCSV(s string) []string {
 	return parts
 }
 
-func broadcast(in <-chan udpmultipath.ProxyConfig, outs []chan udpmultipath.ProxyConfig) {
-	for cfg := range in {
-		for _, out := range outs {
-			out <- cfg
+// Parses the ADDR=KEY entries of -proxy-public-keys into the public keys by proxy address.
+func parseProxyKeys(s string) (map[string][tunnel.KeySize]byte, error) {
+	proxyKeys := make(map[string][tunnel.KeySize]byte)
+	for _, entry := range parseCSV(s) {
+		addr, hexKey, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q, want ADDR=KEY", entry)
 		}
+		pub, err := tunnel.ParseKey(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key of %s: %w", strings.TrimSpace(addr), err)
+		}
+		proxyKeys[strings.TrimSpace(addr)] = pub
 	}
-}
-
-// Only works for IPv4. Masks the IPs to the form 192.0.2.x
-func redactIPs(ips []net.IP) []string {
-	redactedIPs := make([]string, len(ips))
-	for index, ip := range ips {
-		ipString := ip.String()
-		ipParts := strings.Split(ipString, ".")
-		ipParts[len(ipParts)-1] = "x"
-		redactedIPs[index] = strings.Join(ipParts, ".")
-	}
-	return redactedIPs
+	return proxyKeys, nil
 }
 
 func randomHex(n int) (string, error) {
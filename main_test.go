@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func FuzzParseCSV(f *testing.F) {
+	f.Add("203.0.113.7:9029, 198.51.100.4:9029")
+	f.Add("")
+	f.Add(" , ,")
+	f.Fuzz(func(t *testing.T, s string) {
+		parts := parseCSV(s)
+		if s == "" {
+			if parts != nil {
+				t.Fatalf("parseCSV(%q) = %q", s, parts)
+			}
+			return
+		}
+		if len(parts) != strings.Count(s, ",")+1 {
+			t.Fatalf("parseCSV(%q) = %q, want one part per comma and one more", s, parts)
+		}
+		for _, part := range parts {
+			if part != strings.TrimSpace(part) || strings.Contains(part, ",") {
+				t.Fatalf("parseCSV(%q) has part %q", s, part)
+			}
+		}
+	})
+}
+
+func FuzzParseProxyKeys(f *testing.F) {
+	key := strings.Repeat("ab12", 16)
+	f.Add("203.0.113.7:9029=" + key + ", 198.51.100.4:9030=" + strings.ToUpper(key))
+	f.Add("203.0.113.7:9029")
+	f.Add("a=b=" + key)
+	f.Fuzz(func(t *testing.T, s string) {
+		proxyKeys, err := parseProxyKeys(s)
+		if err != nil {
+			return
+		}
+		var entries []string
+		for addr, pub := range proxyKeys {
+			if addr != strings.TrimSpace(addr) || strings.Contains(addr, ",") {
+				t.Fatalf("parseProxyKeys(%q) has address %q", s, addr)
+			}
+			entries = append(entries, addr+"="+hex.EncodeToString(pub[:]))
+		}
+		again, err := parseProxyKeys(strings.Join(entries, ","))
+		if err != nil || len(again) != len(proxyKeys) {
+			t.Fatalf("re-encoded %v as %q, parsed %v, %v", proxyKeys, entries, again, err)
+		}
+		for addr, pub := range proxyKeys {
+			if again[addr] != pub {
+				t.Fatalf("key of %s re-parsed as %x, want %x", addr, again[addr], pub)
+			}
+		}
+	})
+}
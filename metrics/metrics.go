@@ -0,0 +1,304 @@
+// Package metrics implements the few Prometheus metric types the client and the proxy expose, and
+// writes them in the Prometheus text exposition format. Metrics are created on a Registry, with or
+// without labels, and are safe for concurrent use.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type is a Prometheus metric type.
+type Type string
+
+const (
+	CounterType   Type = "counter"
+	GaugeType     Type = "gauge"
+	HistogramType Type = "histogram"
+)
+
+// Registry holds metric families and writes them out. The zero value is not usable, see NewRegistry.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// Creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// A metric name with its help, type, label names and either children or a collect function.
+type family struct {
+	name, help string
+	typ        Type
+	labels     []string
+	buckets    []float64 // histograms only
+
+	mu       sync.Mutex
+	children map[string]*child // by joined label values
+	collect  func(emit func(value float64, labelValues ...string))
+}
+
+type child struct {
+	labelValues []string
+	value       atomic.Uint64 // float64 bits, counters and gauges
+	histogram   *Histogram
+}
+
+// Returns the family `name`, creating it if needed. Registering a name again with the same
+// type and labels returns the existing family, so code run more than once can register freely.
+func (r *Registry) family(name, help string, typ Type, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || !slices.Equal(f.labels, labels) {
+			panic(fmt.Sprintf("metrics: %s registered twice with different types or labels", name))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, typ: typ, labels: labels, buckets: buckets, children: make(map[string]*child)}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) child(labelValues []string) *child {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.children[key]
+	if !ok {
+		c = &child{labelValues: slices.Clone(labelValues)}
+		if f.typ == HistogramType {
+			c.histogram = &Histogram{buckets: f.buckets, counts: make([]uint64, len(f.buckets))}
+		}
+		f.children[key] = c
+	}
+	return c
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ c *child }
+
+// Adds `v`, which must not be negative, to the counter.
+func (c Counter) Add(v float64) {
+	for {
+		old := c.c.value.Load()
+		if c.c.value.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Adds one to the counter.
+func (c Counter) Inc() { c.Add(1) }
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ c *child }
+
+// Sets the gauge to `v`.
+func (g Gauge) Set(v float64) { g.c.value.Store(math.Float64bits(v)) }
+
+// Adds `v` to the gauge.
+func (g Gauge) Add(v float64) { Counter(g).Add(v) }
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // upper bounds, ascending
+	counts  []uint64  // not cumulative
+	sum     float64
+	count   uint64
+}
+
+// Records the observation `v`.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// CounterVec is a counter family with labels.
+type CounterVec struct{ f *family }
+
+// Returns the counter with `labelValues`, in the order of the family's labels.
+func (v CounterVec) With(labelValues ...string) Counter { return Counter{v.f.child(labelValues)} }
+
+// GaugeVec is a gauge family with labels.
+type GaugeVec struct{ f *family }
+
+// Returns the gauge with `labelValues`, in the order of the family's labels.
+func (v GaugeVec) With(labelValues ...string) Gauge { return Gauge{v.f.child(labelValues)} }
+
+// Forgets the gauge with `labelValues`, e.g. once what it describes is gone.
+func (v GaugeVec) Delete(labelValues ...string) {
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	delete(v.f.children, strings.Join(labelValues, "\xff"))
+}
+
+// HistogramVec is a histogram family with labels.
+type HistogramVec struct{ f *family }
+
+// Returns the histogram with `labelValues`, in the order of the family's labels.
+func (v HistogramVec) With(labelValues ...string) *Histogram { return v.f.child(labelValues).histogram }
+
+// Registers a counter.
+func (r *Registry) Counter(name, help string, labels ...string) CounterVec {
+	return CounterVec{r.family(name, help, CounterType, labels, nil)}
+}
+
+// Registers a gauge.
+func (r *Registry) Gauge(name, help string, labels ...string) GaugeVec {
+	return GaugeVec{r.family(name, help, GaugeType, labels, nil)}
+}
+
+// Registers a histogram with the bucket upper bounds `buckets`, in ascending order.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) HistogramVec {
+	return HistogramVec{r.family(name, help, HistogramType, labels, buckets)}
+}
+
+// Registers a counter or gauge family whose values are read when the registry is written, by calling
+// `collect`, which emits every value with its label values. Registering it again replaces `collect`.
+func (r *Registry) Func(name, help string, typ Type, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	f := r.family(name, help, typ, labels, nil)
+	f.mu.Lock()
+	f.collect = collect
+	f.mu.Unlock()
+}
+
+// Writes every metric in the text exposition format, sorted by name and label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(families, func(a, b *family) int { return strings.Compare(a.name, b.name) })
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	for _, f := range families {
+		f.write(cw)
+	}
+	return cw.n, bw.Flush()
+}
+
+// Serves the registry in the text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+	histogram   *Histogram
+}
+
+func (f *family) write(w io.Writer) {
+	var samples []sample
+	f.mu.Lock()
+	collect := f.collect
+	for _, c := range f.children {
+		samples = append(samples, sample{labelValues: c.labelValues, value: math.Float64frombits(c.value.Load()), histogram: c.histogram})
+	}
+	f.mu.Unlock()
+	if collect != nil {
+		collect(func(value float64, labelValues ...string) {
+			samples = append(samples, sample{labelValues: labelValues, value: value})
+		})
+	}
+	slices.SortFunc(samples, func(a, b sample) int { return slices.Compare(a.labelValues, b.labelValues) })
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	for _, s := range samples {
+		if s.histogram == nil {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelString(s.labelValues, ""), formatValue(s.value))
+			continue
+		}
+		h := s.histogram
+		h.mu.Lock()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelString(s.labelValues, ""), formatValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelString(s.labelValues, ""), h.count)
+		h.mu.Unlock()
+	}
+}
+
+// Returns `{name="value",...}` for the label values, with `le` added when not empty.
+func (f *family) labelString(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	if le != "" {
+		if len(f.labels) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "le=\"%s\"", le)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	sent := r.Counter("packets_sent_total", "Packets sent.", "path")
+	sent.With("a").Inc()
+	sent.With("a").Add(2)
+	sent.With(`b"\`).Inc()
+	r.Gauge("queue_depth", "Queued packets.\nNow.").With().Set(4)
+	rtt := r.Histogram("rtt_seconds", "Round trips.", []float64{0.01, 0.05}, "path")
+	for _, v := range []float64{0.005, 0.01, 0.03, 1} {
+		rtt.With("a").Observe(v)
+	}
+	r.Func("sessions", "Open sessions.", GaugeType, []string{"id"}, func(emit func(float64, ...string)) {
+		emit(2, "s2")
+		emit(1, "s1")
+	})
+	// registering again returns the same metric
+	r.Counter("packets_sent_total", "Packets sent.", "path").With("a").Inc()
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	want := `# HELP packets_sent_total Packets sent.
+# TYPE packets_sent_total counter
+packets_sent_total{path="a"} 4
+packets_sent_total{path="b\"\\"} 1
+# HELP queue_depth Queued packets.\nNow.
+# TYPE queue_depth gauge
+queue_depth 4
+# HELP rtt_seconds Round trips.
+# TYPE rtt_seconds histogram
+rtt_seconds_bucket{path="a",le="0.01"} 2
+rtt_seconds_bucket{path="a",le="0.05"} 3
+rtt_seconds_bucket{path="a",le="+Inf"} 4
+rtt_seconds_sum{path="a"} 1.045
+rtt_seconds_count{path="a"} 4
+# HELP sessions Open sessions.
+# TYPE sessions gauge
+sessions{id="s1"} 1
+sessions{id="s2"} 2
+`
+	if b.String() != want {
+		t.Errorf("WriteTo wrote:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("x", "")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering x as a gauge should panic")
+		}
+	}()
+	r.Gauge("x", "")
+}
@@ -0,0 +1,266 @@
+package netsim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock: time only passes when Advance, AdvanceTo or Step are called, which fire the
+// timers that come due in order, each with the clock set to its time. It is safe for concurrent use.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  timerHeap
+	seq     uint64        // breaks ties between timers due at the same time, in the order they were set
+	changed chan struct{} // closed and replaced whenever a timer is set, see BlockUntil
+}
+
+// Creates a clock set to `start`.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start, changed: make(chan struct{})}
+}
+
+// Returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Returns the virtual time elapsed since `t`.
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Timer is a timer of a Clock, like time.Timer.
+type Timer struct {
+	C <-chan time.Time // nil for timers made by AfterFunc
+
+	clock  *Clock
+	when   time.Time
+	period time.Duration // tickers only
+	seq    uint64
+	index  int // in the clock's heap, -1 while stopped
+	fire   func(now time.Time)
+}
+
+// Calls `f` in its own goroutine once `d` has passed.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *Timer {
+	return c.start(d, 0, func(time.Time) { go f() })
+}
+
+// Creates a timer sending the time on its channel once `d` has passed.
+func (c *Clock) NewTimer(d time.Duration) *Timer {
+	ch := make(chan time.Time, 1)
+	t := c.start(d, 0, func(now time.Time) {
+		select {
+		case ch <- now:
+		default:
+		}
+	})
+	t.C = ch
+	return t
+}
+
+// Returns a channel the time is sent on once `d` has passed.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C
+}
+
+// Blocks until `d` has passed.
+func (c *Clock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Calls `fire` from the goroutine advancing the clock once `d` has passed, before the clock moves on.
+func (c *Clock) at(d time.Duration, fire func(now time.Time)) *Timer {
+	return c.start(d, 0, fire)
+}
+
+func (c *Clock) start(d, period time.Duration, fire func(time.Time)) *Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &Timer{clock: c, period: period, index: -1, fire: fire}
+	c.setLocked(t, c.now.Add(d))
+	return t
+}
+
+func (c *Clock) setLocked(t *Timer, when time.Time) {
+	t.when = when
+	t.seq = c.seq
+	c.seq++
+	heap.Push(&c.timers, t)
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Stops the timer; returns false if it had already fired or been stopped.
+func (t *Timer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+// Sets the timer to fire once `d` has passed from now; returns false if it had already fired or been stopped.
+func (t *Timer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := t.index >= 0
+	if active {
+		heap.Remove(&c.timers, t.index)
+	}
+	c.setLocked(t, c.now.Add(d))
+	return active
+}
+
+// Ticker is a ticker of a Clock, like time.Ticker. Ticks a slow receiver misses are dropped.
+type Ticker struct {
+	C <-chan time.Time
+	t *Timer
+}
+
+// Creates a ticker sending the time on its channel every `d`, which must be positive.
+func (c *Clock) NewTicker(d time.Duration) *Ticker {
+	if d <= 0 {
+		panic("netsim: non-positive interval for NewTicker")
+	}
+	ch := make(chan time.Time, 1)
+	t := c.start(d, d, func(now time.Time) {
+		select {
+		case ch <- now:
+		default:
+		}
+	})
+	return &Ticker{C: ch, t: t}
+}
+
+// Stops the ticker.
+func (t *Ticker) Stop() {
+	t.t.Stop()
+}
+
+// Stops the ticker and restarts it with the interval `d`.
+func (t *Ticker) Reset(d time.Duration) {
+	c := t.t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.t.index >= 0 {
+		heap.Remove(&c.timers, t.t.index)
+	}
+	t.t.period = d
+	c.setLocked(t.t, c.now.Add(d))
+}
+
+// Moves the clock `d` forward, firing the timers due by then.
+func (c *Clock) Advance(d time.Duration) {
+	c.AdvanceTo(c.Now().Add(d))
+}
+
+// Moves the clock forward to `end`, firing the timers due by then in order; each fires with the clock set
+// to its time. Timers set while advancing fire too if they are due by `end`. The clock never goes back.
+func (c *Clock) AdvanceTo(end time.Time) {
+	for c.fireNext(end) {
+	}
+	c.mu.Lock()
+	if end.After(c.now) {
+		c.now = end
+	}
+	c.mu.Unlock()
+}
+
+// Moves the clock to the next timer and fires it; returns false if no timer is set.
+func (c *Clock) Step() bool {
+	next, ok := c.Next()
+	return ok && c.fireNext(next)
+}
+
+// Returns when the next timer fires, false if no timer is set.
+func (c *Clock) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].when, true
+}
+
+// Fires the next timer if it is due by `end`.
+func (c *Clock) fireNext(end time.Time) bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 || c.timers[0].when.After(end) {
+		c.mu.Unlock()
+		return false
+	}
+	t := heap.Pop(&c.timers).(*Timer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	now := c.now
+	if t.period > 0 {
+		c.setLocked(t, t.when.Add(t.period))
+	}
+	c.mu.Unlock()
+	t.fire(now)
+	return true
+}
+
+// Returns how many timers are set, sleepers and blocked reads with a deadline included.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// Blocks until at least `n` timers are set, e.g. until the goroutines under test are all waiting on the clock.
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) >= n {
+			c.mu.Unlock()
+			return
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		<-changed
+	}
+}
+
+// Min-heap of timers by time, then by the order they were set.
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int { return len(h) }
+
+func (h timerHeap) Less(i, j int) bool {
+	if !h[i].when.Equal(h[j].when) {
+		return h[i].when.Before(h[j].when)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,118 @@
+package netsim
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+
+func TestClockTimers(t *testing.T) {
+	c := NewClock(epoch)
+	var fired []string
+	due := make(map[string]time.Time)
+	at := func(name string, d time.Duration) *Timer {
+		due[name] = epoch.Add(d)
+		return c.at(d, func(now time.Time) {
+			if want := due[name]; !now.Equal(want) {
+				t.Errorf("%s fired at %v, want %v", name, now, want)
+			}
+			fired = append(fired, name)
+		})
+	}
+	at("b", 20*time.Millisecond)
+	at("a", 10*time.Millisecond)
+	at("a2", 10*time.Millisecond)
+	stopped := at("stopped", 5*time.Millisecond)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop reported the wrong state")
+	}
+	late := at("late", time.Second)
+
+	c.Advance(15 * time.Millisecond)
+	if got := c.Now(); !got.Equal(epoch.Add(15 * time.Millisecond)) {
+		t.Errorf("Now = %v after Advance", got)
+	}
+	c.Advance(10 * time.Millisecond)
+	if want := []string{"a", "a2", "b"}; !slices.Equal(fired, want) {
+		t.Errorf("fired %v, want %v", fired, want)
+	}
+	if c.Timers() != 1 {
+		t.Errorf("Timers = %d, want 1", c.Timers())
+	}
+
+	due["late"] = c.Now().Add(5 * time.Millisecond)
+	if !late.Reset(5 * time.Millisecond) {
+		t.Error("Reset of a pending timer returned false")
+	}
+	if next, ok := c.Next(); !ok || !next.Equal(epoch.Add(30*time.Millisecond)) {
+		t.Errorf("Next = %v, %v", next, ok)
+	}
+	if !c.Step() || c.Step() {
+		t.Error("Step fired the wrong number of timers")
+	}
+	if fired[len(fired)-1] != "late" || !c.Now().Equal(epoch.Add(30*time.Millisecond)) {
+		t.Errorf("Step fired %v at %v", fired, c.Now())
+	}
+}
+
+func TestClockTicker(t *testing.T) {
+	c := NewClock(epoch)
+	tk := c.NewTicker(10 * time.Millisecond)
+	c.Advance(10 * time.Millisecond)
+	if got := <-tk.C; !got.Equal(epoch.Add(10 * time.Millisecond)) {
+		t.Errorf("tick at %v", got)
+	}
+	// a slow receiver only gets the first tick it missed
+	c.Advance(30 * time.Millisecond)
+	if got := <-tk.C; !got.Equal(epoch.Add(20 * time.Millisecond)) {
+		t.Errorf("tick at %v", got)
+	}
+	select {
+	case got := <-tk.C:
+		t.Errorf("unexpected tick at %v", got)
+	default:
+	}
+
+	tk.Reset(time.Second)
+	c.Advance(500 * time.Millisecond)
+	select {
+	case got := <-tk.C:
+		t.Errorf("tick at %v after Reset", got)
+	default:
+	}
+	tk.Stop()
+	if c.Timers() != 0 {
+		t.Errorf("Timers = %d after Stop", c.Timers())
+	}
+}
+
+func TestClockSleep(t *testing.T) {
+	c := NewClock(epoch)
+	done := make(chan time.Time)
+	for range 2 {
+		go func() {
+			c.Sleep(time.Second)
+			done <- c.Now()
+		}()
+	}
+	c.BlockUntil(2)
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Sleep returned early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	for range 2 {
+		if got := <-done; !got.Equal(epoch.Add(time.Second)) {
+			t.Errorf("woke up at %v", got)
+		}
+	}
+
+	called := make(chan struct{})
+	c.AfterFunc(time.Minute, func() { close(called) })
+	c.Advance(time.Minute)
+	<-called
+}
@@ -0,0 +1,212 @@
+package netsim
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+const receiveBuffer = 4096 // datagrams a conn holds unread before dropping new ones
+
+type datagram struct {
+	from netip.AddrPort
+	data []byte
+}
+
+// PacketConn is an unconnected conn of a Network, like the *net.UDPConn of net.ListenUDP. Its deadlines
+// are in the virtual time of the network's clock.
+type PacketConn struct {
+	net   *Network
+	local netip.AddrPort
+
+	mu            sync.Mutex
+	queue         []datagram
+	closed        bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	deadlineTimer *Timer
+	notify        chan struct{} // closed and replaced when a blocked read should look again
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+func (pc *PacketConn) broadcastLocked() {
+	close(pc.notify)
+	pc.notify = make(chan struct{})
+}
+
+// Queues a delivered datagram; returns false if it was dropped.
+func (pc *PacketConn) enqueue(d datagram) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed || len(pc.queue) >= receiveBuffer {
+		return false
+	}
+	pc.queue = append(pc.queue, d)
+	pc.broadcastLocked()
+	return true
+}
+
+func (pc *PacketConn) opError(op string, remote netip.AddrPort, err error) error {
+	e := &net.OpError{Op: op, Net: "udp", Source: udpAddr(pc.local), Err: err}
+	if remote.IsValid() {
+		e.Addr = udpAddr(remote)
+	}
+	return e
+}
+
+// Reads the next datagram, blocking until one arrives, the read deadline passes or the conn is closed.
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	d, err := pc.read()
+	if err != nil {
+		return 0, nil, err
+	}
+	return copy(b, d.data), udpAddr(d.from), nil
+}
+
+func (pc *PacketConn) read() (datagram, error) {
+	for {
+		pc.mu.Lock()
+		if pc.closed {
+			pc.mu.Unlock()
+			return datagram{}, pc.opError("read", netip.AddrPort{}, net.ErrClosed)
+		}
+		if len(pc.queue) > 0 {
+			d := pc.queue[0]
+			pc.queue[0] = datagram{}
+			pc.queue = pc.queue[1:]
+			pc.mu.Unlock()
+			return d, nil
+		}
+		if expired(pc.readDeadline, pc.net.clock.Now()) {
+			pc.mu.Unlock()
+			return datagram{}, pc.opError("read", netip.AddrPort{}, os.ErrDeadlineExceeded)
+		}
+		notify := pc.notify
+		pc.mu.Unlock()
+		<-notify
+	}
+}
+
+func expired(deadline, now time.Time) bool {
+	return !deadline.IsZero() && !now.Before(deadline)
+}
+
+// Sends `b` to `addr`, a *net.UDPAddr. Writes never block; they fail if the link is Unreachable.
+func (pc *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, pc.opError("write", netip.AddrPort{}, net.InvalidAddrError("not a UDP address"))
+	}
+	return pc.write(b, udp.AddrPort())
+}
+
+func (pc *PacketConn) write(b []byte, dst netip.AddrPort) (int, error) {
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	pc.mu.Lock()
+	closed, deadline := pc.closed, pc.writeDeadline
+	pc.mu.Unlock()
+	if closed {
+		return 0, pc.opError("write", dst, net.ErrClosed)
+	}
+	if expired(deadline, pc.net.clock.Now()) {
+		return 0, pc.opError("write", dst, os.ErrDeadlineExceeded)
+	}
+	if err := pc.net.send(pc.local, dst, b); err != nil {
+		return 0, pc.opError("write", dst, err)
+	}
+	return len(b), nil
+}
+
+// Closes the conn, blocked reads return net.ErrClosed.
+func (pc *PacketConn) Close() error {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return pc.opError("close", netip.AddrPort{}, net.ErrClosed)
+	}
+	pc.closed = true
+	pc.queue = nil
+	if pc.deadlineTimer != nil {
+		pc.deadlineTimer.Stop()
+	}
+	pc.broadcastLocked()
+	pc.mu.Unlock()
+	pc.net.unbind(pc)
+	return nil
+}
+
+// Returns the address the conn is bound to, a *net.UDPAddr.
+func (pc *PacketConn) LocalAddr() net.Addr {
+	return udpAddr(pc.local)
+}
+
+// Sets the read and write deadlines, in virtual time.
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	pc.SetWriteDeadline(t)
+	return pc.SetReadDeadline(t)
+}
+
+// Sets the read deadline, in virtual time; blocked reads wake up when the clock reaches it.
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.readDeadline = t
+	if pc.deadlineTimer != nil {
+		pc.deadlineTimer.Stop()
+		pc.deadlineTimer = nil
+	}
+	if now := pc.net.clock.Now(); !t.IsZero() && t.After(now) {
+		pc.deadlineTimer = pc.net.clock.at(t.Sub(now), func(time.Time) {
+			pc.mu.Lock()
+			pc.broadcastLocked()
+			pc.mu.Unlock()
+		})
+	}
+	pc.broadcastLocked()
+	return nil
+}
+
+// Sets the write deadline, in virtual time. Writes never block, so it only makes them fail once passed.
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.writeDeadline = t
+	return nil
+}
+
+// Conn is a connected conn of a Network, like the *net.UDPConn of net.Dial: it only sends to its remote
+// address and drops what comes from anywhere else.
+type Conn struct {
+	pc     *PacketConn
+	remote netip.AddrPort
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// Reads the next datagram from the remote address.
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		d, err := c.pc.read()
+		if err != nil {
+			return 0, err
+		}
+		if d.from == c.remote {
+			return copy(b, d.data), nil
+		}
+	}
+}
+
+// Sends `b` to the remote address.
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.pc.write(b, c.remote)
+}
+
+func (c *Conn) Close() error                       { return c.pc.Close() }
+func (c *Conn) LocalAddr() net.Addr                { return c.pc.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return udpAddr(c.remote) }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.pc.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.pc.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.pc.SetWriteDeadline(t) }
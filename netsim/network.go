@@ -0,0 +1,262 @@
+// Package netsim is an in-memory UDP network for tests: packet and connected conns between addresses, with
+// per-link latency, jitter, loss, reordering, bandwidth and outages, driven by a virtual Clock so the same test
+// delivers the same packets at the same virtual times on every run.
+//
+// A test typically sets the links, starts the code under test on conns of the network, waits with
+// Clock.BlockUntil for it to block on the clock and moves the clock with Clock.Advance. Deliveries happen
+// synchronously while the clock advances, in the order they are due; the random decisions (jitter, loss,
+// reordering) come from the network's seeded source in the order packets are written.
+package netsim
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnreachable is returned by writes over a link that is Unreachable.
+	ErrUnreachable = errors.New("netsim: network is unreachable")
+	errAddrInUse   = errors.New("netsim: address already in use")
+)
+
+// Link is how packets travel from an IP to another.
+type Link struct {
+	Latency      time.Duration // one way
+	Jitter       time.Duration // uniformly random extra delay in [0, Jitter)
+	Loss         float64       // probability a packet is lost
+	Reorder      float64       // probability a packet is held back ReorderDelay more, letting the next ones overtake it
+	ReorderDelay time.Duration
+	Bandwidth    int           // bytes per second, 0 is unlimited; packets queue behind each other
+	MaxQueue     time.Duration // packets that would queue longer are dropped, 0 never drops
+	Down         bool          // packets are silently dropped
+	Unreachable  bool          // writes fail with ErrUnreachable
+}
+
+// LinkStats counts what happened to the packets written over a link.
+type LinkStats struct {
+	Sent      int // written without error
+	Delivered int // reached a conn bound to the destination
+	Lost      int // by Loss or Down
+	Dropped   int // by MaxQueue, or because nothing was bound to the destination
+	Refused   int // writes failed because the link was Unreachable
+}
+
+type link struct {
+	Link
+	busyUntil time.Time // the queue of Bandwidth drains by then
+	stats     LinkStats
+}
+
+type linkKey struct{ from, to netip.Addr }
+
+// Network is a simulated network. Create it with New.
+type Network struct {
+	clock *Clock
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	links    map[linkKey]*link
+	def      Link
+	conns    map[netip.AddrPort]*PacketConn
+	nextPort uint16
+}
+
+// Creates a network on `clock` whose random decisions are drawn from `seed`.
+func New(clock *Clock, seed uint64) *Network {
+	return &Network{
+		clock:    clock,
+		rng:      rand.New(rand.NewPCG(seed, seed)),
+		links:    make(map[linkKey]*link),
+		conns:    make(map[netip.AddrPort]*PacketConn),
+		nextPort: 49152,
+	}
+}
+
+// Returns the clock of the network.
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// Sets the link of the pairs of IPs without one of their own, perfect by default.
+func (n *Network) SetDefaultLink(l Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.def = l
+}
+
+// Sets the link from `from` to `to`. Packets already in flight keep the delay they were given.
+func (n *Network) SetLink(from, to netip.Addr, l Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.linkLocked(from, to).Link = l
+}
+
+// Sets the links between `a` and `b`, both ways.
+func (n *Network) SetPath(a, b netip.Addr, l Link) {
+	n.SetLink(a, b, l)
+	n.SetLink(b, a, l)
+}
+
+// Changes the link from `from` to `to` with `f`.
+func (n *Network) UpdateLink(from, to netip.Addr, f func(*Link)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f(&n.linkLocked(from, to).Link)
+}
+
+// Takes the links between `a` and `b` down, both ways, `after` from now and for `d`. With `unreachable`
+// writes fail during the outage, otherwise the packets are silently dropped.
+func (n *Network) Outage(a, b netip.Addr, after, d time.Duration, unreachable bool) {
+	set := func(down bool) func(time.Time) {
+		return func(time.Time) {
+			for _, dir := range [][2]netip.Addr{{a, b}, {b, a}} {
+				n.UpdateLink(dir[0], dir[1], func(l *Link) {
+					l.Down = down && !unreachable
+					l.Unreachable = down && unreachable
+				})
+			}
+		}
+	}
+	n.clock.at(after, set(true))
+	n.clock.at(after+d, set(false))
+}
+
+// Returns the counters of the link from `from` to `to`.
+func (n *Network) Stats(from, to netip.Addr) LinkStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if l, ok := n.links[linkKey{from.Unmap(), to.Unmap()}]; ok {
+		return l.stats
+	}
+	return LinkStats{}
+}
+
+func (n *Network) linkLocked(from, to netip.Addr) *link {
+	key := linkKey{from.Unmap(), to.Unmap()}
+	l, ok := n.links[key]
+	if !ok {
+		l = &link{Link: n.def}
+		n.links[key] = l
+	}
+	return l
+}
+
+// Binds a packet conn to `addr`; port 0 picks a free one.
+func (n *Network) ListenPacket(addr netip.AddrPort) (*PacketConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+	if addr.Port() == 0 {
+		for {
+			candidate := netip.AddrPortFrom(addr.Addr(), n.nextPort)
+			n.nextPort++
+			if n.nextPort == 0 {
+				n.nextPort = 49152
+			}
+			if _, taken := n.conns[candidate]; !taken {
+				addr = candidate
+				break
+			}
+		}
+	} else if _, taken := n.conns[addr]; taken {
+		return nil, fmt.Errorf("listen %v: %w", addr, errAddrInUse)
+	}
+	pc := &PacketConn{net: n, local: addr, notify: make(chan struct{})}
+	n.conns[addr] = pc
+	return pc, nil
+}
+
+// Binds a conn to `local`, port 0 picks a free one, that only exchanges packets with `remote`.
+func (n *Network) Dial(local, remote netip.AddrPort) (*Conn, error) {
+	pc, err := n.ListenPacket(local)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{pc: pc, remote: netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port())}, nil
+}
+
+// Sends `p` from `src` to `dst` over their link.
+func (n *Network) send(src, dst netip.AddrPort, p []byte) error {
+	n.mu.Lock()
+	l := n.linkLocked(src.Addr(), dst.Addr())
+	delay, ok, err := n.routeLocked(l, len(p))
+	n.mu.Unlock()
+	if !ok {
+		return err
+	}
+
+	packet := datagram{from: src, data: append([]byte(nil), p...)}
+	deliver := func(time.Time) {
+		n.mu.Lock()
+		pc := n.conns[dst]
+		n.mu.Unlock()
+		delivered := pc != nil && pc.enqueue(packet)
+		n.mu.Lock()
+		if delivered {
+			l.stats.Delivered++
+		} else {
+			l.stats.Dropped++
+		}
+		n.mu.Unlock()
+	}
+	if delay <= 0 {
+		deliver(time.Time{})
+	} else {
+		n.clock.at(delay, deliver)
+	}
+	return nil
+}
+
+// Decides the fate of a packet of `size` bytes written over `l` now: whether it is delivered and after how long.
+func (n *Network) routeLocked(l *link, size int) (delay time.Duration, ok bool, err error) {
+	if l.Unreachable {
+		l.stats.Refused++
+		return 0, false, ErrUnreachable
+	}
+	l.stats.Sent++
+
+	// draw every random number whatever the outcome, so changing a link doesn't shift the others' decisions
+	lost := n.rng.Float64() < l.Loss
+	jitter := time.Duration(n.rng.Float64() * float64(l.Jitter))
+	reordered := n.rng.Float64() < l.Reorder
+
+	if l.Down || lost {
+		l.stats.Lost++
+		return 0, false, nil
+	}
+	now := n.clock.Now()
+	depart := now
+	if l.Bandwidth > 0 {
+		if l.busyUntil.After(depart) {
+			depart = l.busyUntil
+		}
+		if l.MaxQueue > 0 && depart.Sub(now) > l.MaxQueue {
+			l.stats.Dropped++
+			return 0, false, nil
+		}
+		depart = depart.Add(time.Duration(size) * time.Second / time.Duration(l.Bandwidth))
+		l.busyUntil = depart
+	}
+	delay = depart.Sub(now) + l.Latency + jitter
+	if reordered {
+		delay += l.ReorderDelay
+	}
+	return delay, true, nil
+}
+
+func (n *Network) unbind(pc *PacketConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conns[pc.local] == pc {
+		delete(n.conns, pc.local)
+	}
+}
+
+func udpAddr(addr netip.AddrPort) *net.UDPAddr {
+	return net.UDPAddrFromAddrPort(addr)
+}
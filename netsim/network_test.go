@@ -0,0 +1,229 @@
+package netsim
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	client = netip.MustParseAddr("10.0.0.2")
+	proxy  = netip.MustParseAddr("198.51.100.7")
+)
+
+func listen(t *testing.T, n *Network, addr string) *PacketConn {
+	t.Helper()
+	pc, err := n.ListenPacket(netip.MustParseAddrPort(addr))
+	if err != nil {
+		t.Fatalf("ListenPacket %s: %v", addr, err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// Returns the payloads waiting on `pc`, without blocking.
+func drain(t *testing.T, pc *PacketConn) []string {
+	t.Helper()
+	var got []string
+	pc.SetReadDeadline(pc.net.clock.Now())
+	defer pc.SetReadDeadline(time.Time{})
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("ReadFrom: %v", err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+}
+
+func TestLatency(t *testing.T) {
+	n := New(NewClock(epoch), 1)
+	n.SetPath(client, proxy, Link{Latency: 20 * time.Millisecond})
+	a := listen(t, n, "10.0.0.2:0")
+	b := listen(t, n, "198.51.100.7:9029")
+
+	if _, err := a.WriteTo([]byte("hello"), b.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	n.Clock().Advance(19 * time.Millisecond)
+	if got := drain(t, b); len(got) != 0 {
+		t.Fatalf("delivered %q before the latency", got)
+	}
+	n.Clock().Advance(time.Millisecond)
+	buf := make([]byte, 16)
+	size, from, err := b.ReadFrom(buf)
+	if err != nil || string(buf[:size]) != "hello" || from.String() != a.LocalAddr().String() {
+		t.Fatalf("ReadFrom = %q, %v, %v", buf[:size], from, err)
+	}
+	if want := (LinkStats{Sent: 1, Delivered: 1}); n.Stats(client, proxy) != want {
+		t.Errorf("Stats = %+v, want %+v", n.Stats(client, proxy), want)
+	}
+}
+
+func TestLossIsSeeded(t *testing.T) {
+	run := func(seed uint64) LinkStats {
+		n := New(NewClock(epoch), seed)
+		n.SetLink(client, proxy, Link{Loss: 0.3, Jitter: 5 * time.Millisecond})
+		a := listen(t, n, "10.0.0.2:0")
+		b := listen(t, n, "198.51.100.7:9029")
+		for range 1000 {
+			a.WriteTo([]byte("x"), b.LocalAddr())
+		}
+		n.Clock().Advance(time.Second)
+		if got := len(drain(t, b)); got != n.Stats(client, proxy).Delivered {
+			t.Errorf("read %d packets, Stats says %d", got, n.Stats(client, proxy).Delivered)
+		}
+		return n.Stats(client, proxy)
+	}
+	first := run(7)
+	if first.Lost < 250 || first.Lost > 350 {
+		t.Errorf("lost %d of 1000 packets at 30%%", first.Lost)
+	}
+	if again := run(7); again != first {
+		t.Errorf("same seed gave %+v then %+v", first, again)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	n := New(NewClock(epoch), 3)
+	n.SetLink(client, proxy, Link{Latency: 10 * time.Millisecond, Reorder: 1, ReorderDelay: 5 * time.Millisecond})
+	a := listen(t, n, "10.0.0.2:0")
+	b := listen(t, n, "198.51.100.7:9029")
+
+	a.WriteTo([]byte("1"), b.LocalAddr())
+	n.UpdateLink(client, proxy, func(l *Link) { l.Reorder = 0 })
+	n.Clock().Advance(time.Millisecond)
+	a.WriteTo([]byte("2"), b.LocalAddr())
+	n.Clock().Advance(time.Second)
+	if got := drain(t, b); len(got) != 2 || got[0] != "2" || got[1] != "1" {
+		t.Errorf("received %q, want the first packet overtaken", got)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	n := New(NewClock(epoch), 1)
+	// 1000 bytes take 10ms, packets wait in the queue for 35ms at most
+	n.SetLink(client, proxy, Link{Bandwidth: 100_000, MaxQueue: 35 * time.Millisecond})
+	a := listen(t, n, "10.0.0.2:0")
+	b := listen(t, n, "198.51.100.7:9029")
+
+	packet := make([]byte, 1000)
+	for range 5 {
+		a.WriteTo(packet, b.LocalAddr())
+	}
+	if got := n.Stats(client, proxy); got.Sent != 5 || got.Dropped != 1 {
+		t.Errorf("Stats = %+v, want the fifth packet dropped by the queue", got)
+	}
+	n.Clock().Advance(20 * time.Millisecond)
+	if got := len(drain(t, b)); got != 2 {
+		t.Errorf("%d packets after 20ms, want 2", got)
+	}
+	n.Clock().Advance(20 * time.Millisecond)
+	if got := len(drain(t, b)); got != 2 {
+		t.Errorf("%d more packets after 40ms, want 2", got)
+	}
+}
+
+func TestOutage(t *testing.T) {
+	for _, unreachable := range []bool{false, true} {
+		n := New(NewClock(epoch), 1)
+		a := listen(t, n, "10.0.0.2:0")
+		b := listen(t, n, "198.51.100.7:9029")
+		n.Outage(client, proxy, time.Second, time.Second, unreachable)
+
+		write := func() error {
+			_, err := a.WriteTo([]byte("x"), b.LocalAddr())
+			return err
+		}
+		n.Clock().Advance(1500 * time.Millisecond)
+		err := write()
+		if unreachable != errors.Is(err, ErrUnreachable) {
+			t.Errorf("unreachable %v: write during the outage: %v", unreachable, err)
+		}
+		if got := drain(t, b); len(got) != 0 {
+			t.Errorf("unreachable %v: delivered %q during the outage", unreachable, got)
+		}
+		n.Clock().Advance(time.Second)
+		if err := write(); err != nil {
+			t.Errorf("unreachable %v: write after the outage: %v", unreachable, err)
+		}
+		if got := drain(t, b); len(got) != 1 {
+			t.Errorf("unreachable %v: delivered %q after the outage", unreachable, got)
+		}
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	n := New(NewClock(epoch), 1)
+	pc := listen(t, n, "10.0.0.2:5000")
+	pc.SetReadDeadline(epoch.Add(time.Second))
+
+	done := make(chan error)
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 16))
+		done <- err
+	}()
+	n.Clock().BlockUntil(1)
+	n.Clock().Advance(999 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("ReadFrom returned early: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	n.Clock().Advance(time.Millisecond)
+	err := <-done
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("ReadFrom = %v, want a timeout", err)
+	}
+}
+
+func TestConn(t *testing.T) {
+	n := New(NewClock(epoch), 1)
+	server := listen(t, n, "198.51.100.7:9029")
+	other := listen(t, n, "198.51.100.8:9029")
+	c, err := n.Dial(netip.MustParseAddrPort("10.0.0.2:0"), netip.MustParseAddrPort("198.51.100.7:9029"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, err := n.ListenPacket(netip.MustParseAddrPort(c.LocalAddr().String())); err == nil {
+		t.Error("ListenPacket on the address of a conn succeeded")
+	}
+
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 16)
+	size, from, err := server.ReadFrom(buf)
+	if err != nil || string(buf[:size]) != "ping" {
+		t.Fatalf("ReadFrom = %q, %v", buf[:size], err)
+	}
+	other.WriteTo([]byte("spoofed"), from)
+	server.WriteTo([]byte("pong"), from)
+	if size, err := c.Read(buf); err != nil || string(buf[:size]) != "pong" {
+		t.Errorf("Read = %q, %v", buf[:size], err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Read(buf)
+		done <- err
+	}()
+	c.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read after Close = %v", err)
+	}
+	if _, err := c.Write([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Write after Close = %v", err)
+	}
+	server.WriteTo([]byte("to nobody"), from)
+	if got := n.Stats(proxy, client); got.Dropped != 1 {
+		t.Errorf("Stats = %+v, want the packet to the closed conn dropped", got)
+	}
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatedRoundTrip(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	inner := EncodePingRequest(PingRequest{Nonce: 1})
+	now := time.UnixMilli(1718000000123)
+
+	b := SealAuthenticated(secret, now, 42, inner)
+	if len(b) != len(inner)+AuthOverhead {
+		t.Fatalf("len = %d; want %d", len(b), len(inner)+AuthOverhead)
+	}
+	if id, err := AuthenticatedKeyID(b); err != nil || id != KeyIDOf(secret) {
+		t.Errorf("AuthenticatedKeyID = %x, %v", id, err)
+	}
+
+	got, sealed, counter, err := OpenAuthenticated(secret, b)
+	if err != nil {
+		t.Fatalf("OpenAuthenticated: %v", err)
+	}
+	if !bytes.Equal(got, inner) || !sealed.Equal(now) || counter != 42 {
+		t.Errorf("got %x sealed at %v under %d; want %x sealed at %v under 42", got, sealed, counter, inner, now)
+	}
+
+	tampered := append([]byte(nil), b...)
+	tampered[HeaderSize+KeyIDSize+8] ^= 1
+	if _, _, _, err := OpenAuthenticated(secret, tampered); !errors.Is(err, ErrBadTag) {
+		t.Errorf("tampered packet: err = %v; want %v", err, ErrBadTag)
+	}
+	if _, _, _, err := OpenAuthenticated([]byte("another secret of enough length"), b); !errors.Is(err, ErrBadTag) {
+		t.Errorf("wrong key: err = %v; want %v", err, ErrBadTag)
+	}
+	if _, err := AuthenticatedKeyID(inner); !errors.Is(err, ErrBadType) {
+		t.Errorf("unsealed packet: err = %v; want %v", err, ErrBadType)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	start := time.Unix(1718000000, 0)
+	a := NewAuthenticator(secret, start)
+	for i := range uint64(3) {
+		_, _, counter, err := OpenAuthenticated(secret, a.Seal(start, []byte("x")))
+		if err != nil || counter != uint64(start.UnixNano())+i {
+			t.Fatalf("packet %d: counter = %d, %v; want %d", i, counter, err, uint64(start.UnixNano())+i)
+		}
+	}
+	// a client restarted later starts past every counter it could have used before
+	_, _, counter, _ := OpenAuthenticated(secret, NewAuthenticator(secret, start.Add(time.Millisecond)).Seal(start, nil))
+	if counter <= uint64(start.UnixNano())+2 {
+		t.Errorf("counter after a restart = %d; want more than %d", counter, uint64(start.UnixNano())+2)
+	}
+}
+
+func FuzzAuthenticated(f *testing.F) {
+	f.Add([]byte("0123456789abcdef0123456789abcdef"), int64(1718000000123), uint64(1), EncodePingRequest(PingRequest{Nonce: 1}), 0)
+	f.Add([]byte("k"), int64(-1), uint64(1<<63), []byte{}, 40)
+	f.Fuzz(func(t *testing.T, secret []byte, ms int64, n uint64, inner []byte, flip int) {
+		b := SealAuthenticated(secret, time.UnixMilli(ms), n, inner)
+		got, sealed, counter, err := OpenAuthenticated(secret, b)
+		if err != nil || !bytes.Equal(got, inner) || sealed.UnixMilli() != ms || counter != n {
+			t.Fatalf("OpenAuthenticated = %x sealed at %d under %d, %v; want %x sealed at %d under %d", got, sealed.UnixMilli(), counter, err, inner, ms, n)
+		}
+		// every byte is covered by the tag, the header included
+		tampered := append([]byte(nil), b...)
+		tampered[uint(flip)%uint(len(b))] ^= 0x80
+		if _, _, _, err := OpenAuthenticated(secret, tampered); err == nil {
+			t.Fatalf("opened %x after flipping byte %d", tampered, uint(flip)%uint(len(b)))
+		}
+		// arbitrary input must not be accepted without knowing the secret
+		if _, _, _, err := OpenAuthenticated(secret, inner); err == nil {
+			t.Fatalf("opened %x with a forged tag", inner)
+		}
+	})
+}
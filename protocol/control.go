@@ -0,0 +1,280 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Control messages are sent by the client to a proxy's relay listener from every path's socket,
+// so the proxy learns which source addresses belong to which session.
+const (
+	TypeSessionOpen   uint8 = 3
+	TypeSessionAck    uint8 = 4
+	TypeSessionUpdate uint8 = 5
+	TypeSessionClose  uint8 = 6
+)
+
+// Features requested by the client in SessionOpen and capabilities advertised by the proxy in SessionAck.
+const (
+	FeatureDedupe       uint32 = 1 << 0 // duplicate copies of client packets are dropped
+	FeaturePingV2       uint32 = 1 << 1 // v2 pings are answered
+	FeatureMultiSession uint32 = 1 << 2 // several sessions can share the proxy
+)
+
+// SessionAck statuses.
+const (
+	StatusOK uint8 = iota
+	StatusRejected
+	StatusUnknownSession
+	StatusTooManySessions
+	StatusSessionExists
+	StatusDestinationNotAllowed
+	StatusTooManyDestinations
+)
+
+var ErrMalformed = errors.New("protocol: malformed message")
+
+// IsControl reports whether `b` looks like a control message. It only checks the header.
+func IsControl(b []byte) bool {
+	h, err := ParseHeader(b)
+	if err != nil || h.Version != Version2 {
+		return false
+	}
+	return h.Type >= TypeSessionOpen && h.Type <= TypeSessionClose
+}
+
+// SessionOpen asks the proxy to relay for a game. SessionUpdate carries the same fields
+// and replaces those of an existing session.
+type SessionOpen struct {
+	Nonce      uint64 // echoed in the ack
+	SessionID  string
+	GameAddr   *net.UDPAddr // Riot's game server
+	ClientAddr *net.UDPAddr // where the game client listens
+	ClientIPs  []net.IP     // IPs the client's paths send from
+	Features   uint32       // requested features
+}
+
+// SessionAck answers SessionOpen, SessionUpdate and SessionClose.
+type SessionAck struct {
+	Nonce         uint64
+	SessionID     string
+	Status        uint8
+	Capabilities  uint32 // features the proxy supports
+	MaxPacketSize uint16
+	IdleTimeout   uint32 // seconds without traffic before the proxy closes the session
+	Message       string // human readable reason when Status isn't StatusOK
+}
+
+// SessionClose asks the proxy to stop relaying for a session.
+type SessionClose struct {
+	Nonce     uint64
+	SessionID string
+}
+
+func EncodeSessionOpen(msg SessionOpen) []byte {
+	return encodeSessionOpen(TypeSessionOpen, msg)
+}
+
+func EncodeSessionUpdate(msg SessionOpen) []byte {
+	return encodeSessionOpen(TypeSessionUpdate, msg)
+}
+
+func encodeSessionOpen(msgType uint8, msg SessionOpen) []byte {
+	b := make([]byte, HeaderSize, 128)
+	putHeader(b, Version2, msgType, 0)
+	b = binary.BigEndian.AppendUint64(b, msg.Nonce)
+	b = binary.BigEndian.AppendUint32(b, msg.Features)
+	b = appendString(b, msg.SessionID)
+	b = appendUDPAddr(b, msg.GameAddr)
+	b = appendUDPAddr(b, msg.ClientAddr)
+	b = append(b, byte(min(len(msg.ClientIPs), 255)))
+	for _, ip := range msg.ClientIPs[:min(len(msg.ClientIPs), 255)] {
+		b = appendIP(b, ip)
+	}
+	return b
+}
+
+// Decodes a SessionOpen or SessionUpdate; the header type tells which one it was.
+func DecodeSessionOpen(b []byte) (SessionOpen, error) {
+	h, err := ParseHeader(b)
+	if err != nil {
+		return SessionOpen{}, err
+	}
+	if h.Type != TypeSessionOpen && h.Type != TypeSessionUpdate {
+		return SessionOpen{}, ErrBadType
+	}
+	if h.Version != Version2 {
+		return SessionOpen{}, ErrBadVersion
+	}
+
+	r := reader{b: b[HeaderSize:]}
+	msg := SessionOpen{
+		Nonce:      r.uint64(),
+		Features:   r.uint32(),
+		SessionID:  r.string(),
+		GameAddr:   r.udpAddr(),
+		ClientAddr: r.udpAddr(),
+	}
+	n := int(r.byte())
+	for range n {
+		msg.ClientIPs = append(msg.ClientIPs, r.ip())
+	}
+	if r.err != nil {
+		return SessionOpen{}, r.err
+	}
+	if msg.GameAddr == nil || msg.ClientAddr == nil {
+		return SessionOpen{}, ErrMalformed
+	}
+	return msg, nil
+}
+
+func EncodeSessionAck(msg SessionAck) []byte {
+	b := make([]byte, HeaderSize, 64)
+	putHeader(b, Version2, TypeSessionAck, 0)
+	b = binary.BigEndian.AppendUint64(b, msg.Nonce)
+	b = append(b, msg.Status)
+	b = binary.BigEndian.AppendUint32(b, msg.Capabilities)
+	b = binary.BigEndian.AppendUint16(b, msg.MaxPacketSize)
+	b = binary.BigEndian.AppendUint32(b, msg.IdleTimeout)
+	b = appendString(b, msg.SessionID)
+	b = appendString(b, msg.Message)
+	return b
+}
+
+func DecodeSessionAck(b []byte) (SessionAck, error) {
+	if err := checkV2(b, TypeSessionAck, HeaderSize); err != nil {
+		return SessionAck{}, err
+	}
+	r := reader{b: b[HeaderSize:]}
+	msg := SessionAck{
+		Nonce:         r.uint64(),
+		Status:        r.byte(),
+		Capabilities:  r.uint32(),
+		MaxPacketSize: r.uint16(),
+		IdleTimeout:   r.uint32(),
+		SessionID:     r.string(),
+		Message:       r.string(),
+	}
+	return msg, r.err
+}
+
+func EncodeSessionClose(msg SessionClose) []byte {
+	b := make([]byte, HeaderSize, 32)
+	putHeader(b, Version2, TypeSessionClose, 0)
+	b = binary.BigEndian.AppendUint64(b, msg.Nonce)
+	return appendString(b, msg.SessionID)
+}
+
+func DecodeSessionClose(b []byte) (SessionClose, error) {
+	if err := checkV2(b, TypeSessionClose, HeaderSize); err != nil {
+		return SessionClose{}, err
+	}
+	r := reader{b: b[HeaderSize:]}
+	msg := SessionClose{
+		Nonce:     r.uint64(),
+		SessionID: r.string(),
+	}
+	return msg, r.err
+}
+
+// Strings are length-prefixed with a single byte and cut to 255 bytes.
+func appendString(b []byte, s string) []byte {
+	s = s[:min(len(s), 255)]
+	b = append(b, byte(len(s)))
+	return append(b, s...)
+}
+
+// IPs are length-prefixed (4 or 16 bytes), IPv4 is always sent in its 4-byte form.
+func appendIP(b []byte, ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	b = append(b, byte(len(ip)))
+	return append(b, ip...)
+}
+
+// A nil address is encoded as an empty IP and port 0.
+func appendUDPAddr(b []byte, addr *net.UDPAddr) []byte {
+	if addr == nil {
+		return append(b, 0, 0, 0)
+	}
+	b = appendIP(b, addr.IP)
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+// reader decodes the variable-length fields of a control message. The first problem is kept
+// in err and every later read returns a zero value.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = ErrShortMessage
+		return nil
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *reader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	return string(r.take(int(r.byte())))
+}
+
+func (r *reader) ip() net.IP {
+	n := int(r.byte())
+	if n != 0 && n != net.IPv4len && n != net.IPv6len {
+		if r.err == nil {
+			r.err = ErrMalformed
+		}
+		return nil
+	}
+	b := r.take(n)
+	if len(b) == 0 {
+		return nil
+	}
+	return net.IP(append([]byte(nil), b...))
+}
+
+func (r *reader) udpAddr() *net.UDPAddr {
+	ip := r.ip()
+	port := r.uint16()
+	if ip == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
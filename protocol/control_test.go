@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSessionOpenRoundTrip(t *testing.T) {
+	want := SessionOpen{
+		Nonce:      99,
+		SessionID:  "0123456789abcdef",
+		GameAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10).To4(), Port: 5100},
+		ClientAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 50000},
+		ClientIPs:  []net.IP{net.IPv4(10, 0, 0, 2).To4(), net.ParseIP("2001:db8::1")},
+		Features:   FeatureDedupe | FeaturePingV2,
+	}
+
+	for _, b := range [][]byte{EncodeSessionOpen(want), EncodeSessionUpdate(want)} {
+		if !IsControl(b) {
+			t.Fatalf("IsControl(%x) = false", b)
+		}
+		got, err := DecodeSessionOpen(b)
+		if err != nil {
+			t.Fatalf("DecodeSessionOpen: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; want %+v", got, want)
+		}
+	}
+
+	if h, _ := ParseHeader(EncodeSessionUpdate(want)); h.Type != TypeSessionUpdate {
+		t.Errorf("update encoded with type %d", h.Type)
+	}
+}
+
+func TestSessionAckAndCloseRoundTrip(t *testing.T) {
+	ack := SessionAck{
+		Nonce:         7,
+		SessionID:     "abc",
+		Status:        StatusTooManySessions,
+		Capabilities:  FeatureDedupe | FeatureMultiSession,
+		MaxPacketSize: 4096,
+		IdleTimeout:   120,
+		Message:       "too many sessions (64)",
+	}
+	gotAck, err := DecodeSessionAck(EncodeSessionAck(ack))
+	if err != nil || gotAck != ack {
+		t.Errorf("DecodeSessionAck = %+v, %v; want %+v", gotAck, err, ack)
+	}
+
+	closeMsg := SessionClose{Nonce: 8, SessionID: "abc"}
+	gotClose, err := DecodeSessionClose(EncodeSessionClose(closeMsg))
+	if err != nil || gotClose != closeMsg {
+		t.Errorf("DecodeSessionClose = %+v, %v; want %+v", gotClose, err, closeMsg)
+	}
+}
+
+func TestControlDecodeErrors(t *testing.T) {
+	open := EncodeSessionOpen(SessionOpen{
+		SessionID:  "id",
+		GameAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 1},
+		ClientAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2},
+	})
+	noGame := EncodeSessionOpen(SessionOpen{SessionID: "id", ClientAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2}})
+	badIP := append([]byte(nil), open...)
+	badIP[HeaderSize+8+4+1+2] = 5 // length of the game IP
+
+	tests := []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"truncated", open[:len(open)-1], ErrShortMessage},
+		{"no game address", noGame, ErrMalformed},
+		{"bad ip length", badIP, ErrMalformed},
+		{"ping as open", EncodePingRequest(PingRequest{}), ErrBadType},
+		{"ack as close", EncodeSessionAck(SessionAck{}), ErrBadType},
+	}
+
+	for _, tc := range tests {
+		var err error
+		if tc.name == "ack as close" {
+			_, err = DecodeSessionClose(tc.b)
+		} else {
+			_, err = DecodeSessionOpen(tc.b)
+		}
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.want)
+		}
+	}
+
+	if IsControl(EncodePingRequest(PingRequest{})) || IsControl([]byte("game payload")) {
+		t.Errorf("IsControl accepted a non-control message")
+	}
+}
+
+// Reports whether `a` and `b` carry the same session, IPv4 addresses being equal in either form.
+func sameSessionOpen(a, b SessionOpen) bool {
+	sameAddr := func(x, y *net.UDPAddr) bool {
+		return x.IP.Equal(y.IP) && x.Port == y.Port
+	}
+	if a.Nonce != b.Nonce || a.SessionID != b.SessionID || a.Features != b.Features || len(a.ClientIPs) != len(b.ClientIPs) ||
+		!sameAddr(a.GameAddr, b.GameAddr) || !sameAddr(a.ClientAddr, b.ClientAddr) {
+		return false
+	}
+	for i := range a.ClientIPs {
+		if !a.ClientIPs[i].Equal(b.ClientIPs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzSessionOpen(f *testing.F) {
+	open := SessionOpen{
+		Nonce:      99,
+		SessionID:  "0123456789abcdef",
+		GameAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 5100},
+		ClientAddr: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 50000},
+		ClientIPs:  []net.IP{net.IPv4(10, 0, 0, 2), net.ParseIP("2001:db8::1")},
+		Features:   FeatureDedupe | FeaturePingV2,
+	}
+	f.Add(EncodeSessionOpen(open))
+	f.Add(EncodeSessionUpdate(open))
+	f.Add(EncodeSessionOpen(SessionOpen{GameAddr: open.GameAddr}))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := DecodeSessionOpen(b)
+		if err != nil {
+			return
+		}
+		if !IsControl(b) {
+			t.Fatalf("decoded %x, which IsControl rejects", b)
+		}
+		encode := EncodeSessionOpen
+		if b[5] == TypeSessionUpdate {
+			encode = EncodeSessionUpdate
+		}
+		again, err := DecodeSessionOpen(encode(msg))
+		if err != nil {
+			t.Fatalf("decoding the re-encoded %+v: %v", msg, err)
+		}
+		if !sameSessionOpen(again, msg) {
+			t.Fatalf("re-encoded %+v, decoded %+v", msg, again)
+		}
+	})
+}
+
+func FuzzSessionAck(f *testing.F) {
+	f.Add(EncodeSessionAck(SessionAck{Nonce: 7, SessionID: "abc", Status: StatusTooManySessions, Capabilities: FeatureDedupe, MaxPacketSize: 4096, IdleTimeout: 120, Message: "too many sessions (64)"}))
+	f.Add(EncodeSessionClose(SessionClose{Nonce: 8, SessionID: "abc"}))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if ack, err := DecodeSessionAck(b); err == nil {
+			if got, err := DecodeSessionAck(EncodeSessionAck(ack)); err != nil || got != ack {
+				t.Fatalf("re-encoded %+v, decoded %+v, %v", ack, got, err)
+			}
+		}
+		if msg, err := DecodeSessionClose(b); err == nil {
+			if got, err := DecodeSessionClose(EncodeSessionClose(msg)); err != nil || got != msg {
+				t.Fatalf("re-encoded %+v, decoded %+v, %v", msg, got, err)
+			}
+		}
+	})
+}
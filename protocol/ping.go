@@ -0,0 +1,193 @@
+// Package protocol holds the wire formats exchanged between the multipath client and its proxies.
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	Magic uint32 = 0x4c4d5050 // "LMPP", prefixes every versioned message
+
+	Version1 uint8 = 1 // legacy 8-byte ping exchange, no header
+	Version2 uint8 = 2 // ping exchange with nonces, timestamps and leg breakdown
+
+	TypePingRequest  uint8 = 1
+	TypePingResponse uint8 = 2
+
+	HeaderSize       = 8 // magic (4) + version (1) + type (1) + flags (1) + reserved (1)
+	PingV1Size       = 8
+	PingRequestSize  = HeaderSize + 8 + 8 // without the optional region
+	MaxRegionLen     = 16
+	PingResponseSize = HeaderSize + 8 + 8 + 8 + 8 + 8
+
+	// MinPingRequestSize is what proxies require of a v2 ping request: it is padded to the size of the
+	// response, so answering a request with a spoofed source doesn't amplify it.
+	MinPingRequestSize = PingResponseSize
+)
+
+// Ping response flags.
+const (
+	FlagUpstreamStale   uint8 = 1 << 0 // the upstream estimate hasn't been refreshed recently
+	FlagUpstreamUnknown uint8 = 1 << 1 // the proxy has no upstream estimate yet, Upstream is zero
+)
+
+var (
+	ErrShortMessage = errors.New("protocol: message too short")
+	ErrBadMagic     = errors.New("protocol: bad magic")
+	ErrBadVersion   = errors.New("protocol: unsupported version")
+	ErrBadType      = errors.New("protocol: unexpected message type")
+)
+
+// Header is the common prefix of every versioned message.
+type Header struct {
+	Version uint8
+	Type    uint8
+	Flags   uint8
+}
+
+// PingRequest is sent by the client to a proxy's ping listener.
+type PingRequest struct {
+	Nonce      uint64
+	ClientSend time.Time // client clock, echoed back untouched
+	Region     string    // league server the client plays on; empty lets the proxy pick its default
+}
+
+// PingResponse answers a PingRequest. ProxyRecv and ProxySend are measured with the proxy's clock,
+// so only their difference is meaningful to the client. Upstream is the proxy's estimate of its
+// own latency to the game server.
+type PingResponse struct {
+	Nonce      uint64
+	ClientSend time.Time
+	ProxyRecv  time.Time
+	ProxySend  time.Time
+	Upstream   time.Duration
+	Flags      uint8
+}
+
+// Hold returns how long the proxy held the request before answering it.
+func (r PingResponse) Hold() time.Duration {
+	return r.ProxySend.Sub(r.ProxyRecv)
+}
+
+// Parses the common header and checks the magic. The version is returned as is
+// so callers can decide what they support.
+func ParseHeader(b []byte) (Header, error) {
+	if len(b) < HeaderSize {
+		return Header{}, ErrShortMessage
+	}
+	if binary.BigEndian.Uint32(b[0:4]) != Magic {
+		return Header{}, ErrBadMagic
+	}
+	return Header{Version: b[4], Type: b[5], Flags: b[6]}, nil
+}
+
+func putHeader(b []byte, version, msgType, flags uint8) {
+	binary.BigEndian.PutUint32(b[0:4], Magic)
+	b[4] = version
+	b[5] = msgType
+	b[6] = flags
+	b[7] = 0
+}
+
+// Checks the header of a v2 message and that it has at least `size` bytes.
+func checkV2(b []byte, msgType uint8, size int) error {
+	h, err := ParseHeader(b)
+	if err != nil {
+		return err
+	}
+	if h.Version != Version2 {
+		return ErrBadVersion
+	}
+	if h.Type != msgType {
+		return ErrBadType
+	}
+	if len(b) < size {
+		return ErrShortMessage
+	}
+	return nil
+}
+
+// IsPingV1Request reports whether b is a legacy ping request, i.e. exactly 8 bytes.
+func IsPingV1Request(b []byte) bool {
+	return len(b) == PingV1Size
+}
+
+// Encodes the legacy reply: the bloat in ms as a big-endian int64.
+func EncodePingV1Response(bloat time.Duration) []byte {
+	b := make([]byte, PingV1Size)
+	binary.BigEndian.PutUint64(b, uint64(bloat.Milliseconds()))
+	return b
+}
+
+// Decodes the legacy reply into the bloat the client must subtract from its RTT.
+func DecodePingV1Response(b []byte) (time.Duration, error) {
+	if len(b) < PingV1Size {
+		return 0, ErrShortMessage
+	}
+	return time.Duration(int64(binary.BigEndian.Uint64(b))) * time.Millisecond, nil
+}
+
+// Encodes a ping request. The region, if any, trails the fixed part, cut to MaxRegionLen bytes and
+// NUL-padded, and the request is padded with zeros to MinPingRequestSize.
+func EncodePingRequest(req PingRequest) []byte {
+	region := req.Region
+	if len(region) > MaxRegionLen {
+		region = region[:MaxRegionLen]
+	}
+	b := make([]byte, MinPingRequestSize)
+	putHeader(b, Version2, TypePingRequest, 0)
+	binary.BigEndian.PutUint64(b[8:16], req.Nonce)
+	binary.BigEndian.PutUint64(b[16:24], uint64(req.ClientSend.UnixNano()))
+	copy(b[PingRequestSize:], region)
+	return b
+}
+
+// Decodes a ping request. Requests shorter than MinPingRequestSize are accepted, it's up to the proxy
+// to refuse them.
+func DecodePingRequest(b []byte) (PingRequest, error) {
+	if err := checkV2(b, TypePingRequest, PingRequestSize); err != nil {
+		return PingRequest{}, err
+	}
+	region := b[PingRequestSize:]
+	if len(region) > MaxRegionLen {
+		region = region[:MaxRegionLen]
+	}
+	region = bytes.TrimRight(region, "\x00")
+	return PingRequest{
+		Nonce:      binary.BigEndian.Uint64(b[8:16]),
+		ClientSend: unixNano(b[16:24]),
+		Region:     string(region),
+	}, nil
+}
+
+func EncodePingResponse(resp PingResponse) []byte {
+	b := make([]byte, PingResponseSize)
+	putHeader(b, Version2, TypePingResponse, resp.Flags)
+	binary.BigEndian.PutUint64(b[8:16], resp.Nonce)
+	binary.BigEndian.PutUint64(b[16:24], uint64(resp.ClientSend.UnixNano()))
+	binary.BigEndian.PutUint64(b[24:32], uint64(resp.ProxyRecv.UnixNano()))
+	binary.BigEndian.PutUint64(b[32:40], uint64(resp.ProxySend.UnixNano()))
+	binary.BigEndian.PutUint64(b[40:48], uint64(resp.Upstream))
+	return b
+}
+
+func DecodePingResponse(b []byte) (PingResponse, error) {
+	if err := checkV2(b, TypePingResponse, PingResponseSize); err != nil {
+		return PingResponse{}, err
+	}
+	return PingResponse{
+		Nonce:      binary.BigEndian.Uint64(b[8:16]),
+		ClientSend: unixNano(b[16:24]),
+		ProxyRecv:  unixNano(b[24:32]),
+		ProxySend:  unixNano(b[32:40]),
+		Upstream:   time.Duration(int64(binary.BigEndian.Uint64(b[40:48]))),
+		Flags:      b[6],
+	}, nil
+}
+
+func unixNano(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
@@ -0,0 +1,194 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPingRequestRoundTrip(t *testing.T) {
+	want := PingRequest{Nonce: 0xdeadbeefcafe, ClientSend: time.Unix(0, 1718000000123456789)}
+
+	b := EncodePingRequest(want)
+	if len(b) != MinPingRequestSize {
+		t.Fatalf("len = %d; want %d", len(b), MinPingRequestSize)
+	}
+	if IsPingV1Request(b) {
+		t.Fatalf("v2 request detected as v1")
+	}
+
+	got, err := DecodePingRequest(b)
+	if err != nil {
+		t.Fatalf("DecodePingRequest: %v", err)
+	}
+	if got.Nonce != want.Nonce || !got.ClientSend.Equal(want.ClientSend) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestPingRequestRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		want   string
+	}{
+		{"", ""},
+		{"EUW", "EUW"},
+		{"AVERYLONGREGIONNAME", "AVERYLONGREGIONN"},
+	}
+
+	// requests of older clients aren't padded
+	unpadded := append(EncodePingRequest(PingRequest{Nonce: 7})[:PingRequestSize], "EUW"...)
+	if got, err := DecodePingRequest(unpadded); err != nil || got.Region != "EUW" {
+		t.Errorf("unpadded request: got %+v, %v; want region EUW", got, err)
+	}
+
+	for _, tc := range tests {
+		b := EncodePingRequest(PingRequest{Nonce: 7, Region: tc.region})
+		got, err := DecodePingRequest(b)
+		if err != nil {
+			t.Fatalf("DecodePingRequest(%q): %v", tc.region, err)
+		}
+		if got.Region != tc.want || got.Nonce != 7 {
+			t.Errorf("region %q: got %+v; want region %q", tc.region, got, tc.want)
+		}
+	}
+}
+
+func TestPingResponseRoundTrip(t *testing.T) {
+	recv := time.Unix(0, 1718000000500000000)
+	want := PingResponse{
+		Nonce:      42,
+		ClientSend: time.Unix(0, 1718000000123456789),
+		ProxyRecv:  recv,
+		ProxySend:  recv.Add(3 * time.Millisecond),
+		Upstream:   37 * time.Millisecond,
+		Flags:      FlagUpstreamStale,
+	}
+
+	got, err := DecodePingResponse(EncodePingResponse(want))
+	if err != nil {
+		t.Fatalf("DecodePingResponse: %v", err)
+	}
+	if got.Nonce != want.Nonce || !got.ClientSend.Equal(want.ClientSend) ||
+		!got.ProxyRecv.Equal(want.ProxyRecv) || !got.ProxySend.Equal(want.ProxySend) ||
+		got.Upstream != want.Upstream || got.Flags != want.Flags {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got.Hold() != 3*time.Millisecond {
+		t.Errorf("Hold() = %v; want 3ms", got.Hold())
+	}
+}
+
+func TestPingV1(t *testing.T) {
+	if !IsPingV1Request(make([]byte, 8)) {
+		t.Errorf("8 zero bytes not detected as v1 request")
+	}
+
+	for _, bloat := range []time.Duration{0, 120 * time.Millisecond, -35 * time.Millisecond} {
+		got, err := DecodePingV1Response(EncodePingV1Response(bloat))
+		if err != nil {
+			t.Fatalf("DecodePingV1Response: %v", err)
+		}
+		if got != bloat {
+			t.Errorf("bloat = %v; want %v", got, bloat)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	req := EncodePingRequest(PingRequest{Nonce: 1, ClientSend: time.Now()})
+	resp := EncodePingResponse(PingResponse{Nonce: 1})
+
+	badMagic := append([]byte(nil), req...)
+	badMagic[0] = 0
+	badVersion := append([]byte(nil), req...)
+	badVersion[4] = 9
+
+	tests := []struct {
+		name   string
+		decode func([]byte) error
+		input  []byte
+		want   error
+	}{
+		{"v1 request", decodeReq, make([]byte, 8), ErrBadMagic},
+		{"truncated request", decodeReq, req[:PingRequestSize-1], ErrShortMessage},
+		{"bad magic", decodeReq, badMagic, ErrBadMagic},
+		{"bad version", decodeReq, badVersion, ErrBadVersion},
+		{"response as request", decodeReq, resp, ErrBadType},
+		{"request as response", decodeResp, req, ErrBadType},
+		{"truncated response", decodeResp, resp[:PingResponseSize-8], ErrShortMessage},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.decode(tc.input); !errors.Is(err, tc.want) {
+				t.Errorf("err = %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func decodeReq(b []byte) error {
+	_, err := DecodePingRequest(b)
+	return err
+}
+
+func decodeResp(b []byte) error {
+	_, err := DecodePingResponse(b)
+	return err
+}
+
+// Returns `b` cut to `size` bytes with the header bytes decoders ignore cleared, which is what
+// encoding the decoded message gives back.
+func canonical(b []byte, size int, keepFlags bool) []byte {
+	out := append([]byte(nil), b[:min(len(b), size)]...)
+	if !keepFlags {
+		out[6] = 0
+	}
+	out[7] = 0
+	return out
+}
+
+func FuzzPingRequest(f *testing.F) {
+	f.Add(EncodePingRequest(PingRequest{Nonce: 1, ClientSend: time.Unix(0, 1718000000123456789)}))
+	f.Add(EncodePingRequest(PingRequest{Nonce: 2, Region: "EUW"}))
+	f.Add(make([]byte, PingV1Size))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		req, err := DecodePingRequest(b)
+		if err != nil {
+			return
+		}
+		if IsPingV1Request(b) {
+			t.Fatalf("%x decoded as both a v1 and a v2 request", b)
+		}
+		if len(req.Region) > MaxRegionLen {
+			t.Fatalf("region %q longer than %d bytes", req.Region, MaxRegionLen)
+		}
+		got := EncodePingRequest(req)
+		if want := canonical(b, PingRequestSize, false); len(got) != MinPingRequestSize || !bytes.Equal(got[:PingRequestSize], want) {
+			t.Fatalf("re-encoded %x as %x", b, got)
+		}
+		if again, err := DecodePingRequest(got); err != nil || again != req {
+			t.Fatalf("decoded %x as %+v, %v; want %+v", got, again, err, req)
+		}
+	})
+}
+
+func FuzzPingResponse(f *testing.F) {
+	f.Add(EncodePingResponse(PingResponse{Nonce: 1, ProxyRecv: time.Unix(0, 10), ProxySend: time.Unix(0, 20), Upstream: 30 * time.Millisecond}))
+	f.Add(EncodePingResponse(PingResponse{Nonce: 2, Flags: FlagUpstreamStale | FlagUpstreamUnknown}))
+	f.Add(EncodePingV1Response(25 * time.Millisecond))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if _, err := DecodePingV1Response(b); (err == nil) != (len(b) >= PingV1Size) {
+			t.Fatalf("DecodePingV1Response(%x) = %v", b, err)
+		}
+		resp, err := DecodePingResponse(b)
+		if err != nil {
+			return
+		}
+		if got, want := EncodePingResponse(resp), canonical(b, PingResponseSize, true); !bytes.Equal(got, want) {
+			t.Fatalf("re-encoded %x as %x", want, got)
+		}
+	})
+}
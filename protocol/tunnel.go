@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"encoding/binary"
+)
+
+// Messages of the optional encrypted transport, see package tunnel for the cryptography. The client
+// starts a Noise handshake with a HandshakeInit sent to the relay listener; the proxy answers with a
+// HandshakeResponse carrying the index the client must put in every TunnelData it sends afterwards:
+//
+//	HandshakeInit:     header(8) | noise message
+//	HandshakeResponse: header(8) | index(4) | noise message
+//	TunnelData:        header(8) | index(4) | counter(8) | ciphertext | tag(16)
+const (
+	TypeHandshakeInit     uint8 = 8
+	TypeHandshakeResponse uint8 = 9
+	TypeTunnelData        uint8 = 10
+)
+
+const (
+	// TunnelOverhead is how many bytes the encrypted transport adds to every packet.
+	TunnelOverhead = tunnelDataHeaderSize + 16
+
+	tunnelDataHeaderSize = HeaderSize + 4 + 8
+)
+
+// TunnelData is an encrypted packet. Counter is the nonce it was sealed with.
+type TunnelData struct {
+	Index      uint32
+	Counter    uint64
+	Ciphertext []byte // includes the tag
+}
+
+func EncodeHandshakeInit(noise []byte) []byte {
+	b := make([]byte, HeaderSize, HeaderSize+len(noise))
+	putHeader(b, Version2, TypeHandshakeInit, 0)
+	return append(b, noise...)
+}
+
+// Returns the noise message of a HandshakeInit. The returned slice aliases `b`.
+func DecodeHandshakeInit(b []byte) ([]byte, error) {
+	if err := checkV2(b, TypeHandshakeInit, HeaderSize+1); err != nil {
+		return nil, err
+	}
+	return b[HeaderSize:], nil
+}
+
+func EncodeHandshakeResponse(index uint32, noise []byte) []byte {
+	b := make([]byte, HeaderSize, HeaderSize+4+len(noise))
+	putHeader(b, Version2, TypeHandshakeResponse, 0)
+	b = binary.BigEndian.AppendUint32(b, index)
+	return append(b, noise...)
+}
+
+// Returns the index and noise message of a HandshakeResponse. The returned slice aliases `b`.
+func DecodeHandshakeResponse(b []byte) (uint32, []byte, error) {
+	if err := checkV2(b, TypeHandshakeResponse, HeaderSize+4+1); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(b[HeaderSize:]), b[HeaderSize+4:], nil
+}
+
+// Returns the header of a TunnelData (everything before the ciphertext), meant to be
+// authenticated along with it. Sealing appends the ciphertext to it.
+func TunnelDataHeader(index uint32, counter uint64, capacity int) []byte {
+	b := make([]byte, HeaderSize, tunnelDataHeaderSize+capacity)
+	putHeader(b, Version2, TypeTunnelData, 0)
+	b = binary.BigEndian.AppendUint32(b, index)
+	return binary.BigEndian.AppendUint64(b, counter)
+}
+
+// Splits a TunnelData into its fields. The ciphertext aliases `b`, the header is b[:len(b)-len(Ciphertext)].
+func DecodeTunnelData(b []byte) (TunnelData, error) {
+	if err := checkV2(b, TypeTunnelData, TunnelOverhead); err != nil {
+		return TunnelData{}, err
+	}
+	return TunnelData{
+		Index:      binary.BigEndian.Uint32(b[HeaderSize:]),
+		Counter:    binary.BigEndian.Uint64(b[HeaderSize+4:]),
+		Ciphertext: b[tunnelDataHeaderSize:],
+	}, nil
+}
@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzHandshake(f *testing.F) {
+	f.Add(EncodeHandshakeInit([]byte("noise init")))
+	f.Add(EncodeHandshakeResponse(7, []byte("noise response")))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if noise, err := DecodeHandshakeInit(b); err == nil {
+			if got, want := EncodeHandshakeInit(noise), canonical(b, len(b), false); !bytes.Equal(got, want) {
+				t.Fatalf("re-encoded init %x as %x", want, got)
+			}
+		}
+		if index, noise, err := DecodeHandshakeResponse(b); err == nil {
+			if got, want := EncodeHandshakeResponse(index, noise), canonical(b, len(b), false); !bytes.Equal(got, want) {
+				t.Fatalf("re-encoded response %x as %x", want, got)
+			}
+		}
+	})
+}
+
+func FuzzTunnelData(f *testing.F) {
+	f.Add(append(TunnelDataHeader(7, 1, 32), make([]byte, 32)...))
+	f.Add(append(TunnelDataHeader(0, 1<<63, TagSize), make([]byte, TagSize)...))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := DecodeTunnelData(b)
+		if err != nil {
+			return
+		}
+		if len(msg.Ciphertext) < TagSize {
+			t.Fatalf("ciphertext %x shorter than a tag", msg.Ciphertext)
+		}
+		header := TunnelDataHeader(msg.Index, msg.Counter, len(msg.Ciphertext))
+		if got, want := append(header, msg.Ciphertext...), canonical(b, len(b), false); !bytes.Equal(got, want) {
+			t.Fatalf("re-encoded %x as %x", want, got)
+		}
+	})
+}
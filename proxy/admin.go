@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+)
+
+// UpstreamStats is the JSON view of an UpstreamEstimate.
+type UpstreamStats struct {
+	LatencyMs float64   `json:"latency_ms"`
+	Samples   int       `json:"samples"`
+	Updated   time.Time `json:"updated"`
+	Stale     bool      `json:"stale"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// RateStats is the JSON view of a Rate. Zero is unlimited.
+type RateStats struct {
+	Packets float64 `json:"packets_per_second"`
+	Bytes   float64 `json:"bytes_per_second"`
+}
+
+// LimitsStats is the JSON view of the Limits the server enforces, defaults filled in.
+type LimitsStats struct {
+	Client             RateStats `json:"client"`
+	Session            RateStats `json:"session"`
+	Global             RateStats `json:"global"`
+	BanAfter           int       `json:"ban_after"` // 0 never bans
+	BanWindowSeconds   float64   `json:"ban_window_seconds"`
+	BanDurationSeconds float64   `json:"ban_duration_seconds"`
+}
+
+// StatsReport is what the admin /stats endpoint returns.
+type StatsReport struct {
+	Started       time.Time                 `json:"started"`
+	UptimeSeconds float64                   `json:"uptime_seconds"`
+	Limits        LimitsStats               `json:"limits"`
+	Server        ServerStats               `json:"server"`
+	Sessions      []SessionStats            `json:"sessions"`
+	Upstream      map[string]*UpstreamStats `json:"upstream"` // by region, null until the first successful sample
+}
+
+// Returns the current state of the server, as served on /stats.
+func (s *Server) Report() StatsReport {
+	report := StatsReport{
+		Started:       s.started,
+		UptimeSeconds: time.Since(s.started).Seconds(),
+		Limits: LimitsStats{
+			Client:             RateStats(s.opts.Limits.Client),
+			Session:            RateStats(s.opts.Limits.Session),
+			Global:             RateStats(s.opts.Limits.Global),
+			BanAfter:           s.opts.Limits.BanAfter,
+			BanWindowSeconds:   s.opts.Limits.BanWindow.Seconds(),
+			BanDurationSeconds: s.opts.Limits.BanDuration.Seconds(),
+		},
+		Server:   s.Stats(),
+		Sessions: s.Sessions(),
+		Upstream: make(map[string]*UpstreamStats, len(s.opts.Regions)),
+	}
+	for _, region := range s.opts.Regions {
+		estimate, ok := s.estimator.Estimate(region)
+		if !ok {
+			report.Upstream[region] = nil
+			continue
+		}
+		stats := &UpstreamStats{
+			LatencyMs: float64(estimate.Latency) / float64(time.Millisecond),
+			Samples:   estimate.Samples,
+			Updated:   estimate.Updated,
+			Stale:     estimate.Stale,
+		}
+		if estimate.LastErr != nil {
+			stats.LastError = estimate.LastErr.Error()
+		}
+		report.Upstream[region] = stats
+	}
+	return report
+}
+
+// Reports why the server can't relay and answer pings properly yet, nil once it can.
+func (s *Server) notReady() error {
+	if !s.serving.Load() {
+		return errors.New("not serving")
+	}
+	if _, ok := s.estimator.Estimate(s.opts.Regions[0]); !ok {
+		return fmt.Errorf("no upstream estimate for %s yet", s.opts.Regions[0])
+	}
+	return nil
+}
+
+// Returns the handler of the admin endpoints:
+//   - /healthz answers 200 as long as the server is serving.
+//   - /readyz answers 200 once it also has an upstream estimate for its default region, 503 before.
+//   - /stats returns a StatsReport as JSON.
+//   - /metrics returns the same in the Prometheus text format, see Metrics.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.serving.Load() {
+			http.Error(w, "not serving", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.notReady(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(s.Report())
+	})
+	mux.Handle("GET /metrics", s.metrics.Handler())
+	return mux
+}
+
+// Serves the admin endpoints on `ln` until ctx is done.
+func (s *Server) serveAdmin(ctx context.Context, ln net.Listener) {
+	srv := &http.Server{Handler: s.AdminHandler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.log.Error("admin endpoint failed", logging.KeyError, err)
+	}
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminEndpoints(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) {
+		opts.AdminAddr = "127.0.0.1:0"
+		opts.Limits = Limits{Client: Rate{Packets: 500}, Global: Rate{Bytes: 1 << 20}, BanAfter: 5}
+	})
+	if err := srv.OpenSession(Session{ID: "s", GameAddr: listenLoopback(t).LocalAddr().(*net.UDPAddr), ClientAddr: listenLoopback(t).LocalAddr().(*net.UDPAddr)}); err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	base := "http://" + srv.AdminAddr().String()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s = %d; want 200", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(base + "/stats")
+	if err != nil {
+		t.Fatalf("GET /stats: %v", err)
+	}
+	defer resp.Body.Close()
+	var report StatsReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding /stats: %v", err)
+	}
+	if report.Server.Sessions != 1 || len(report.Sessions) != 1 || report.Sessions[0].ID != "s" {
+		t.Errorf("stats sessions = %d, %+v; want session s", report.Server.Sessions, report.Sessions)
+	}
+	if na := report.Upstream["NA"]; na == nil || na.LatencyMs < 10 {
+		t.Errorf("NA upstream = %+v; want at least the 10ms the upstream sleeps", na)
+	}
+	want := LimitsStats{Client: RateStats{Packets: 500}, Global: RateStats{Bytes: 1 << 20}, BanAfter: 5, BanWindowSeconds: 10, BanDurationSeconds: 300}
+	if report.Limits != want {
+		t.Errorf("limits = %+v; want %+v", report.Limits, want)
+	}
+	if report.UptimeSeconds <= 0 || report.Started.IsZero() {
+		t.Errorf("uptime = %v since %v", report.UptimeSeconds, report.Started)
+	}
+
+	resp, err = http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading /metrics: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE lolmp_proxy_session_packets_to_game_total counter\n",
+		`lolmp_proxy_session_packets_to_game_total{session="s",client=""} 0` + "\n",
+		"lolmp_proxy_sessions 1\n",
+		`lolmp_proxy_upstream_stale{region="NA"} 0` + "\n",
+		`lolmp_proxy_upstream_latency_seconds{region="NA"} `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestAdminNotServing(t *testing.T) {
+	srv, err := NewServer(Options{ListenAddr: "127.0.0.1:0", PingListenAddr: "127.0.0.1:0", Regions: []string{"NA"}})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s before Serve = %d; want 503", path, rec.Code)
+		}
+	}
+}
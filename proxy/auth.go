@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+)
+
+// Identity of the client a packet was authenticated as. It is the zero value when authentication is off.
+type client struct {
+	id   protocol.KeyID
+	name string
+}
+
+// Checks a client packet, authenticated with a pre-shared key or encrypted in a tunnel, and returns the
+// packet it carries and who sent it. Without keys every packet is accepted as is. Packets that fail the
+// check are counted as rejected and must be dropped without an answer, so probing the proxy reveals nothing.
+func (s *Server) authenticate(b []byte) ([]byte, client, bool) {
+	if s.opts.Keys == nil && s.opts.TunnelKey == nil {
+		return b, client{}, true
+	}
+
+	if h, err := protocol.ParseHeader(b); err == nil && h.Type == protocol.TypeTunnelData && s.opts.TunnelKey != nil {
+		inner, c, ok := s.openTunnel(b)
+		if !ok {
+			s.rejected.Add(1)
+		}
+		return inner, c, ok
+	}
+	if s.opts.Keys == nil {
+		s.rejected.Add(1)
+		return nil, client{}, false
+	}
+
+	id, err := protocol.AuthenticatedKeyID(b)
+	if err != nil {
+		s.rejected.Add(1)
+		return nil, client{}, false
+	}
+	key, ok := s.opts.Keys.Lookup(id)
+	if !ok {
+		s.rejected.Add(1)
+		return nil, client{}, false
+	}
+	inner, sealed, counter, err := protocol.OpenAuthenticated(key.Secret, b)
+	if err != nil {
+		s.rejected.Add(1)
+		return nil, client{}, false
+	}
+	if skew := time.Since(sealed); skew > s.opts.MaxClockSkew || skew < -s.opts.MaxClockSkew {
+		s.rejected.Add(1)
+		return nil, client{}, false
+	}
+	if !s.replayWindow(id).Accept(counter) {
+		s.rejected.Add(1)
+		return nil, client{}, false
+	}
+	return inner, client{id: id, name: key.Name}, true
+}
+
+// Returns the replay window of the key `id`, creating it on first use. Only keys in the ring get one.
+func (s *Server) replayWindow(id protocol.KeyID) *tunnel.ReplayWindow {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	w, ok := s.replay[id]
+	if !ok {
+		w = new(tunnel.ReplayWindow)
+		s.replay[id] = w
+	}
+	return w
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+var (
+	alice = keys.Key{Name: "alice", Secret: []byte("alice's secret, long enough")}
+	bob   = keys.Key{Name: "bob", Secret: []byte("bob's secret, also long enough")}
+)
+
+var counter atomic.Uint64
+
+func sealed(key keys.Key, b []byte) []byte {
+	return protocol.SealAuthenticated(key.Secret, time.Now(), counter.Add(1), b)
+}
+
+func TestAuthenticatedPing(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) { opts.Keys = keys.NewRing([]keys.Key{alice}) })
+
+	conn, err := net.Dial("udp", srv.PingAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := protocol.EncodePingRequest(protocol.PingRequest{Nonce: 5, ClientSend: time.Now()})
+	old := protocol.SealAuthenticated(alice.Secret, time.Now().Add(-time.Hour), counter.Add(1), req)
+	forged := sealed(alice, req)
+	forged[len(forged)-1] ^= 1
+	for _, b := range [][]byte{req, sealed(bob, req), old, forged} {
+		if _, err := conn.Write(b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	buf := make([]byte, 512)
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Fatalf("unauthenticated ping was answered: %x", buf[:n])
+	}
+
+	resp, err := protocol.DecodePingResponse(exchange(t, conn, sealed(alice, req)))
+	if err != nil || resp.Nonce != 5 {
+		t.Fatalf("DecodePingResponse = %+v, %v", resp, err)
+	}
+	if got := srv.Stats().Rejected; got != 4 {
+		t.Errorf("Rejected = %d; want 4", got)
+	}
+}
+
+func TestAuthenticatedSessions(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) { opts.Keys = keys.NewRing([]keys.Key{alice, bob}) })
+	game := listenLoopback(t)
+	gameClient := listenLoopback(t)
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("udp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	alicePath, bobPath := dial(), dial()
+
+	open := protocol.EncodeSessionOpen(protocol.SessionOpen{
+		SessionID:  "alice-game",
+		GameAddr:   game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: gameClient.LocalAddr().(*net.UDPAddr),
+	})
+	if ack := control(t, alicePath, sealed(alice, open)); ack.Status != protocol.StatusOK {
+		t.Fatalf("open ack = %+v", ack)
+	}
+	// bob can't attach to alice's session, even knowing its ID and addresses
+	if ack := control(t, bobPath, sealed(bob, open)); ack.Status != protocol.StatusSessionExists {
+		t.Errorf("bob attaching: status = %d; want %d", ack.Status, protocol.StatusSessionExists)
+	}
+
+	// unauthenticated data and data sealed by bob from alice's path are dropped
+	for _, b := range [][]byte{[]byte("spoofed"), sealed(bob, []byte("spoofed"))} {
+		if _, err := alicePath.Write(b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if _, err := alicePath.Write(sealed(alice, []byte("move"))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 512)
+	_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := game.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "move" {
+		t.Fatalf("game server read = %q, %v; want \"move\"", buf[:n], err)
+	}
+
+	stats := srv.Sessions()
+	if len(stats) != 1 || stats[0].Client != "alice" || stats[0].PacketsToGame != 1 {
+		t.Errorf("Sessions() = %+v; want alice's session with 1 packet", stats)
+	}
+	if got := srv.Stats().Rejected; got != 1 {
+		t.Errorf("Rejected = %d; want 1 (the unauthenticated packet)", got)
+	}
+}
+
+func TestAuthenticatedReplay(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) { opts.Keys = keys.NewRing([]keys.Key{alice}) })
+	game := listenLoopback(t)
+	gameClient := listenLoopback(t)
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("udp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	alicePath, attacker := dial(), dial()
+
+	open := sealed(alice, protocol.EncodeSessionOpen(protocol.SessionOpen{
+		SessionID:  "alice-game",
+		GameAddr:   game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: gameClient.LocalAddr().(*net.UDPAddr),
+	}))
+	if ack := control(t, alicePath, open); ack.Status != protocol.StatusOK {
+		t.Fatalf("open ack = %+v", ack)
+	}
+
+	// a sniffed SessionOpen replayed from elsewhere doesn't bind the attacker's address to the session
+	if _, err := attacker.Write(open); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 512)
+	_ = attacker.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := attacker.Read(buf); err == nil {
+		t.Fatalf("replayed SessionOpen was answered: %x", buf[:n])
+	}
+
+	// a data packet replayed from alice's own path reaches the game server once
+	move := sealed(alice, []byte("move"))
+	for range 2 {
+		if _, err := alicePath.Write(move); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, _, err := game.ReadFromUDP(buf); err != nil || string(buf[:n]) != "move" {
+		t.Fatalf("game server read = %q, %v; want \"move\"", buf[:n], err)
+	}
+	_ = game.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := game.ReadFromUDP(buf); err == nil {
+		t.Fatalf("replayed packet reached the game server: %q", buf[:n])
+	}
+
+	stats := srv.Sessions()
+	if len(stats) != 1 || stats[0].Paths != 1 || stats[0].PacketsToGame != 1 {
+		t.Errorf("Sessions() = %+v; want 1 path and 1 packet", stats)
+	}
+	if got := srv.Stats().Rejected; got != 2 {
+		t.Errorf("Rejected = %d; want 2 (the replays)", got)
+	}
+}
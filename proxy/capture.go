@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/capture"
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+)
+
+// Interfaces of a session capture, the paths come after them.
+const (
+	captureGame   = 0 // between the proxy and the game server, both ways
+	captureClient = 1 // from the proxy to the game client
+)
+
+// Capture of a session's traffic in Options.CaptureDir. A nil *sessionCapture captures nothing.
+type sessionCapture struct {
+	w        *capture.Writer
+	log      *slog.Logger
+	listen   netip.AddrPort // the relay listener
+	upstream netip.AddrPort // the session's socket to the game server
+	game     netip.AddrPort
+
+	mu    sync.Mutex
+	paths map[netip.AddrPort]int // interface of each client source address
+}
+
+// Creates the capture of `sess` if Options.CaptureDir is set; a capture that can't be created is logged and skipped.
+func (s *Server) startCapture(sess *session) *sessionCapture {
+	if s.opts.CaptureDir == "" {
+		return nil
+	}
+	log := s.log.With(logging.KeySession, sess.ID)
+	upstream, _ := sess.upstream.LocalAddr().(*net.UDPAddr)
+	w, err := capture.Create(s.opts.CaptureDir, sess.ID, sess.opened, []capture.Interface{
+		captureGame:   {Name: "game", Description: upstream.String() + " ↔ " + sess.GameAddr.String()},
+		captureClient: {Name: "client", Description: "to the game client"},
+	})
+	if err != nil {
+		log.Error("failed to create the session capture", logging.KeyError, err)
+		return nil
+	}
+	return &sessionCapture{
+		w:        w,
+		log:      log,
+		listen:   s.conn.LocalAddr().(*net.UDPAddr).AddrPort(),
+		upstream: upstream.AddrPort(),
+		game:     sess.GameAddr.AddrPort(),
+		paths:    make(map[netip.AddrPort]int),
+	}
+}
+
+func (c *sessionCapture) write(iface int, at time.Time, src, dst netip.AddrPort, payload []byte) {
+	if err := c.w.WritePacket(iface, at, src, dst, payload); err != nil {
+		c.log.Debug("failed to capture a packet", logging.KeyError, err)
+	}
+}
+
+// Captures `wire`, a copy that arrived from the path `src` at `at`, before it is deduplicated.
+func (c *sessionCapture) arrived(src netip.AddrPort, at time.Time, wire []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	iface, ok := c.paths[src]
+	if !ok {
+		var err error
+		if iface, err = c.w.AddInterface(capture.Interface{Name: "path " + src.String(), Description: "copies from " + src.String()}); err != nil {
+			c.mu.Unlock()
+			c.log.Debug("failed to add a path to the capture", logging.KeyError, err)
+			return
+		}
+		c.paths[src] = iface
+	}
+	c.mu.Unlock()
+	c.write(iface, at, src, c.listen, wire)
+}
+
+// Captures a packet forwarded to the game server.
+func (c *sessionCapture) toGame(at time.Time, payload []byte) {
+	if c == nil {
+		return
+	}
+	c.write(captureGame, at, c.upstream, c.game, payload)
+}
+
+// Captures a packet received from the game server.
+func (c *sessionCapture) fromGame(at time.Time, payload []byte) {
+	if c == nil {
+		return
+	}
+	c.write(captureGame, at, c.game, c.upstream, payload)
+}
+
+// Captures a packet relayed to the game client at `client`.
+func (c *sessionCapture) toClient(at time.Time, client netip.AddrPort, payload []byte) {
+	if c == nil {
+		return
+	}
+	c.write(captureClient, at, c.listen, client, payload)
+}
+
+func (c *sessionCapture) close() {
+	if c == nil {
+		return
+	}
+	if err := c.w.Close(); err != nil {
+		c.log.Error("failed to close the session capture", logging.KeyError, err)
+	}
+}
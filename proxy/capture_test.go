@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestSessionCapture(t *testing.T) {
+	dir := t.TempDir()
+	srv := startTestServer(t, func(opts *Options) { opts.CaptureDir = dir })
+
+	game := listenLoopback(t)
+	gameClient := listenLoopback(t)
+	err := srv.OpenSession(Session{
+		ID:         "game",
+		GameAddr:   game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: gameClient.LocalAddr().(*net.UDPAddr),
+	})
+	if err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+
+	// two paths sending the same packet, both copies are captured
+	for range 2 {
+		path, err := net.Dial("udp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer path.Close()
+		if _, err := path.Write([]byte("move")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	buf := make([]byte, 512)
+	_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, proxyAddr, err := game.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("game server read: %v", err)
+	}
+	if _, err := game.WriteToUDP([]byte("state"), proxyAddr); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = gameClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := gameClient.ReadFromUDP(buf); err != nil {
+		t.Fatalf("game client read: %v", err)
+	}
+	if stats := srv.Sessions(); len(stats) != 1 || stats[0].Duplicates != 1 {
+		t.Fatalf("sessions = %+v, want the duplicate counted", stats)
+	}
+	srv.CloseSession("game")
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*-game.pcapng"))
+	if len(files) != 1 {
+		t.Fatalf("capture files = %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatalf("NewNgReader: %v", err)
+	}
+	payloads := make(map[int][]string)
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		udp, _ := gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default).Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if udp == nil {
+			t.Fatalf("captured packet without UDP: %x", data)
+		}
+		payloads[ci.InterfaceIndex] = append(payloads[ci.InterfaceIndex], string(udp.Payload))
+	}
+	if r.NInterfaces() != 4 {
+		t.Fatalf("capture has %d interfaces, want game, client and two paths", r.NInterfaces())
+	}
+	want := map[int][]string{
+		captureGame:   {"move", "state"},
+		captureClient: {"state"},
+		2:             {"move"},
+		3:             {"move"},
+	}
+	for iface, w := range want {
+		if got := payloads[iface]; !slices.Equal(got, w) {
+			t.Errorf("interface %d captured %q, want %q", iface, got, w)
+		}
+	}
+}
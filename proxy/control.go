@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+// Features this proxy supports, advertised in every SessionAck.
+const capabilities = protocol.FeatureDedupe | protocol.FeaturePingV2 | protocol.FeatureMultiSession
+
+var (
+	errSessionExists   = errors.New("session already exists")
+	errTooManySessions = errors.New("too many sessions")
+	errUnknownSession  = errors.New("unknown session")
+
+	errDestinationNotAllowed = errors.New("destination not allowed")
+	errTooManyDestinations   = errors.New("too many destinations")
+	errClientAddrNotAllowed  = errors.New("the game client must listen on the IP of one of the session's paths")
+)
+
+// Handles a control message `c` sent from `src` to the relay listener and answers it with a SessionAck.
+// Opening a session binds `src` to it, so every path of the client opens (or attaches to) the session
+// from its own socket. Updates and closes are only accepted from paths bound to the session, and only
+// the client that opened a session can attach to, update or close it.
+func (s *Server) handleControl(src netip.AddrPort, c client, b []byte) {
+	h, err := protocol.ParseHeader(b)
+	if err != nil {
+		return
+	}
+
+	var ack protocol.SessionAck
+	switch h.Type {
+	case protocol.TypeSessionOpen, protocol.TypeSessionUpdate:
+		msg, err := protocol.DecodeSessionOpen(b)
+		if err != nil {
+			s.log.Warn("dropping malformed control message", "src", src, logging.KeyError, err)
+			return
+		}
+		ack = protocol.SessionAck{Nonce: msg.Nonce, SessionID: msg.SessionID}
+		cfg := Session{ID: msg.SessionID, GameAddr: msg.GameAddr, ClientAddr: msg.ClientAddr, ClientIPs: msg.ClientIPs}
+		if h.Type == protocol.TypeSessionOpen {
+			err = s.openFrom(src, c, cfg)
+		} else {
+			err = s.updateFrom(src, c, cfg)
+		}
+		setStatus(&ack, err)
+	case protocol.TypeSessionClose:
+		msg, err := protocol.DecodeSessionClose(b)
+		if err != nil {
+			s.log.Warn("dropping malformed control message", "src", src, logging.KeyError, err)
+			return
+		}
+		ack = protocol.SessionAck{Nonce: msg.Nonce, SessionID: msg.SessionID}
+		if !s.closeFrom(src, c, msg.SessionID) {
+			ack.Status = protocol.StatusUnknownSession
+		}
+	default:
+		return // acks are only sent by proxies
+	}
+
+	ack.Capabilities = capabilities
+	ack.MaxPacketSize = uint16(min(s.opts.MaxPacketSize, 0xffff))
+	ack.IdleTimeout = uint32(s.opts.IdleTimeout / time.Second)
+	if _, err := s.conn.WriteToUDPAddrPort(protocol.EncodeSessionAck(ack), src); err != nil {
+		s.log.Warn("failed to acknowledge control message", "src", src, logging.KeyError, err)
+	}
+}
+
+func setStatus(ack *protocol.SessionAck, err error) {
+	switch {
+	case err == nil:
+		ack.Status = protocol.StatusOK
+	case errors.Is(err, errSessionExists):
+		ack.Status = protocol.StatusSessionExists
+	case errors.Is(err, errTooManySessions):
+		ack.Status = protocol.StatusTooManySessions
+	case errors.Is(err, errUnknownSession):
+		ack.Status = protocol.StatusUnknownSession
+	case errors.Is(err, errDestinationNotAllowed):
+		ack.Status = protocol.StatusDestinationNotAllowed
+	case errors.Is(err, errTooManyDestinations):
+		ack.Status = protocol.StatusTooManyDestinations
+	default:
+		ack.Status = protocol.StatusRejected
+	}
+	if err != nil {
+		ack.Message = err.Error()
+	}
+}
+
+// Opens the session if needed and binds `src` to it. Opening an existing session with the same game
+// and client addresses only binds `src`, so retransmits and the client's other paths are harmless.
+func (s *Server) openFrom(src netip.AddrPort, c client, cfg Session) error {
+	s.mu.RLock()
+	reachable := s.reachable(cfg.ClientAddr, src, s.sessions[cfg.ID])
+	s.mu.RUnlock()
+	if !reachable {
+		return fmt.Errorf("session %s: %w", cfg.ID, errClientAddrNotAllowed)
+	}
+
+	err := s.openSession(cfg, c, src.Addr().Unmap())
+	if errors.Is(err, errSessionExists) {
+		err = nil
+		s.mu.RLock()
+		sess := s.sessions[cfg.ID]
+		if sess == nil || sess.owner != c || !sameUDPAddr(sess.GameAddr, cfg.GameAddr) || !sameUDPAddr(sess.ClientAddr, cfg.ClientAddr) {
+			err = fmt.Errorf("session %s: %w with other addresses", cfg.ID, errSessionExists)
+		}
+		s.mu.RUnlock()
+	}
+	if err != nil {
+		return err
+	}
+	return s.bindPath(src, cfg.ID)
+}
+
+// Replaces the client side of a session `src` is bound to. The game address can't change, since the
+// game server knows the session by its upstream socket: a new game needs a new session.
+func (s *Server) updateFrom(src netip.AddrPort, c client, cfg Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[cfg.ID]
+	if !ok || s.paths[src] != sess || !sess.allows(c) {
+		return fmt.Errorf("session %s: %w", cfg.ID, errUnknownSession)
+	}
+	if !sameUDPAddr(sess.GameAddr, cfg.GameAddr) {
+		return fmt.Errorf("session %s: the game address can't change, open a new session", cfg.ID)
+	}
+	if !s.reachable(cfg.ClientAddr, src, sess) {
+		return fmt.Errorf("session %s: %w", cfg.ID, errClientAddrNotAllowed)
+	}
+	sess.ClientAddr = cfg.ClientAddr
+	sess.ClientIPs = cfg.ClientIPs
+	sess.touch()
+	s.log.Info("session updated", logging.KeySession, cfg.ID, "game_client", cfg.ClientAddr)
+	return nil
+}
+
+// Closes the session `id` if `src` is one of its paths.
+func (s *Server) closeFrom(src netip.AddrPort, c client, id string) bool {
+	s.mu.RLock()
+	sess, ok := s.sessions[id]
+	bound := ok && s.paths[src] == sess && sess.allows(c)
+	s.mu.RUnlock()
+	return bound && s.CloseSession(id)
+}
+
+// Binds the client source address `src` to the session `id`, moving it away from any other session.
+func (s *Server) bindPath(src netip.AddrPort, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("session %s: %w", id, errUnknownSession)
+	}
+	if prev, ok := s.paths[src]; ok {
+		if prev == sess {
+			sess.touch()
+			return nil
+		}
+		prev.paths.Add(-1)
+	}
+	s.paths[src] = sess
+	sess.paths.Add(1)
+	sess.touch()
+	return nil
+}
+
+// Reports whether the game client address `addr` a control message from `src` asks for is on the IP of
+// `src` or of another path bound to `sess`, which may be nil. Otherwise anyone could point a session's
+// game server at a third party. Callers hold s.mu.
+func (s *Server) reachable(addr *net.UDPAddr, src netip.AddrPort, sess *session) bool {
+	if addr == nil {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	if ip == src.Addr().Unmap() {
+		return true
+	}
+	for path, bound := range s.paths {
+		if sess != nil && bound == sess && path.Addr().Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+func control(t *testing.T, conn net.Conn, msg []byte) protocol.SessionAck {
+	t.Helper()
+	ack, err := protocol.DecodeSessionAck(exchange(t, conn, msg))
+	if err != nil {
+		t.Fatalf("DecodeSessionAck: %v", err)
+	}
+	return ack
+}
+
+func TestControlSessionLifecycle(t *testing.T) {
+	srv := startTestServer(t)
+	game := listenLoopback(t)
+	client := listenLoopback(t)
+	movedClient := listenLoopback(t)
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("udp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	pathA, pathB, stranger := dial(), dial(), dial()
+
+	open := protocol.SessionOpen{
+		Nonce:      1,
+		SessionID:  "remote",
+		GameAddr:   game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: client.LocalAddr().(*net.UDPAddr),
+		// no path sends from this IP: only the control channel can bind them
+		ClientIPs: []net.IP{net.IPv4(192, 0, 2, 1)},
+		Features:  protocol.FeatureDedupe,
+	}
+	for _, path := range []net.Conn{pathA, pathB} {
+		ack := control(t, path, protocol.EncodeSessionOpen(open))
+		if ack.Status != protocol.StatusOK || ack.Nonce != 1 || ack.SessionID != "remote" {
+			t.Fatalf("open ack = %+v", ack)
+		}
+		if ack.Capabilities&protocol.FeatureDedupe == 0 || ack.MaxPacketSize == 0 || ack.IdleTimeout == 0 {
+			t.Errorf("ack doesn't describe the proxy: %+v", ack)
+		}
+	}
+	if stats := srv.Sessions(); len(stats) != 1 || stats[0].Paths != 2 {
+		t.Fatalf("Sessions() = %+v; want one session with 2 paths", stats)
+	}
+
+	hijack := open
+	hijack.ClientAddr = movedClient.LocalAddr().(*net.UDPAddr)
+	if ack := control(t, stranger, protocol.EncodeSessionOpen(hijack)); ack.Status != protocol.StatusSessionExists {
+		t.Errorf("opening with other addresses: status = %d; want %d", ack.Status, protocol.StatusSessionExists)
+	}
+	if ack := control(t, stranger, protocol.EncodeSessionUpdate(hijack)); ack.Status != protocol.StatusUnknownSession {
+		t.Errorf("update from an unbound path: status = %d; want %d", ack.Status, protocol.StatusUnknownSession)
+	}
+	if ack := control(t, stranger, protocol.EncodeSessionClose(protocol.SessionClose{SessionID: "remote"})); ack.Status != protocol.StatusUnknownSession {
+		t.Errorf("close from an unbound path: status = %d; want %d", ack.Status, protocol.StatusUnknownSession)
+	}
+
+	if _, err := pathA.Write([]byte("move")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 512)
+	_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, proxyAddr, err := game.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "move" {
+		t.Fatalf("game server read = %q, %v; want \"move\"", buf[:n], err)
+	}
+
+	if ack := control(t, pathB, protocol.EncodeSessionUpdate(hijack)); ack.Status != protocol.StatusOK {
+		t.Fatalf("update ack = %+v", ack)
+	}
+	if _, err := game.WriteToUDP([]byte("state"), proxyAddr); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got := readString(t, movedClient); got != "state" {
+		t.Errorf("moved client read %q; want \"state\"", got)
+	}
+
+	otherGame := hijack
+	otherGame.GameAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	if ack := control(t, pathB, protocol.EncodeSessionUpdate(otherGame)); ack.Status != protocol.StatusRejected || ack.Message == "" {
+		t.Errorf("changing the game address: ack = %+v; want a rejection", ack)
+	}
+
+	if ack := control(t, pathA, protocol.EncodeSessionClose(protocol.SessionClose{Nonce: 5, SessionID: "remote"})); ack.Status != protocol.StatusOK || ack.Nonce != 5 {
+		t.Errorf("close ack = %+v", ack)
+	}
+	if n := len(srv.Sessions()); n != 0 {
+		t.Errorf("len(Sessions()) = %d; want 0 after closing", n)
+	}
+}
+
+func TestControlClientAddr(t *testing.T) {
+	srv := startTestServer(t)
+	game := listenLoopback(t)
+	client := listenLoopback(t)
+
+	pathA, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer pathA.Close()
+
+	// without authentication anyone can open a session, but its game server can't be pointed at a third party
+	victim := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	reflect := protocol.SessionOpen{SessionID: "s", GameAddr: game.LocalAddr().(*net.UDPAddr), ClientAddr: victim}
+	if ack := control(t, pathA, protocol.EncodeSessionOpen(reflect)); ack.Status != protocol.StatusRejected {
+		t.Fatalf("opening towards another IP: ack = %+v; want a rejection", ack)
+	}
+	if n := len(srv.Sessions()); n != 0 {
+		t.Fatalf("len(Sessions()) = %d; want 0", n)
+	}
+
+	open := reflect
+	open.ClientAddr = client.LocalAddr().(*net.UDPAddr)
+	if ack := control(t, pathA, protocol.EncodeSessionOpen(open)); ack.Status != protocol.StatusOK {
+		t.Fatalf("open ack = %+v", ack)
+	}
+	if ack := control(t, pathA, protocol.EncodeSessionUpdate(reflect)); ack.Status != protocol.StatusRejected {
+		t.Errorf("moving the game client to another IP: ack = %+v; want a rejection", ack)
+	}
+	if _, err := pathA.Write([]byte("move")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 512)
+	_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, proxyAddr, err := game.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("game server read: %v", err)
+	}
+	if _, err := game.WriteToUDP([]byte("state"), proxyAddr); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got := readString(t, client); got != "state" {
+		t.Errorf("client read %q; want \"state\"", got)
+	}
+
+	// the IP of another path of the session is fine
+	otherIP := net.IPv4(127, 0, 0, 2)
+	pathB, err := net.DialUDP("udp", &net.UDPAddr{IP: otherIP}, srv.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Skipf("can't send from %v: %v", otherIP, err)
+	}
+	defer pathB.Close()
+	if ack := control(t, pathB, protocol.EncodeSessionOpen(open)); ack.Status != protocol.StatusOK {
+		t.Fatalf("open ack from %v = %+v", otherIP, ack)
+	}
+	moved := open
+	moved.ClientAddr = &net.UDPAddr{IP: otherIP, Port: 5000}
+	if ack := control(t, pathA, protocol.EncodeSessionUpdate(moved)); ack.Status != protocol.StatusOK {
+		t.Errorf("moving the game client to another path's IP: ack = %+v", ack)
+	}
+}
+
+func TestControlTooManySessions(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) { opts.MaxSessions = 1 })
+	game := listenLoopback(t)
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	for i, want := range []uint8{protocol.StatusOK, protocol.StatusTooManySessions} {
+		ack := control(t, conn, protocol.EncodeSessionOpen(protocol.SessionOpen{
+			SessionID:  string(rune('a' + i)),
+			GameAddr:   game.LocalAddr().(*net.UDPAddr),
+			ClientAddr: game.LocalAddr().(*net.UDPAddr),
+		}))
+		if ack.Status != want {
+			t.Errorf("session %d: status = %d; want %d", i, ack.Status, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DestinationRule allows game servers in Prefix listening on a port from MinPort to MaxPort.
+type DestinationRule struct {
+	Prefix           netip.Prefix
+	MinPort, MaxPort uint16
+}
+
+func (r DestinationRule) allows(addr netip.AddrPort) bool {
+	return r.Prefix.Contains(addr.Addr()) && addr.Port() >= r.MinPort && addr.Port() <= r.MaxPort
+}
+
+func (r DestinationRule) String() string {
+	return fmt.Sprintf("%v %d-%d", r.Prefix, r.MinPort, r.MaxPort)
+}
+
+// GameProfiles are the built-in destination allow-lists, by game. "lol" holds the ranges Riot announces
+// from AS6507 and the UDP ports its support pages give for the game client; check them against the
+// current ones before relying on them.
+var GameProfiles = map[string][]DestinationRule{
+	"lol": {
+		riotRule("104.160.128.0/19"),
+		riotRule("162.249.72.0/21"),
+		riotRule("185.40.64.0/22"),
+		riotRule("192.64.168.0/21"),
+		riotRule("43.229.64.0/22"),
+		riotRule("45.7.36.0/22"),
+	},
+}
+
+func riotRule(prefix string) DestinationRule {
+	return DestinationRule{Prefix: netip.MustParsePrefix(prefix), MinPort: 5000, MaxPort: 5500}
+}
+
+// Reports whether a session may relay to `game`. Without rules any destination is allowed.
+func allowedDestination(rules []DestinationRule, game *net.UDPAddr) bool {
+	if rules == nil {
+		return true
+	}
+	addr := game.AddrPort()
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+	for _, rule := range rules {
+		if rule.allows(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses destination profiles, one rule per line: the profile name, a CIDR and a port or port range.
+//
+//	# profile  cidr             ports
+//	lol        162.249.72.0/21  5000-5500
+//	mygame     203.0.113.0/24   7777
+func ParseDestinations(r io.Reader) (map[string][]DestinationRule, error) {
+	profiles := make(map[string][]DestinationRule)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want \"<profile> <cidr> <port or range>\"", line)
+		}
+		prefix, err := netip.ParsePrefix(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rule := DestinationRule{Prefix: prefix.Masked()}
+		if rule.MinPort, rule.MaxPort, err = parsePortRange(fields[2]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		profiles[fields[0]] = append(profiles[fields[0]], rule)
+	}
+	return profiles, scanner.Err()
+}
+
+func parsePortRange(s string) (uint16, uint16, error) {
+	low, high, isRange := strings.Cut(s, "-")
+	if !isRange {
+		high = low
+	}
+	minPort, err := strconv.ParseUint(low, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", low)
+	}
+	maxPort, err := strconv.ParseUint(high, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", high)
+	}
+	if minPort == 0 || minPort > maxPort {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return uint16(minPort), uint16(maxPort), nil
+}
+
+// Loads the destination profiles of the file at `path`, see ParseDestinations.
+func LoadDestinations(path string) (map[string][]DestinationRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profiles, err := ParseDestinations(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return profiles, nil
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+func TestParseDestinations(t *testing.T) {
+	profiles, err := ParseDestinations(strings.NewReader("# profile cidr ports\nmygame 203.0.113.7/24 7777\nmygame 2001:db8::/32 5000-5100\n"))
+	if err != nil {
+		t.Fatalf("ParseDestinations: %v", err)
+	}
+	rules := profiles["mygame"]
+	if len(rules) != 2 || rules[0].String() != "203.0.113.0/24 7777-7777" || rules[1].String() != "2001:db8::/32 5000-5100" {
+		t.Fatalf("rules = %v", rules)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"203.0.113.50:7777", true},
+		{"203.0.113.50:7778", false},
+		{"198.51.100.1:7777", false},
+		{"[2001:db8::1]:5050", true},
+		{"[::ffff:203.0.113.50]:7777", true},
+	}
+	for _, tt := range tests {
+		if got := allowedDestination(rules, net.UDPAddrFromAddrPort(netip.MustParseAddrPort(tt.addr))); got != tt.want {
+			t.Errorf("allowedDestination(%s) = %v; want %v", tt.addr, got, tt.want)
+		}
+	}
+	if !allowedDestination(nil, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1}) {
+		t.Errorf("without rules every destination should be allowed")
+	}
+
+	for _, input := range []string{"mygame 203.0.113.0/24\n", "mygame 203.0.113.0 7777\n", "mygame 203.0.113.0/24 0\n", "mygame 203.0.113.0/24 9000-8000\n"} {
+		if _, err := ParseDestinations(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestControlDestinations(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) {
+		opts.Destinations = []DestinationRule{{Prefix: netip.MustParsePrefix("127.0.0.1/32"), MinPort: 1, MaxPort: 65535}}
+		opts.MaxDestinations = 2
+	})
+	client := listenLoopback(t)
+	conn, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	open := func(id string, game *net.UDPAddr) uint8 {
+		msg := protocol.SessionOpen{SessionID: id, GameAddr: game, ClientAddr: client.LocalAddr().(*net.UDPAddr)}
+		return control(t, conn, protocol.EncodeSessionOpen(msg)).Status
+	}
+
+	if status := open("reflect", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 53}); status != protocol.StatusDestinationNotAllowed {
+		t.Errorf("open to a forbidden destination: status = %d; want %d", status, protocol.StatusDestinationNotAllowed)
+	}
+
+	games := []*net.UDPAddr{listenLoopback(t).LocalAddr().(*net.UDPAddr), listenLoopback(t).LocalAddr().(*net.UDPAddr)}
+	for i, game := range games {
+		if status := open(fmt.Sprint("game", i), game); status != protocol.StatusOK {
+			t.Fatalf("open game%d: status = %d", i, status)
+		}
+	}
+	// another session with a game server the client already has is fine, a third one isn't
+	if status := open("game0-again", games[0]); status != protocol.StatusOK {
+		t.Errorf("second session to the same game server: status = %d", status)
+	}
+	if status := open("game2", listenLoopback(t).LocalAddr().(*net.UDPAddr)); status != protocol.StatusTooManyDestinations {
+		t.Errorf("third game server: status = %d; want %d", status, protocol.StatusTooManyDestinations)
+	}
+	if got := srv.Stats().Denied; got != 2 {
+		t.Errorf("Denied = %d; want 2", got)
+	}
+
+	// static sessions are checked against the allow-list too, but not limited
+	err = srv.OpenSession(Session{ID: "static", GameAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 53}, ClientAddr: client.LocalAddr().(*net.UDPAddr)})
+	if err == nil {
+		t.Errorf("OpenSession to a forbidden destination succeeded")
+	}
+	if err := srv.OpenSession(Session{ID: "static", GameAddr: listenLoopback(t).LocalAddr().(*net.UDPAddr), ClientAddr: client.LocalAddr().(*net.UDPAddr)}); err != nil {
+		t.Errorf("OpenSession: %v", err)
+	}
+}
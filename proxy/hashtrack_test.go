@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash"
+)
+
+func getKeys(tracker *SeenHashTracker) []uint64 {
+	keys := make([]uint64, 0, len(tracker.SeenHash))
+	for k := range tracker.SeenHash {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name    string
+		entries map[uint64]time.Time
+		want    []uint64
+	}{
+		{
+			name: "all fresh",
+			entries: map[uint64]time.Time{
+				1: now,
+				2: now.Add(-10 * time.Millisecond),
+			},
+			want: []uint64{1, 2},
+		},
+		{
+			name: "some expired",
+			entries: map[uint64]time.Time{
+				3: now.Add(-200 * time.Millisecond),
+				4: now.Add(-10 * time.Millisecond),
+			},
+			want: []uint64{4},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newTracker(50 * time.Millisecond)
+			tracker.SeenHash = tc.entries
+
+			tracker.cleanupHash(now)
+			got := getKeys(tracker)
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("after cleanup got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsHashDuplicate(t *testing.T) {
+	tracker := newTracker(time.Minute)
+	now := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+
+	if got := tracker.isHashDuplicate(42, now); got {
+		t.Errorf("expected isHashDuplicate(42) == false on first call, got %v", got)
+	}
+
+	if got := tracker.isHashDuplicate(42, now.Add(time.Second)); !got { // duplicate
+		t.Errorf("expected isHashDuplicate(42) == true on second call, got %v", got)
+	}
+
+	if len(tracker.SeenHash) != 1 {
+		t.Fatalf("expected exactly 1 key, got %d", len(tracker.SeenHash))
+	}
+
+	// forgotten once cleaned up a cleanup interval after it was first seen
+	tracker.cleanupHash(now.Add(59 * time.Second))
+	if !tracker.isHashDuplicate(42, now.Add(59*time.Second)) {
+		t.Error("hash forgotten before the cleanup interval")
+	}
+	tracker.cleanupHash(now.Add(time.Minute))
+	if tracker.isHashDuplicate(42, now.Add(time.Minute)) {
+		t.Error("hash still remembered after the cleanup interval")
+	}
+}
+
+// Measures the duplicate check of every packet the proxy relays: hashing it and looking the hash up. Each
+// packet arrives over two paths, 10000 packets a second, and the tracker is cleaned every second as
+// the proxy does.
+func BenchmarkDedupe(b *testing.B) {
+	for _, size := range []int{100, 1200} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			tracker := newTracker(defaultCleanupInterval)
+			pkt := make([]byte, size)
+			now := time.Now()
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := range b.N {
+				binary.BigEndian.PutUint64(pkt, uint64(i/2))
+				now = now.Add(100 * time.Microsecond)
+				if tracker.isHashDuplicate(xxhash.Sum64(pkt), now) != (i%2 == 1) {
+					b.Fatalf("copy %d of packet %d taken for a duplicate", i%2, i/2)
+				}
+				if i%10000 == 0 {
+					tracker.cleanupHash(now)
+				}
+			}
+		})
+	}
+}
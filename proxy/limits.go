@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+const (
+	defaultBanWindow   = 10 * time.Second
+	defaultBanDuration = 5 * time.Minute
+
+	// Sources beyond this many aren't tracked for bans, so a spoofed flood can't exhaust memory.
+	maxTrackedSources = 1 << 16
+)
+
+// Drops packets from banned sources and beyond the global rate limit, before anything else is done with them.
+func (s *Server) admit(src netip.AddrPort, size int, now time.Time) bool {
+	if s.limiter.banned(src.Addr(), now) {
+		s.banned.Add(1)
+		return false
+	}
+	if !s.limiter.allowGlobal(size, now) {
+		s.limited.Add(1)
+		return false
+	}
+	return true
+}
+
+// Records a strike against the source of a packet that failed authentication or exceeded a limit.
+func (s *Server) strike(src netip.AddrPort, now time.Time) {
+	if s.limiter.strike(src.Addr(), now) {
+		s.log.Warn("banning a source", "src", src.Addr(), "duration", s.opts.Limits.BanDuration)
+	}
+}
+
+// Rate is a packet and byte budget per second. Up to one second's worth can be spent at once.
+// Zero fields are unlimited.
+type Rate struct {
+	Packets float64
+	Bytes   float64
+}
+
+// Limits configures rate limiting and bans. The zero value limits nothing.
+type Limits struct {
+	Client  Rate // per client: its key when authenticated, its IP otherwise
+	Session Rate // per session
+	Global  Rate // everything the relay receives
+
+	BanAfter    int           // strikes within BanWindow that get a source IP banned; 0 never bans
+	BanWindow   time.Duration // strikes older than this are forgotten
+	BanDuration time.Duration // how long a ban lasts
+}
+
+// Token buckets for one Rate.
+type buckets struct {
+	packets, bytes float64
+	last           time.Time
+}
+
+// Spends one packet of `size` bytes if the budget allows it.
+func (b *buckets) take(rate Rate, size int, now time.Time) bool {
+	if b.last.IsZero() {
+		b.packets, b.bytes = rate.Packets, rate.Bytes
+	} else {
+		elapsed := now.Sub(b.last).Seconds()
+		b.packets = min(rate.Packets, b.packets+rate.Packets*elapsed)
+		b.bytes = min(rate.Bytes, b.bytes+rate.Bytes*elapsed)
+	}
+	b.last = now
+
+	if (rate.Packets > 0 && b.packets < 1) || (rate.Bytes > 0 && b.bytes < float64(size)) {
+		return false
+	}
+	if rate.Packets > 0 {
+		b.packets--
+	}
+	if rate.Bytes > 0 {
+		b.bytes -= float64(size)
+	}
+	return true
+}
+
+// Identity a client is limited as.
+type limitKey struct {
+	id   protocol.KeyID
+	addr netip.Addr
+}
+
+func keyFor(src netip.AddrPort, c client) limitKey {
+	if c.id != (protocol.KeyID{}) {
+		return limitKey{id: c.id}
+	}
+	return limitKey{addr: src.Addr().Unmap()}
+}
+
+// Misbehaviour of a source IP.
+type sourceState struct {
+	strikes     int
+	firstStrike time.Time
+	bannedUntil time.Time
+}
+
+// limiter enforces Limits. It is safe for concurrent use.
+type limiter struct {
+	limits Limits
+
+	mu      sync.Mutex
+	global  buckets
+	clients map[limitKey]*buckets
+	sources map[netip.Addr]*sourceState
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:  limits,
+		clients: make(map[limitKey]*buckets),
+		sources: make(map[netip.Addr]*sourceState),
+	}
+}
+
+// Reports whether the source IP `addr` is banned.
+func (l *limiter) banned(addr netip.Addr, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	src, ok := l.sources[addr.Unmap()]
+	return ok && now.Before(src.bannedUntil)
+}
+
+// Records a strike against the source IP `addr`. Returns true if it got it banned.
+func (l *limiter) strike(addr netip.Addr, now time.Time) bool {
+	if l.limits.BanAfter <= 0 {
+		return false
+	}
+	addr = addr.Unmap()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	src, ok := l.sources[addr]
+	if !ok {
+		if len(l.sources) >= maxTrackedSources {
+			return false
+		}
+		src = &sourceState{}
+		l.sources[addr] = src
+	}
+	if now.Sub(src.firstStrike) > l.limits.BanWindow {
+		src.strikes, src.firstStrike = 0, now
+	}
+	src.strikes++
+	if src.strikes < l.limits.BanAfter {
+		return false
+	}
+	src.strikes = 0
+	src.bannedUntil = now.Add(l.limits.BanDuration)
+	return true
+}
+
+// Spends a packet of `size` bytes from the global budget.
+func (l *limiter) allowGlobal(size int, now time.Time) bool {
+	if l.limits.Global == (Rate{}) {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.global.take(l.limits.Global, size, now)
+}
+
+// Spends a packet of `size` bytes from the budget of `key`.
+func (l *limiter) allowClient(key limitKey, size int, now time.Time) bool {
+	if l.limits.Client == (Rate{}) {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.clients[key]
+	if !ok {
+		b = &buckets{}
+		l.clients[key] = b
+	}
+	return b.take(l.limits.Client, size, now)
+}
+
+// Spends a packet of `size` bytes from the budget of `sess`.
+func (l *limiter) allowSession(sess *session, size int, now time.Time) bool {
+	if l.limits.Session == (Rate{}) {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return sess.limit.take(l.limits.Session, size, now)
+}
+
+// Returns how many source IPs are banned at `now`.
+func (l *limiter) bans(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, src := range l.sources {
+		if now.Before(src.bannedUntil) {
+			n++
+		}
+	}
+	return n
+}
+
+// Forgets the budgets that are full again and the sources that are neither banned nor recently striking.
+func (l *limiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.clients {
+		if now.Sub(b.last) > time.Second {
+			delete(l.clients, key)
+		}
+	}
+	for addr, src := range l.sources {
+		if !now.Before(src.bannedUntil) && now.Sub(src.firstStrike) > l.limits.BanWindow {
+			delete(l.sources, addr)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+func TestBuckets(t *testing.T) {
+	var b buckets
+	rate := Rate{Packets: 2, Bytes: 250}
+	start := time.Now()
+
+	if !b.take(rate, 100, start) || !b.take(rate, 100, start) {
+		t.Fatalf("the first second's budget should be available at once")
+	}
+	if b.take(rate, 10, start) {
+		t.Errorf("a third packet went through a 2 packets/s limit")
+	}
+	if b.take(rate, 200, start.Add(500*time.Millisecond)) {
+		t.Errorf("a 200-byte packet went through with 175 bytes of budget left")
+	}
+	if !b.take(rate, 50, start.Add(500*time.Millisecond)) {
+		t.Errorf("half a second should refill one packet")
+	}
+
+	var unlimited buckets
+	for range 1000 {
+		if !unlimited.take(Rate{}, 1500, start) {
+			t.Fatalf("the zero Rate should limit nothing")
+		}
+	}
+}
+
+func TestLimiterBans(t *testing.T) {
+	l := newLimiter(Limits{BanAfter: 3, BanWindow: time.Second, BanDuration: time.Minute})
+	addr := netip.MustParseAddr("192.0.2.1")
+	start := time.Now()
+
+	// strikes spread over more than the window don't add up
+	for i := range 4 {
+		if l.strike(addr, start.Add(time.Duration(i)*700*time.Millisecond)) {
+			t.Fatalf("banned after strike %d spread over time", i)
+		}
+	}
+	now := start.Add(10 * time.Second)
+	l.strike(addr, now)
+	l.strike(addr, now)
+	if !l.strike(addr, now) || !l.banned(netip.MustParseAddr("::ffff:192.0.2.1"), now) || l.bans(now) != 1 {
+		t.Fatalf("3 strikes within the window should ban")
+	}
+	if l.banned(addr, now.Add(time.Minute)) {
+		t.Errorf("the ban should be over")
+	}
+	l.cleanup(now.Add(time.Minute))
+	if len(l.sources) != 0 {
+		t.Errorf("%d sources left after cleanup", len(l.sources))
+	}
+}
+
+func TestSessionRateLimit(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) { opts.Limits.Session = Rate{Packets: 3} })
+	game := listenLoopback(t)
+	if err := srv.OpenSession(Session{ID: "s", GameAddr: game.LocalAddr().(*net.UDPAddr), ClientAddr: listenLoopback(t).LocalAddr().(*net.UDPAddr)}); err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	conn, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	for i := range 10 {
+		if _, err := conn.Write([]byte{byte(i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	received := 0
+	buf := make([]byte, 512)
+	for {
+		_ = game.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		if _, _, err := game.ReadFromUDP(buf); err != nil {
+			break
+		}
+		received++
+	}
+	// the budget refills while the packets are sent, so allow for a bit more than the burst
+	if received < 3 || received > 5 {
+		t.Errorf("game server got %d packets; want about 3", received)
+	}
+	if stats := srv.Sessions(); stats[0].Limited != uint64(10-received) {
+		t.Errorf("Limited = %d; want %d", stats[0].Limited, 10-received)
+	}
+}
+
+func TestBanAfterFailedAuthentication(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) {
+		opts.Keys = keys.NewRing([]keys.Key{alice})
+		opts.Limits = Limits{BanAfter: 3, BanDuration: time.Minute}
+	})
+	conn, err := net.Dial("udp", srv.PingAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := protocol.EncodePingRequest(protocol.PingRequest{Nonce: 1, ClientSend: time.Now()})
+	for range 3 {
+		if _, err := conn.Write(req); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Stats().Bans == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// even properly authenticated packets are dropped while banned
+	if _, err := conn.Write(sealed(alice, req)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	buf := make([]byte, 512)
+	if n, err := conn.Read(buf); err == nil {
+		t.Errorf("banned source got an answer: %x", buf[:n])
+	}
+	if stats := srv.Stats(); stats.Bans != 1 || stats.Rejected != 3 || stats.Banned != 1 {
+		t.Errorf("Stats() = %+v; want 1 ban, 3 rejected and 1 banned packet", stats)
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/metrics"
+)
+
+// Registers the server's metrics on `r`. They are all read from the server's counters when scraped.
+func (s *Server) registerMetrics(r *metrics.Registry) {
+	r.Func("lolmp_proxy_uptime_seconds", "Seconds since the proxy started.", metrics.GaugeType, nil,
+		func(emit func(float64, ...string)) { emit(time.Since(s.started).Seconds()) })
+	r.Func("lolmp_proxy_sessions", "Open sessions.", metrics.GaugeType, nil,
+		func(emit func(float64, ...string)) { emit(float64(s.Stats().Sessions)) })
+
+	sessionCounter := func(name, help string, value func(SessionStats) uint64) {
+		r.Func(name, help, metrics.CounterType, []string{"session", "client"}, func(emit func(float64, ...string)) {
+			for _, stats := range s.Sessions() {
+				emit(float64(value(stats)), stats.ID, stats.Client)
+			}
+		})
+	}
+	sessionCounter("lolmp_proxy_session_packets_to_game_total", "Client packets forwarded to the game server.", func(st SessionStats) uint64 { return st.PacketsToGame })
+	sessionCounter("lolmp_proxy_session_bytes_to_game_total", "Bytes of client packets forwarded to the game server.", func(st SessionStats) uint64 { return st.BytesToGame })
+	sessionCounter("lolmp_proxy_session_packets_to_client_total", "Game server packets relayed to the game client.", func(st SessionStats) uint64 { return st.PacketsToClient })
+	sessionCounter("lolmp_proxy_session_bytes_to_client_total", "Bytes of game server packets relayed to the game client.", func(st SessionStats) uint64 { return st.BytesToClient })
+	sessionCounter("lolmp_proxy_session_duplicates_total", "Copies of client packets dropped by the dedupe tracker.", func(st SessionStats) uint64 { return st.Duplicates })
+	sessionCounter("lolmp_proxy_session_limited_total", "Packets dropped by the session's rate limit.", func(st SessionStats) uint64 { return st.Limited })
+	r.Func("lolmp_proxy_session_paths", "Client source addresses bound to the session.", metrics.GaugeType, []string{"session", "client"}, func(emit func(float64, ...string)) {
+		for _, stats := range s.Sessions() {
+			emit(float64(stats.Paths), stats.ID, stats.Client)
+		}
+	})
+
+	serverCounter := func(name, help string, value func(ServerStats) uint64) {
+		r.Func(name, help, metrics.CounterType, nil, func(emit func(float64, ...string)) { emit(float64(value(s.Stats()))) })
+	}
+	serverCounter("lolmp_proxy_rejected_packets_total", "Packets dropped for failing authentication.", func(st ServerStats) uint64 { return st.Rejected })
+	serverCounter("lolmp_proxy_denied_sessions_total", "Sessions refused for their game server.", func(st ServerStats) uint64 { return st.Denied })
+	serverCounter("lolmp_proxy_limited_packets_total", "Packets dropped by the client and global rate limits.", func(st ServerStats) uint64 { return st.Limited })
+	serverCounter("lolmp_proxy_banned_packets_total", "Packets dropped because their source IP is banned.", func(st ServerStats) uint64 { return st.Banned })
+	r.Func("lolmp_proxy_bans", "Source IPs banned right now.", metrics.GaugeType, nil,
+		func(emit func(float64, ...string)) { emit(float64(s.Stats().Bans)) })
+
+	r.Func("lolmp_proxy_ping_requests_total", "Ping datagrams by outcome; answered_unknown is included in answered.", metrics.CounterType, []string{"outcome"}, func(emit func(float64, ...string)) {
+		pings := s.pings.stats()
+		emit(float64(pings.Received), "received")
+		emit(float64(pings.Answered), "answered")
+		emit(float64(pings.Unknown), "answered_unknown")
+		emit(float64(pings.Malformed), "malformed")
+		emit(float64(pings.Dropped), "dropped")
+	})
+	r.Func("lolmp_proxy_ping_errors_total", "Socket errors of the ping listener.", metrics.CounterType, []string{"op"}, func(emit func(float64, ...string)) {
+		pings := s.pings.stats()
+		emit(float64(pings.ReadErrors), "read")
+		emit(float64(pings.WriteErrors), "write")
+	})
+
+	r.Func("lolmp_proxy_upstream_latency_seconds", "Current proxy to game server latency estimate, absent until the first sample.", metrics.GaugeType, []string{"region"}, func(emit func(float64, ...string)) {
+		for _, region := range s.opts.Regions {
+			if estimate, ok := s.estimator.Estimate(region); ok {
+				emit(estimate.Latency.Seconds(), region)
+			}
+		}
+	})
+	r.Func("lolmp_proxy_upstream_stale", "1 if the latency estimate hasn't been refreshed recently.", metrics.GaugeType, []string{"region"}, func(emit func(float64, ...string)) {
+		for _, region := range s.opts.Regions {
+			if estimate, ok := s.estimator.Estimate(region); ok {
+				stale := 0.0
+				if estimate.Stale {
+					stale = 1
+				}
+				emit(stale, region)
+			}
+		}
+	})
+}
+
+// Returns the registry of the server's metrics, served on the admin /metrics endpoint.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics
+}
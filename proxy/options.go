@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+)
+
+const (
+	defaultCleanupInterval = 1 * time.Second
+	defaultMaxPacketSize   = 4096
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultMaxSessions     = 64
+	defaultMaxClockSkew    = 1 * time.Minute
+	defaultMaxDestinations = 4
+)
+
+// Options configures a proxy Server. Everything but the listen addresses and regions is optional.
+type Options struct {
+	ListenAddr       string            // UDP address game packets are relayed through
+	PingListenAddr   string            // UDP address pings are answered on
+	Regions          []string          // league servers this proxy reports latency for; the first one is the default
+	UpstreamTargets  map[string]string // per-region probe targets, see NewUpstreamProber. Missing regions use DefaultUpstreamTargets
+	UpstreamInterval time.Duration     // how often the upstream latency of each region is sampled
+	UpstreamSamples  int               // how many samples the upstream estimate is the median of
+	CleanupInterval  time.Duration     // how long a packet hash is remembered for deduplication
+	MaxPacketSize    int               // datagrams bigger than this are dropped
+	MaxSessions      int               // sessions beyond this many are refused
+	IdleTimeout      time.Duration     // sessions without traffic for this long are closed
+	Keys             *keys.Ring        // keys of the authorized clients; nil relays and answers unauthenticated packets
+	TunnelKey        *tunnel.Keypair   // static key of the encrypted transport; nil refuses handshakes
+	TunnelClients    *keys.Ring        // static public keys of the clients allowed to open tunnels
+	MaxClockSkew     time.Duration     // authenticated packets and handshakes sealed further than this from the proxy's clock are rejected
+	Destinations     []DestinationRule // game servers sessions may relay to, see GameProfiles; nil allows any
+	MaxDestinations  int               // distinct game servers a client may have sessions with at once
+	Limits           Limits            // rate limits and bans; the zero value limits nothing
+	PingWorkers      int               // pings answered concurrently
+	AdminAddr        string            // TCP address /healthz, /readyz and /stats are served on, see AdminHandler; empty disables them
+	Logger           *slog.Logger      // nil means slog.Default()
+	CaptureDir       string            // directory a pcapng capture of every session is written to, see package capture; empty writes none
+}
+
+// Fills in the defaults and checks that the options can be used to run a server.
+func (opts *Options) validate() error {
+	if opts.ListenAddr == "" {
+		return errors.New("a listen address is required")
+	}
+	if opts.PingListenAddr == "" {
+		return errors.New("a ping listen address is required")
+	}
+	if len(opts.Regions) == 0 {
+		return errors.New("at least one region is required")
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = defaultCleanupInterval
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = defaultMaxPacketSize
+	}
+	if opts.MaxSessions <= 0 {
+		opts.MaxSessions = defaultMaxSessions
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultIdleTimeout
+	}
+	if opts.TunnelKey != nil && opts.TunnelClients == nil {
+		return errors.New("the encrypted transport needs the keys of the authorized clients")
+	}
+	if opts.MaxClockSkew <= 0 {
+		opts.MaxClockSkew = defaultMaxClockSkew
+	}
+	if opts.MaxDestinations <= 0 {
+		opts.MaxDestinations = defaultMaxDestinations
+	}
+	if opts.Limits.BanWindow <= 0 {
+		opts.Limits.BanWindow = defaultBanWindow
+	}
+	if opts.Limits.BanDuration <= 0 {
+		opts.Limits.BanDuration = defaultBanDuration
+	}
+	if opts.PingWorkers <= 0 {
+		opts.PingWorkers = defaultPingWorkers
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return nil
+}
+
+// Returns the probe target of every served region.
+func (opts *Options) upstreamTargets(defaults map[string]string) (map[string]string, error) {
+	targets := make(map[string]string, len(opts.Regions))
+	for _, region := range opts.Regions {
+		target, ok := opts.UpstreamTargets[region]
+		if !ok {
+			target, ok = defaults[region]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown server %q and no upstream target given for it", region)
+		}
+		targets[region] = target
+	}
+	return targets, nil
+}
+
+// DefaultUpstreamTargets maps league servers to the DynamoDB endpoints timed by default.
+// `cacheBuster` is appended to every query so intermediate caches don't answer for AWS.
+func DefaultUpstreamTargets(cacheBuster string) map[string]string {
+	return map[string]string{
+		"NA":   fmt.Sprintf("https://dynamodb.us-east-2.amazonaws.com/ping?x=%s", cacheBuster),
+		"LAN":  fmt.Sprintf("https://dynamodb.us-east-1.amazonaws.com/ping?x=%s", cacheBuster),
+		"LAS":  fmt.Sprintf("https://dynamodb.sa-east-1.amazonaws.com/ping?x=%s", cacheBuster),
+		"EUW":  fmt.Sprintf("https://dynamodb.eu-central-1.amazonaws.com/ping?x=%s", cacheBuster),
+		"OCE":  fmt.Sprintf("https://dynamodb.ap-southeast-2.amazonaws.com/ping?x=%s", cacheBuster),
+		"EUNE": fmt.Sprintf("https://dynamodb.eu-central-1.amazonaws.com/ping?x=%s", cacheBuster),
+		"RU":   fmt.Sprintf("https://dynamodb.eu-north-1.amazonaws.com/ping?x=%s", cacheBuster),
+		"TR":   fmt.Sprintf("https://dynamodb.eu-south-1.amazonaws.com/ping?x=%s", cacheBuster),
+		"JP":   fmt.Sprintf("https://dynamodb.ap-northeast-1.amazonaws.com/ping?x=%s", cacheBuster),
+		"KR":   fmt.Sprintf("https://dynamodb.ap-northeast-2.amazonaws.com/ping?x=%s", cacheBuster),
+	}
+}
+
+// Parses "SERVER=URL,..." into per-region upstream probe targets.
+func ParseUpstreamTargets(s string) (map[string]string, error) {
+	targets := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return targets, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		shard, target, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid upstream target %q, expected SERVER=URL", strings.TrimSpace(entry))
+		}
+		targets[strings.ToUpper(strings.TrimSpace(shard))] = strings.TrimSpace(target)
+	}
+	return targets, nil
+}
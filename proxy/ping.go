@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+const (
+	defaultPingWorkers = 4
+	pingQueueSize      = 256
+)
+
+// PingStats counts what happened to the pings the proxy received.
+type PingStats struct {
+	Received    uint64 `json:"received"` // datagrams read from the ping listener
+	Answered    uint64 `json:"answered"`
+	Unknown     uint64 `json:"unknown"`   // answered without an upstream estimate, included in Answered
+	Malformed   uint64 `json:"malformed"` // neither a v1 nor a v2 request
+	Dropped     uint64 `json:"dropped"`   // dropped because every worker was busy and the queue was full
+	ReadErrors  uint64 `json:"read_errors"`
+	WriteErrors uint64 `json:"write_errors"`
+}
+
+type pingCounters struct {
+	received, answered, unknown, malformed, dropped, readErrors, writeErrors atomic.Uint64
+}
+
+func (c *pingCounters) stats() PingStats {
+	return PingStats{
+		Received:    c.received.Load(),
+		Answered:    c.answered.Load(),
+		Unknown:     c.unknown.Load(),
+		Malformed:   c.malformed.Load(),
+		Dropped:     c.dropped.Load(),
+		ReadErrors:  c.readErrors.Load(),
+		WriteErrors: c.writeErrors.Load(),
+	}
+}
+
+// A ping waiting for a worker.
+type pingRequest struct {
+	b    []byte
+	src  netip.AddrPort
+	recv time.Time
+}
+
+// Answers both ping protocol versions on `pc`:
+//   - v1: an 8-byte request answered with the time (in ms) taken for everything that is not the
+//     round trip to the league servers (we call it: the bloat), as an 8-byte big-endian int64.
+//   - v2: a `protocol.PingRequest` answered with a `protocol.PingResponse` carrying the nonce, the
+//     proxy receive/send timestamps and the proxy→game-server latency as a separate field.
+//
+// Pings are answered right away from the estimator's current view of the requested region (or the
+// default one), which is refreshed in the background. Requests are handled by PingWorkers workers;
+// when they can't keep up, pings are dropped rather than queued for long, since a late answer is a
+// wrong measurement. Problems with single packets are counted and never stop the handler, which
+// runs until ctx is done or `pc` is closed.
+func (s *Server) servePings(ctx context.Context, pc *net.UDPConn) {
+	s.log.Info("ping handler listening", "addr", pc.LocalAddr(), "shards", s.opts.Regions)
+
+	queue := make(chan pingRequest, pingQueueSize)
+	var wg sync.WaitGroup
+	for range s.opts.PingWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range queue {
+				s.answerPing(pc, req)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(queue)
+
+	buf := make([]byte, 2048)
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// avoid hanging for more than 1 second
+		if err := pc.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil && errors.Is(err, net.ErrClosed) {
+			return
+		}
+
+		n, src, err := pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				return
+			}
+			// e.g. ICMP port unreachable from a client that went away
+			s.pings.readErrors.Add(1)
+			continue
+		}
+		recv := time.Now()
+		s.pings.received.Add(1)
+
+		if !s.admit(src, n, recv) {
+			continue
+		}
+		select {
+		case queue <- pingRequest{b: append([]byte(nil), buf[:n]...), src: src, recv: recv}:
+		default:
+			s.pings.dropped.Add(1)
+		}
+	}
+}
+
+// Authenticates and answers a single ping. Without an upstream estimate for the region, v2 pings are
+// answered with FlagUpstreamUnknown and a zero upstream latency, and v1 pings as if it were zero.
+func (s *Server) answerPing(pc *net.UDPConn, p pingRequest) {
+	packet, c, ok := s.authenticate(p.b)
+	if !ok {
+		s.strike(p.src, p.recv)
+		return
+	}
+	if !s.limiter.allowClient(keyFor(p.src, c), len(p.b), p.recv) {
+		s.limited.Add(1)
+		s.strike(p.src, p.recv)
+		return
+	}
+
+	req, isV1, err := parsePing(packet)
+	if err != nil {
+		s.pings.malformed.Add(1)
+		return
+	}
+
+	region := req.Region
+	if region == "" {
+		region = s.opts.Regions[0]
+	}
+	var flags uint8
+	estimate, ok := s.estimator.Estimate(region)
+	if !ok {
+		flags |= protocol.FlagUpstreamUnknown
+		s.pings.unknown.Add(1)
+	} else if estimate.Stale {
+		flags |= protocol.FlagUpstreamStale
+	}
+	upstream := estimate.Latency
+
+	var reply []byte
+	if isV1 {
+		// v1 clients compute total - bloat, so everything we held the request for that
+		// wasn't the upstream round trip counts as bloat.
+		reply = protocol.EncodePingV1Response(time.Since(p.recv) - upstream)
+	} else {
+		reply = protocol.EncodePingResponse(protocol.PingResponse{
+			Nonce:      req.Nonce,
+			ClientSend: req.ClientSend,
+			ProxyRecv:  p.recv,
+			ProxySend:  time.Now(),
+			Upstream:   upstream,
+			Flags:      flags,
+		})
+	}
+
+	if _, err := pc.WriteToUDPAddrPort(reply, p.src); err != nil {
+		s.pings.writeErrors.Add(1)
+		return
+	}
+	s.pings.answered.Add(1)
+}
+
+// Tells the version of a ping request: a v1 request is any 8 bytes, anything else must decode as a v2 one
+// padded to at least the size of its response, so the proxy never sends more than it received.
+func parsePing(packet []byte) (req protocol.PingRequest, v1 bool, err error) {
+	if protocol.IsPingV1Request(packet) {
+		return protocol.PingRequest{}, true, nil
+	}
+	if len(packet) < protocol.MinPingRequestSize {
+		return protocol.PingRequest{}, false, protocol.ErrShortMessage
+	}
+	req, err = protocol.DecodePingRequest(packet)
+	return req, false, err
+}
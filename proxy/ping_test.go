@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+func TestPingWithoutEstimate(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) {
+		opts.UpstreamTargets = map[string]string{"NA": "http://127.0.0.1:1", "EUW": "http://127.0.0.1:1"}
+	})
+	conn, err := net.Dial("udp", srv.PingAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := protocol.DecodePingResponse(exchange(t, conn, protocol.EncodePingRequest(protocol.PingRequest{Nonce: 5, ClientSend: time.Now()})))
+	if err != nil {
+		t.Fatalf("DecodePingResponse: %v", err)
+	}
+	if resp.Nonce != 5 || resp.Flags&protocol.FlagUpstreamUnknown == 0 || resp.Upstream != 0 {
+		t.Errorf("response = %+v; want nonce 5 flagged as unknown", resp)
+	}
+	if _, err := protocol.DecodePingV1Response(exchange(t, conn, make([]byte, protocol.PingV1Size))); err != nil {
+		t.Errorf("v1 ping without an estimate: %v", err)
+	}
+	if stats := srv.Stats().Pings; stats.Unknown != 2 || stats.Answered != 2 {
+		t.Errorf("Pings = %+v; want 2 answered as unknown", stats)
+	}
+}
+
+func TestPingNoAmplification(t *testing.T) {
+	srv := startTestServer(t)
+	conn, err := net.Dial("udp", srv.PingAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// an unpadded v2 request is smaller than its answer would be
+	req := protocol.EncodePingRequest(protocol.PingRequest{Nonce: 5, ClientSend: time.Now()})
+	if _, err := conn.Write(req[:protocol.PingRequestSize]); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 512)
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Fatalf("unpadded request was answered: %x", buf[:n])
+	}
+
+	for _, req := range [][]byte{req, make([]byte, protocol.PingV1Size)} {
+		if reply := exchange(t, conn, req); len(reply) > len(req) {
+			t.Errorf("%d-byte request answered with %d bytes", len(req), len(reply))
+		}
+	}
+	if stats := srv.Stats().Pings; stats.Malformed != 1 || stats.Answered != 2 {
+		t.Errorf("Pings = %+v; want 1 malformed and 2 answered", stats)
+	}
+}
+
+func TestPingHandlerKeepsGoing(t *testing.T) {
+	srv := startTestServer(t)
+	conn, err := net.Dial("udp", srv.PingAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, junk := range [][]byte{{1, 2, 3}, make([]byte, 100)} {
+		if _, err := conn.Write(junk); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	// concurrent clients are all answered
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := net.Dial("udp", srv.PingAddr().String())
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			defer c.Close()
+			for j := range 5 {
+				nonce := uint64(i*100 + j)
+				req := protocol.EncodePingRequest(protocol.PingRequest{Nonce: nonce, ClientSend: time.Now()})
+				if _, err := c.Write(req); err != nil {
+					t.Errorf("write: %v", err)
+					return
+				}
+				buf := make([]byte, 512)
+				_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+				n, err := c.Read(buf)
+				if err != nil {
+					t.Errorf("ping %d: %v", nonce, err)
+					return
+				}
+				if resp, err := protocol.DecodePingResponse(buf[:n]); err != nil || resp.Nonce != nonce {
+					t.Errorf("ping %d = %+v, %v", nonce, resp, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := srv.Stats().Pings
+	if stats.Malformed != 2 || stats.Answered < 40 || stats.Received < stats.Answered+stats.Malformed {
+		t.Errorf("Pings = %+v; want 2 malformed and at least 40 answered", stats)
+	}
+}
+
+func FuzzParsePing(f *testing.F) {
+	f.Add(make([]byte, protocol.PingV1Size))
+	f.Add(protocol.EncodePingRequest(protocol.PingRequest{Nonce: 5, ClientSend: time.Unix(0, 1718000000123456789), Region: "EUW"}))
+	f.Add(protocol.EncodePingResponse(protocol.PingResponse{Nonce: 5}))
+	f.Add(sealed(alice, make([]byte, protocol.PingV1Size)))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		req, v1, err := parsePing(b)
+		if v1 != (len(b) == protocol.PingV1Size) {
+			t.Fatalf("%x taken for a v1 request: %v", b, v1)
+		}
+		if v1 || err != nil {
+			return
+		}
+		if len(b) < protocol.MinPingRequestSize || len(req.Region) > protocol.MaxRegionLen {
+			t.Fatalf("%x decoded as %+v", b, req)
+		}
+	})
+}
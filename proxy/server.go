@@ -0,0 +1,347 @@
+// Package proxy implements the relay that sits between the multipath client and Riot's game server.
+// It listens for the copies of the game packets sent by the client over every path, forwards the first
+// copy of each to the game server and relays the game server's answers back to the game client.
+// It also answers the client's pings with its own latency estimate towards the game server.
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/metrics"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+	"github.com/cespare/xxhash"
+)
+
+// Server is a proxy listening for game packets and pings. Create it with NewServer.
+type Server struct {
+	opts      Options
+	log       *slog.Logger
+	estimator *UpstreamEstimator
+
+	conn  *net.UDPConn // relay listener, set by Listen
+	pc    *net.UDPConn // ping listener, set by Listen
+	admin net.Listener // admin HTTP listener, set by Listen if AdminAddr is
+
+	mu       sync.RWMutex
+	sessions map[string]*session
+	paths    map[netip.AddrPort]*session // client source address -> session
+
+	tunMu      sync.RWMutex
+	tunnels    map[uint32]*tunnelState      // index chosen by the proxy during the handshake -> tunnel
+	handshakes map[protocol.KeyID]time.Time // static key of a client -> when its newest accepted handshake started
+
+	replayMu sync.Mutex
+	replay   map[protocol.KeyID]*tunnel.ReplayWindow // counters of the packets authenticated with each key
+
+	limiter *limiter
+	metrics *metrics.Registry
+	started time.Time
+	serving atomic.Bool
+
+	rejected atomic.Uint64 // packets dropped for failing authentication
+	denied   atomic.Uint64 // sessions refused for their game server
+	limited  atomic.Uint64 // packets dropped by the client and global rate limits
+	banned   atomic.Uint64 // packets dropped because their source is banned
+	pings    pingCounters
+}
+
+// ServerStats is a snapshot of the server-wide counters.
+type ServerStats struct {
+	Sessions int       `json:"sessions"`
+	Rejected uint64    `json:"rejected"` // packets dropped for failing authentication
+	Denied   uint64    `json:"denied"`   // sessions refused because their game server isn't allowed or the client has too many
+	Limited  uint64    `json:"limited"`  // packets dropped by the client and global rate limits, see SessionStats for the session ones
+	Banned   uint64    `json:"banned"`   // packets dropped because their source IP is banned
+	Bans     int       `json:"bans"`     // source IPs banned right now
+	Pings    PingStats `json:"pings"`
+}
+
+// Creates a server from `opts`. Nothing is opened until Listen or Run is called.
+func NewServer(opts Options) (*Server, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	cacheBuster, err := randomHex(5)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := opts.upstreamTargets(DefaultUpstreamTargets(cacheBuster))
+	if err != nil {
+		return nil, err
+	}
+	probers, err := NewUpstreamProbers(targets, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		opts: opts,
+		log:  opts.Logger,
+		estimator: &UpstreamEstimator{
+			Probers:  probers,
+			Interval: opts.UpstreamInterval,
+			Samples:  opts.UpstreamSamples,
+			Logger:   opts.Logger,
+		},
+		sessions:   make(map[string]*session),
+		paths:      make(map[netip.AddrPort]*session),
+		tunnels:    make(map[uint32]*tunnelState),
+		handshakes: make(map[protocol.KeyID]time.Time),
+		replay:     make(map[protocol.KeyID]*tunnel.ReplayWindow),
+		limiter:    newLimiter(opts.Limits),
+		started:    time.Now(),
+		metrics:    metrics.NewRegistry(),
+	}
+	s.registerMetrics(s.metrics)
+	return s, nil
+}
+
+// Opens both listeners and serves until ctx is done or the relay listener fails.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve(ctx)
+}
+
+// Opens the relay and ping listeners. Sessions can be opened from then on.
+func (s *Server) Listen() error {
+	conn, err := listenUDP(s.opts.ListenAddr)
+	if err != nil {
+		return err
+	}
+	pc, err := listenUDP(s.opts.PingListenAddr)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if s.opts.AdminAddr != "" {
+		admin, err := net.Listen("tcp", s.opts.AdminAddr)
+		if err != nil {
+			_ = conn.Close()
+			_ = pc.Close()
+			return fmt.Errorf("Failed to listen on %s: %w", s.opts.AdminAddr, err)
+		}
+		s.admin = admin
+	}
+	s.conn, s.pc = conn, pc
+	return nil
+}
+
+// Returns a snapshot of the server-wide counters, see Sessions for the per-session ones.
+func (s *Server) Stats() ServerStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return ServerStats{
+		Sessions: len(s.sessions),
+		Rejected: s.rejected.Load(),
+		Denied:   s.denied.Load(),
+		Limited:  s.limited.Load(),
+		Banned:   s.banned.Load(),
+		Bans:     s.limiter.bans(time.Now()),
+		Pings:    s.pings.stats(),
+	}
+}
+
+// Returns the address of the relay listener, nil before Listen.
+func (s *Server) Addr() net.Addr {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Returns the address of the admin HTTP listener, nil before Listen or without AdminAddr.
+func (s *Server) AdminAddr() net.Addr {
+	if s.admin == nil {
+		return nil
+	}
+	return s.admin.Addr()
+}
+
+// Returns the address of the ping listener, nil before Listen.
+func (s *Server) PingAddr() net.Addr {
+	if s.pc == nil {
+		return nil
+	}
+	return s.pc.LocalAddr()
+}
+
+// Serves on the listeners opened by Listen until ctx is done or the relay listener fails.
+// Both listeners and every session are closed on return. Ping handling problems are counted in ServerStats.Pings and don't stop the relay.
+func (s *Server) Serve(ctx context.Context) error {
+	if s.conn == nil || s.pc == nil {
+		return fmt.Errorf("Serve called before Listen")
+	}
+	defer s.conn.Close()
+	defer s.pc.Close()
+	defer s.closeAllSessions()
+
+	go s.estimator.Run(ctx)
+
+	go s.servePings(ctx, s.pc)
+	if s.admin != nil {
+		go s.serveAdmin(ctx, s.admin)
+		s.log.Info("admin endpoints listening", "url", "http://"+s.admin.Addr().String())
+	}
+
+	go s.housekeeping(ctx)
+
+	s.log.Info("UDP proxy listening", "addr", s.conn.LocalAddr())
+	s.serving.Store(true)
+	defer s.serving.Store(false)
+	return s.relay(ctx, s.conn)
+}
+
+// Forwards the first copy of every client packet to its session's game server and answers
+// control messages. The game server's answers are relayed by each session's upstream reader.
+func (s *Server) relay(ctx context.Context, conn *net.UDPConn) error {
+	buffer := make([]byte, 64*1024)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+
+		// avoid hanging for more than 1 second
+		if err := conn.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+			s.log.Warn("unable to set read deadline", logging.KeyError, err)
+		}
+
+		n, srcAddr, err := conn.ReadFromUDPAddrPort(buffer)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if ctx.Err() != nil {
+					return nil
+				}
+				continue
+			}
+
+			s.log.Warn("read error", logging.KeyError, err)
+			continue
+		}
+		if n > s.opts.MaxPacketSize {
+			continue
+		}
+		now := time.Now()
+		if !s.admit(srcAddr, n, now) {
+			continue
+		}
+
+		if h, err := protocol.ParseHeader(buffer[:n]); err == nil && h.Type == protocol.TypeHandshakeInit && s.opts.TunnelKey != nil {
+			if !s.handleHandshake(srcAddr, buffer[:n]) {
+				s.strike(srcAddr, now)
+			}
+			continue
+		}
+
+		packet, c, ok := s.authenticate(buffer[:n])
+		if !ok {
+			s.strike(srcAddr, now)
+			continue
+		}
+		if !s.limiter.allowClient(keyFor(srcAddr, c), n, now) {
+			s.limited.Add(1)
+			s.strike(srcAddr, now)
+			continue
+		}
+
+		if protocol.IsControl(packet) {
+			s.handleControl(srcAddr, c, packet)
+			continue
+		}
+
+		sess := s.sessionFor(srcAddr, c)
+		if sess == nil {
+			continue
+		}
+		sess.touch()
+		sess.capture.arrived(srcAddr, now, buffer[:n])
+		if !s.limiter.allowSession(sess, n, now) {
+			sess.limited.Add(1)
+			s.strike(srcAddr, now)
+			continue
+		}
+
+		hash := xxhash.Sum64(packet)
+		if sess.tracker.isHashDuplicate(hash, now) {
+			sess.duplicates.Add(1)
+			continue
+		}
+
+		// New outgoing packet: forward to the game server through the session's own socket
+		if _, err := sess.upstream.Write(packet); err != nil {
+			s.log.Warn("failed to forward to the game server", logging.KeySession, sess.ID, "game", sess.GameAddr, logging.KeyError, err)
+			continue
+		}
+		sess.capture.toGame(time.Now(), packet)
+		sess.packetsToGame.Add(1)
+		sess.bytesToGame.Add(uint64(len(packet)))
+	}
+}
+
+// Relays everything the game server sends on the session's socket to the game client, until the socket is closed.
+func (s *Server) relayFromGame(sess *session) {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := sess.upstream.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// e.g. ICMP port unreachable surfacing as a read error; the session may recover
+			s.log.Warn("read error from the game server", logging.KeySession, sess.ID, logging.KeyError, err)
+			continue
+		}
+		sess.touch()
+		sess.capture.fromGame(time.Now(), buffer[:n])
+
+		// the client may move its address with a SessionUpdate
+		s.mu.RLock()
+		clientAddr := sess.ClientAddr
+		s.mu.RUnlock()
+
+		// Redirect into the client’s real UDP port
+		if _, err := s.conn.WriteToUDP(buffer[:n], clientAddr); err != nil {
+			s.log.Warn("failed to send back to the game client", logging.KeySession, sess.ID, logging.KeyError, err)
+			continue
+		}
+		sess.capture.toClient(time.Now(), clientAddr.AddrPort(), buffer[:n])
+		sess.packetsToClient.Add(1)
+		sess.bytesToClient.Add(uint64(n))
+	}
+}
+
+func listenUDP(listenAddr string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve UDP address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %s: %w", listenAddr, err)
+	}
+	return conn, nil
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
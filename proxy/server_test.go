@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Starts a server whose upstream latency is measured against a local HTTP server.
+// `configure` may adjust the options before the server is created.
+func startTestServer(t *testing.T, configure ...func(*Options)) *Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	t.Cleanup(upstream.Close)
+
+	opts := Options{
+		ListenAddr:      "127.0.0.1:0",
+		PingListenAddr:  "127.0.0.1:0",
+		Regions:         []string{"NA", "EUW"},
+		UpstreamTargets: map[string]string{"NA": upstream.URL, "EUW": upstream.URL},
+		CleanupInterval: time.Minute,
+	}
+	for _, f := range configure {
+		f(&opts)
+	}
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+
+	// pings are answered as unknown until the first estimate, wait for it so tests see real values
+	deadline := time.Now().Add(5 * time.Second)
+	for _, region := range opts.Regions {
+		for opts.UpstreamTargets[region] == upstream.URL {
+			if _, ok := srv.estimator.Estimate(region); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("no upstream estimate for %s", region)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	return srv
+}
+
+// Sends `req` until something comes back or the deadline passes.
+func exchange(t *testing.T, conn net.Conn, req []byte) []byte {
+	t.Helper()
+	buf := make([]byte, 512)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write(req); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if n, err := conn.Read(buf); err == nil {
+			return buf[:n]
+		}
+	}
+	t.Fatalf("no answer before the deadline")
+	return nil
+}
+
+func TestNewServerValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"no listen addr", Options{PingListenAddr: ":1", Regions: []string{"NA"}}},
+		{"no ping addr", Options{ListenAddr: ":1", Regions: []string{"NA"}}},
+		{"no regions", Options{ListenAddr: ":1", PingListenAddr: ":2"}},
+		{"unknown region", Options{ListenAddr: ":1", PingListenAddr: ":2", Regions: []string{"MOON"}}},
+		{"bad target", Options{ListenAddr: ":1", PingListenAddr: ":2", Regions: []string{"NA"}, UpstreamTargets: map[string]string{"NA": "gopher://x"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewServer(tc.opts); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestServerPing(t *testing.T) {
+	srv := startTestServer(t)
+
+	conn, err := net.Dial("udp", srv.PingAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	reply := exchange(t, conn, protocol.EncodePingRequest(protocol.PingRequest{Nonce: 99, ClientSend: time.Now(), Region: "EUW"}))
+	resp, err := protocol.DecodePingResponse(reply)
+	if err != nil {
+		t.Fatalf("DecodePingResponse: %v", err)
+	}
+	if resp.Nonce != 99 {
+		t.Errorf("nonce = %d; want 99", resp.Nonce)
+	}
+	if resp.Upstream < 10*time.Millisecond {
+		t.Errorf("upstream = %v; want at least the 10ms the upstream sleeps", resp.Upstream)
+	}
+
+	bloat, err := protocol.DecodePingV1Response(exchange(t, conn, make([]byte, protocol.PingV1Size)))
+	if err != nil {
+		t.Fatalf("DecodePingV1Response: %v", err)
+	}
+	// answered from the estimate, so the bloat is negative: the client adds the upstream latency back
+	if bloat > 0 {
+		t.Errorf("v1 bloat = %v; want <= 0", bloat)
+	}
+}
+
+func TestServerRelay(t *testing.T) {
+	srv := startTestServer(t)
+
+	game := listenLoopback(t)
+	gameClient := listenLoopback(t)
+	err := srv.OpenSession(Session{
+		ID:         "game",
+		GameAddr:   game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: gameClient.LocalAddr().(*net.UDPAddr),
+	})
+	if err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+
+	// two paths sending the same packet
+	pathA, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer pathA.Close()
+	pathB, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer pathB.Close()
+
+	for _, path := range []net.Conn{pathA, pathB} {
+		if _, err := path.Write([]byte("move")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	buf := make([]byte, 512)
+	_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, proxyAddr, err := game.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "move" {
+		t.Fatalf("game server read = %q, %v; want \"move\"", buf[:n], err)
+	}
+	_ = game.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := game.ReadFromUDP(buf); err == nil {
+		t.Fatalf("game server got a duplicate: %q", buf[:n])
+	}
+
+	if _, err := game.WriteToUDP([]byte("state"), proxyAddr); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = gameClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err = gameClient.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "state" {
+		t.Fatalf("game client read = %q, %v; want \"state\"", buf[:n], err)
+	}
+
+	stats := srv.Sessions()
+	if len(stats) != 1 {
+		t.Fatalf("len(Sessions()) = %d; want 1", len(stats))
+	}
+	got := stats[0]
+	if got.Paths != 2 || got.PacketsToGame != 1 || got.Duplicates != 1 || got.PacketsToClient != 1 {
+		t.Errorf("stats = %+v; want 2 paths, 1 packet each way and 1 duplicate", got)
+	}
+}
@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+// Session describes a game the proxy is relaying for.
+type Session struct {
+	ID         string       // unique per game, chosen by the client
+	GameAddr   *net.UDPAddr // Riot's game server
+	ClientAddr *net.UDPAddr // where the game client listens for the server's packets
+	ClientIPs  []net.IP     // IPs the client's paths send from; empty accepts any source not claimed by another session
+}
+
+// SessionStats is a snapshot of a session's counters.
+type SessionStats struct {
+	ID              string    `json:"id"`
+	Client          string    `json:"client"` // name of the key the session was opened with, empty if opened locally
+	GameAddr        string    `json:"game_addr"`
+	Paths           int       `json:"paths"` // client source addresses bound to the session
+	PacketsToGame   uint64    `json:"packets_to_game"`
+	BytesToGame     uint64    `json:"bytes_to_game"`
+	PacketsToClient uint64    `json:"packets_to_client"`
+	BytesToClient   uint64    `json:"bytes_to_client"`
+	Duplicates      uint64    `json:"duplicates"` // copies dropped by the dedupe tracker
+	Limited         uint64    `json:"limited"`    // packets dropped by the session's rate limit
+	Opened          time.Time `json:"opened"`
+	LastActive      time.Time `json:"last_active"`
+}
+
+// Runtime state of a session.
+type session struct {
+	Session
+	owner    client     // who opened the session; only their packets are relayed
+	origin   netip.Addr // where an unauthenticated client opened it from, invalid for sessions opened locally
+	tracker  *SeenHashTracker
+	upstream *net.UDPConn // connected to GameAddr, so whatever it reads belongs to this session
+	opened   time.Time
+	capture  *sessionCapture // nil unless Options.CaptureDir is set
+
+	lastActive      atomic.Int64 // unix nanos
+	paths           atomic.Int64
+	packetsToGame   atomic.Uint64
+	bytesToGame     atomic.Uint64
+	packetsToClient atomic.Uint64
+	bytesToClient   atomic.Uint64
+	duplicates      atomic.Uint64
+	limited         atomic.Uint64
+	limit           buckets // guarded by the server's limiter
+}
+
+func newSession(cfg Session, owner client, origin netip.Addr, cleanupInterval time.Duration) (*session, error) {
+	upstream, err := net.DialUDP("udp", nil, cfg.GameAddr)
+	if err != nil {
+		return nil, err
+	}
+	sess := &session{
+		Session:  cfg,
+		owner:    owner,
+		origin:   origin,
+		tracker:  newTracker(cleanupInterval),
+		upstream: upstream,
+		opened:   time.Now(),
+	}
+	sess.touch()
+	return sess, nil
+}
+
+func (sess *session) touch() {
+	sess.lastActive.Store(time.Now().UnixNano())
+}
+
+func (sess *session) idleFor() time.Duration {
+	return time.Since(time.Unix(0, sess.lastActive.Load()))
+}
+
+// Reports whether a packet from `src` may belong to this session.
+func (sess *session) accepts(src netip.AddrPort) bool {
+	if len(sess.ClientIPs) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(sess.ClientIPs, func(ip net.IP) bool {
+		addr, ok := netip.AddrFromSlice(ip)
+		return ok && addr.Unmap() == src.Addr().Unmap()
+	})
+}
+
+// Reports whether packets from `c` may be relayed for this session. Sessions without an owner
+// (opened locally, or while authentication is off) accept any client.
+func (sess *session) allows(c client) bool {
+	return sess.owner.id == (protocol.KeyID{}) || sess.owner.id == c.id
+}
+
+func (sess *session) stats() SessionStats {
+	return SessionStats{
+		ID:              sess.ID,
+		Client:          sess.owner.name,
+		GameAddr:        sess.GameAddr.String(),
+		Paths:           int(sess.paths.Load()),
+		PacketsToGame:   sess.packetsToGame.Load(),
+		BytesToGame:     sess.bytesToGame.Load(),
+		PacketsToClient: sess.packetsToClient.Load(),
+		BytesToClient:   sess.bytesToClient.Load(),
+		Duplicates:      sess.duplicates.Load(),
+		Limited:         sess.limited.Load(),
+		Opened:          sess.opened,
+		LastActive:      time.Unix(0, sess.lastActive.Load()),
+	}
+}
+
+// Starts relaying for `cfg`. The server must be listening. Session IDs must be unique.
+// The session accepts any authenticated client, see the control channel for sessions owned by one.
+// The game server must be allowed by Options.Destinations.
+func (s *Server) OpenSession(cfg Session) error {
+	return s.openSession(cfg, client{}, netip.Addr{})
+}
+
+// Opens a session for `owner`, or for whoever sent the control message from `origin` without
+// authentication. Sessions to game servers that aren't allowed are refused, and so is a new game
+// server for a client already at MaxDestinations.
+func (s *Server) openSession(cfg Session, owner client, origin netip.Addr) error {
+	if s.conn == nil {
+		return errors.New("the server is not listening")
+	}
+	if cfg.ID == "" || cfg.GameAddr == nil || cfg.ClientAddr == nil {
+		return errors.New("a session needs an ID, a game address and a client address")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[cfg.ID]; exists {
+		return fmt.Errorf("session %s: %w", cfg.ID, errSessionExists)
+	}
+	if len(s.sessions) >= s.opts.MaxSessions {
+		return fmt.Errorf("%w (%d)", errTooManySessions, len(s.sessions))
+	}
+	if !allowedDestination(s.opts.Destinations, cfg.GameAddr) {
+		s.denied.Add(1)
+		s.log.Warn("refusing a game server not in the allowed destinations", logging.KeySession, cfg.ID, "game", cfg.GameAddr)
+		return fmt.Errorf("%v: %w", cfg.GameAddr, errDestinationNotAllowed)
+	}
+	if n := s.destinationsLocked(owner, origin, cfg.GameAddr); n >= s.opts.MaxDestinations {
+		s.denied.Add(1)
+		return fmt.Errorf("%w (%d)", errTooManyDestinations, n)
+	}
+
+	sess, err := newSession(cfg, owner, origin, s.opts.CleanupInterval)
+	if err != nil {
+		return fmt.Errorf("session %s: %w", cfg.ID, err)
+	}
+	sess.capture = s.startCapture(sess)
+	s.sessions[cfg.ID] = sess
+	go s.relayFromGame(sess)
+
+	if owner.name != "" {
+		s.log.Info("session opened", logging.KeySession, cfg.ID, "game", cfg.GameAddr, logging.KeyClient, owner.name)
+	} else {
+		s.log.Info("session opened", logging.KeySession, cfg.ID, "game", cfg.GameAddr, logging.KeyClient, origin)
+	}
+	return nil
+}
+
+// Returns how many distinct game servers other than `game` the client has sessions with. Sessions
+// are attributed to their owner when authenticated, to their origin otherwise; local ones aren't limited.
+func (s *Server) destinationsLocked(owner client, origin netip.Addr, game *net.UDPAddr) int {
+	if owner.id == (protocol.KeyID{}) && !origin.IsValid() {
+		return 0
+	}
+	seen := make(map[string]bool)
+	for _, sess := range s.sessions {
+		same := sess.owner.id == owner.id
+		if owner.id == (protocol.KeyID{}) {
+			same = sess.owner.id == owner.id && sess.origin == origin
+		}
+		if same && !sameUDPAddr(sess.GameAddr, game) {
+			seen[sess.GameAddr.String()] = true
+		}
+	}
+	return len(seen)
+}
+
+// Stops relaying for the session `id`. Returns false if there is no such session.
+func (s *Server) CloseSession(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return false
+	}
+	s.removeSessionLocked(sess)
+	s.log.Info("session closed", logging.KeySession, id)
+	return true
+}
+
+// Returns a snapshot of every open session.
+func (s *Server) Sessions() []SessionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make([]SessionStats, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		stats = append(stats, sess.stats())
+	}
+	slices.SortFunc(stats, func(a, b SessionStats) int { return a.Opened.Compare(b.Opened) })
+	return stats
+}
+
+// Returns the session a packet `c` sent from `src` belongs to. Unknown sources are bound to the only
+// session accepting them; if none or several do, the packet can't be attributed and nil is returned.
+func (s *Server) sessionFor(src netip.AddrPort, c client) *session {
+	s.mu.RLock()
+	sess, ok := s.paths[src]
+	s.mu.RUnlock()
+	if ok {
+		if !sess.allows(c) {
+			return nil
+		}
+		return sess
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.paths[src]; ok {
+		if !sess.allows(c) {
+			return nil
+		}
+		return sess
+	}
+	var match *session
+	for _, candidate := range s.sessions {
+		if !candidate.accepts(src) || !candidate.allows(c) {
+			continue
+		}
+		if match != nil {
+			return nil // ambiguous
+		}
+		match = candidate
+	}
+	if match != nil {
+		s.paths[src] = match
+		match.paths.Add(1)
+	}
+	return match
+}
+
+func (s *Server) removeSessionLocked(sess *session) {
+	delete(s.sessions, sess.ID)
+	for src, owner := range s.paths {
+		if owner == sess {
+			delete(s.paths, src)
+		}
+	}
+	_ = sess.upstream.Close()
+	sess.capture.close()
+}
+
+func (s *Server) closeAllSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		s.removeSessionLocked(sess)
+	}
+}
+
+// Periodically cleans the dedupe trackers and closes sessions and tunnels idle for longer than IdleTimeout.
+func (s *Server) housekeeping(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireSessions()
+			s.expireTunnels()
+			s.limiter.cleanup(time.Now())
+		}
+	}
+}
+
+func (s *Server) expireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		sess.tracker.cleanupHash(time.Now())
+		if sess.idleFor() > s.opts.IdleTimeout {
+			s.removeSessionLocked(sess)
+			s.log.Info("session closed after being idle", logging.KeySession, sess.ID, "idle_timeout", s.opts.IdleTimeout)
+		}
+	}
+}
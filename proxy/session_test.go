@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Dials the proxy from a specific loopback IP, like a client path leaving through one interface.
+func dialFrom(t *testing.T, ip string, srv *Server) *net.UDPConn {
+	t.Helper()
+	conn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)}, srv.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Skipf("can't send from %s on this machine: %v", ip, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readString(t *testing.T, conn *net.UDPConn) string {
+	t.Helper()
+	buf := make([]byte, 512)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("read on %v: %v", conn.LocalAddr(), err)
+	}
+	return string(buf[:n])
+}
+
+func TestSessionsShareGameServer(t *testing.T) {
+	srv := startTestServer(t)
+	game := listenLoopback(t)
+	clientA := listenLoopback(t)
+	clientB := listenLoopback(t)
+
+	for id, cfg := range map[string]struct {
+		client *net.UDPConn
+		ip     string
+	}{"a": {clientA, "127.0.0.2"}, "b": {clientB, "127.0.0.3"}} {
+		err := srv.OpenSession(Session{
+			ID:         id,
+			GameAddr:   game.LocalAddr().(*net.UDPAddr),
+			ClientAddr: cfg.client.LocalAddr().(*net.UDPAddr),
+			ClientIPs:  []net.IP{net.ParseIP(cfg.ip)},
+		})
+		if err != nil {
+			t.Fatalf("OpenSession(%s): %v", id, err)
+		}
+	}
+
+	pathA := dialFrom(t, "127.0.0.2", srv)
+	pathB := dialFrom(t, "127.0.0.3", srv)
+	stranger := dialFrom(t, "127.0.0.4", srv)
+
+	// both teammates happen to send the same bytes; dedupe is per session
+	for _, path := range []*net.UDPConn{pathA, pathB, stranger} {
+		if _, err := path.Write([]byte("hello")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	buf := make([]byte, 512)
+	upstreams := make(map[string]*net.UDPAddr)
+	for range 2 {
+		_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := game.ReadFromUDP(buf)
+		if err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("game server read = %q, %v", buf[:n], err)
+		}
+		upstreams[from.String()] = from
+	}
+	if len(upstreams) != 2 {
+		t.Fatalf("sessions share an upstream socket: %v", upstreams)
+	}
+	_ = game.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := game.ReadFromUDP(buf); err == nil {
+		t.Fatalf("game server got a packet from an unknown source: %q", buf[:n])
+	}
+
+	// answer each upstream socket; each answer must reach exactly one client
+	got := make(map[string]bool)
+	for addr, upstream := range upstreams {
+		if _, err := game.WriteToUDP([]byte(addr), upstream); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	got[readString(t, clientA)] = true
+	got[readString(t, clientB)] = true
+	if len(got) != 2 {
+		t.Errorf("both clients got the same answer: %v", got)
+	}
+
+	if !srv.CloseSession("a") || srv.CloseSession("a") {
+		t.Errorf("CloseSession should succeed once")
+	}
+	if n := len(srv.Sessions()); n != 1 {
+		t.Errorf("len(Sessions()) = %d; want 1", n)
+	}
+	if err := srv.OpenSession(Session{ID: "b", GameAddr: game.LocalAddr().(*net.UDPAddr), ClientAddr: clientB.LocalAddr().(*net.UDPAddr)}); err == nil {
+		t.Errorf("expected an error reopening an existing session")
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	srv := startTestServer(t, func(opts *Options) {
+		opts.IdleTimeout = 50 * time.Millisecond
+		opts.CleanupInterval = 10 * time.Millisecond
+	})
+	game := listenLoopback(t)
+	client := listenLoopback(t)
+
+	err := srv.OpenSession(Session{
+		ID:         "idle",
+		GameAddr:   game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: client.LocalAddr().(*net.UDPAddr),
+	})
+	if err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(srv.Sessions()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(srv.Sessions()); n != 0 {
+		t.Errorf("len(Sessions()) = %d; want the idle session closed", n)
+	}
+}
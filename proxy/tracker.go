@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+type SeenHashTracker struct {
+	mu              sync.Mutex
+	SeenHash        map[uint64]time.Time
+	cleanupInterval time.Duration
+}
+
+// Creates blank new tracker
+func newTracker(cleanupInterval time.Duration) *SeenHashTracker {
+	return &SeenHashTracker{SeenHash: make(map[uint64]time.Time), cleanupInterval: cleanupInterval}
+}
+
+// Cleans entries in the hash tracker older than `cleanupInterval` at `now`
+func (tracker *SeenHashTracker) cleanupHash(now time.Time) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	for hash, ts := range tracker.SeenHash {
+		if now.Sub(ts) >= tracker.cleanupInterval {
+			delete(tracker.SeenHash, hash)
+		}
+	}
+}
+
+// Checks if a hash is was already saved in the hash tracker.
+// If it is, returns true. If it isn't, saves `now` with the hash
+// as a key and returns false.
+func (tracker *SeenHashTracker) isHashDuplicate(hash uint64, now time.Time) bool {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if _, exists := tracker.SeenHash[hash]; exists {
+		return true
+	}
+	tracker.SeenHash[hash] = now
+	return false
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+)
+
+// Tunnels beyond this many per allowed session are refused, so handshakes alone can't exhaust memory.
+const tunnelsPerSession = 4
+
+// Receiving side of an encrypted tunnel opened by a client's handshake.
+type tunnelState struct {
+	receiver   *tunnel.Receiver
+	client     client
+	lastActive atomic.Int64 // unix nanos
+}
+
+// Answers a HandshakeInit from `src`. Clients whose static key isn't authorized, whose handshake is older
+// than MaxClockSkew, or isn't newer than the last one accepted from their key (a replay), are dropped
+// without an answer and counted as rejected; false is returned for them.
+func (s *Server) handleHandshake(src netip.AddrPort, b []byte) bool {
+	noise, err := protocol.DecodeHandshakeInit(b)
+	if err != nil {
+		s.rejected.Add(1)
+		return false
+	}
+	accepted, err := tunnel.Accept(*s.opts.TunnelKey, noise)
+	if err != nil {
+		s.rejected.Add(1)
+		return false
+	}
+	if skew := time.Since(accepted.Started); skew > s.opts.MaxClockSkew || skew < -s.opts.MaxClockSkew {
+		s.rejected.Add(1)
+		return false
+	}
+	id := protocol.KeyIDOf(accepted.Peer[:])
+	key, ok := s.opts.TunnelClients.Lookup(id)
+	if !ok {
+		s.rejected.Add(1)
+		return false
+	}
+
+	state := &tunnelState{receiver: accepted.Receiver, client: client{id: id, name: key.Name}}
+	state.lastActive.Store(time.Now().UnixNano())
+
+	s.tunMu.Lock()
+	if !accepted.Started.After(s.handshakes[id]) {
+		s.tunMu.Unlock()
+		s.rejected.Add(1)
+		return false
+	}
+	s.handshakes[id] = accepted.Started
+	if len(s.tunnels) >= tunnelsPerSession*s.opts.MaxSessions {
+		s.tunMu.Unlock()
+		s.log.Warn("refusing a tunnel: too many tunnels", logging.KeyClient, key.Name)
+		return true
+	}
+	var index uint32
+	for {
+		var buf [4]byte
+		_, _ = rand.Read(buf[:])
+		index = binary.BigEndian.Uint32(buf[:])
+		if _, taken := s.tunnels[index]; !taken {
+			break
+		}
+	}
+	s.tunnels[index] = state
+	s.tunMu.Unlock()
+
+	if _, err := s.conn.WriteToUDPAddrPort(protocol.EncodeHandshakeResponse(index, accepted.Reply), src); err != nil {
+		s.log.Warn("failed to answer the handshake", "src", src, logging.KeyError, err)
+	}
+	return true
+}
+
+// Decrypts a TunnelData and returns what it carries and who sent it. Tunnels of clients no longer
+// authorized, because their key was revoked or expired, are closed.
+func (s *Server) openTunnel(b []byte) ([]byte, client, bool) {
+	data, err := protocol.DecodeTunnelData(b)
+	if err != nil {
+		return nil, client{}, false
+	}
+	s.tunMu.RLock()
+	state, ok := s.tunnels[data.Index]
+	s.tunMu.RUnlock()
+	if !ok {
+		return nil, client{}, false
+	}
+	if _, ok := s.opts.TunnelClients.Lookup(state.client.id); !ok {
+		s.tunMu.Lock()
+		delete(s.tunnels, data.Index)
+		s.tunMu.Unlock()
+		s.log.Info("closed a tunnel: client no longer authorized", logging.KeyClient, state.client.name)
+		return nil, client{}, false
+	}
+	plaintext, err := state.receiver.Open(b)
+	if err != nil {
+		return nil, client{}, false
+	}
+	state.lastActive.Store(time.Now().UnixNano())
+	return plaintext, state.client, true
+}
+
+// Forgets tunnels unused for longer than IdleTimeout.
+func (s *Server) expireTunnels() {
+	s.tunMu.Lock()
+	defer s.tunMu.Unlock()
+	for index, state := range s.tunnels {
+		if time.Since(time.Unix(0, state.lastActive.Load())) > s.opts.IdleTimeout {
+			delete(s.tunnels, index)
+		}
+	}
+}
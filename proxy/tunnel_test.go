@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+)
+
+func keypair(t *testing.T) tunnel.Keypair {
+	t.Helper()
+	kp, err := tunnel.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+// Runs a handshake with the server over `conn` and returns the resulting sender.
+func openTunnel(t *testing.T, conn net.Conn, local tunnel.Keypair, proxy [tunnel.KeySize]byte) *tunnel.Sender {
+	t.Helper()
+	initiator, noise, err := tunnel.StartHandshake(local, proxy, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, reply, err := protocol.DecodeHandshakeResponse(exchange(t, conn, protocol.EncodeHandshakeInit(noise)))
+	if err != nil {
+		t.Fatalf("DecodeHandshakeResponse: %v", err)
+	}
+	sender, err := initiator.Finish(index, reply)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	return sender
+}
+
+func TestTunnel(t *testing.T) {
+	proxyKey, carol, mallory := keypair(t), keypair(t), keypair(t)
+	clients := keys.NewRing([]keys.Key{{Name: "carol", Secret: carol.Public[:]}})
+	srv := startTestServer(t, func(opts *Options) {
+		opts.TunnelKey = &proxyKey
+		opts.TunnelClients = clients
+	})
+	game := listenLoopback(t)
+	gameClient := listenLoopback(t)
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// unauthorized clients get no answer at all
+	_, noise, err := tunnel.StartHandshake(mallory, proxyKey.Public, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(protocol.EncodeHandshakeInit(noise)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 512)
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Fatalf("unauthorized handshake was answered: %x", buf[:n])
+	}
+
+	sender := openTunnel(t, conn, carol, proxyKey.Public)
+	open := protocol.EncodeSessionOpen(protocol.SessionOpen{
+		SessionID:  "carol-game",
+		GameAddr:   game.LocalAddr().(*net.UDPAddr),
+		ClientAddr: gameClient.LocalAddr().(*net.UDPAddr),
+	})
+	if ack := control(t, conn, sender.Seal(open)); ack.Status != protocol.StatusOK {
+		t.Fatalf("open ack = %+v", ack)
+	}
+
+	move := sender.Seal([]byte("move"))
+	for _, b := range [][]byte{move, move, []byte("plaintext")} {
+		if _, err := conn.Write(b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	_ = game.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := game.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "move" {
+		t.Fatalf("game server read = %q, %v; want \"move\"", buf[:n], err)
+	}
+	_ = game.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := game.ReadFromUDP(buf); err == nil {
+		t.Fatalf("game server got a replayed or plaintext packet: %q", buf[:n])
+	}
+
+	if stats := srv.Sessions(); len(stats) != 1 || stats[0].Client != "carol" {
+		t.Errorf("Sessions() = %+v; want carol's session", stats)
+	}
+	// mallory's handshake, the replay and the plaintext packet
+	if got := srv.Stats().Rejected; got != 3 {
+		t.Errorf("Rejected = %d; want 3", got)
+	}
+
+	// pings go through the same tunnel
+	pingConn, err := net.Dial("udp", srv.PingAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer pingConn.Close()
+	req := protocol.EncodePingRequest(protocol.PingRequest{Nonce: 3, ClientSend: time.Now()})
+	if resp, err := protocol.DecodePingResponse(exchange(t, pingConn, sender.Seal(req))); err != nil || resp.Nonce != 3 {
+		t.Errorf("ping through the tunnel = %+v, %v", resp, err)
+	}
+
+	// revoking carol closes her tunnel
+	clients.Replace(nil)
+	if _, err := pingConn.Write(sender.Seal(req)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = pingConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := pingConn.Read(buf); err == nil {
+		t.Errorf("revoked client got an answer: %x", buf[:n])
+	}
+	srv.tunMu.RLock()
+	defer srv.tunMu.RUnlock()
+	if len(srv.tunnels) != 0 {
+		t.Errorf("%d tunnels left after revoking their client", len(srv.tunnels))
+	}
+}
+
+func TestHandshakeReplay(t *testing.T) {
+	proxyKey, carol := keypair(t), keypair(t)
+	srv := startTestServer(t, func(opts *Options) {
+		opts.TunnelKey = &proxyKey
+		opts.TunnelClients = keys.NewRing([]keys.Key{{Name: "carol", Secret: carol.Public[:]}})
+		opts.Limits = Limits{BanAfter: 2, BanDuration: time.Minute}
+	})
+	dial := func() net.Conn {
+		conn, err := net.Dial("udp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	carolPath, attacker := dial(), dial()
+
+	// two handshakes of carol sniffed on the way, both well within MaxClockSkew
+	var inits [][]byte
+	for _, started := range []time.Time{time.Now().Add(-10 * time.Second), time.Now()} {
+		_, noise, err := tunnel.StartHandshake(carol, proxyKey.Public, started)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inits = append(inits, protocol.EncodeHandshakeInit(noise))
+	}
+	if _, _, err := protocol.DecodeHandshakeResponse(exchange(t, carolPath, inits[1])); err != nil {
+		t.Fatalf("DecodeHandshakeResponse: %v", err)
+	}
+
+	// neither the same handshake nor an older one gets a tunnel, and each replay is a strike
+	for _, init := range inits {
+		if _, err := attacker.Write(init); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	buf := make([]byte, 512)
+	_ = attacker.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := attacker.Read(buf); err == nil {
+		t.Fatalf("replayed handshake was answered: %x", buf[:n])
+	}
+	if stats := srv.Stats(); stats.Rejected != 2 || stats.Bans != 1 {
+		t.Errorf("Stats() = %+v; want 2 rejected and 1 ban", stats)
+	}
+	srv.tunMu.RLock()
+	defer srv.tunMu.RUnlock()
+	if len(srv.tunnels) != 1 {
+		t.Errorf("%d tunnels; want 1", len(srv.tunnels))
+	}
+}
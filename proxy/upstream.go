@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+)
+
+const (
+	defaultUpstreamInterval = 5 * time.Second // how often each region is sampled
+	defaultUpstreamSamples  = 5               // how many samples the median is taken over
+	upstreamRequestTimeout  = 2 * time.Second
+)
+
+// UpstreamEstimator keeps a rolling estimate of the proxy's latency to every region it serves.
+// Samples are taken in the background so pings can be answered from the estimate without waiting on HTTP.
+// The zero value of every option falls back to a sensible default.
+type UpstreamEstimator struct {
+	Probers  map[string]UpstreamProber // how each region is sampled, see NewUpstreamProbers
+	Interval time.Duration             // how often every region is sampled
+	Samples  int                       // how many samples the median is taken over
+	MaxAge   time.Duration             // estimates whose last sample is older than this are stale (default 3×Interval)
+	Logger   *slog.Logger              // nil means slog.Default()
+
+	mu      sync.RWMutex
+	regions map[string]*regionSamples
+}
+
+// UpstreamEstimate is a snapshot of the estimator's view of a region.
+type UpstreamEstimate struct {
+	Latency time.Duration // median of the retained samples
+	Samples int           // number of retained samples
+	Updated time.Time     // when the last successful sample was taken
+	Stale   bool          // true if Updated is older than MaxAge
+	LastErr error         // error of the last sample, nil if it succeeded
+}
+
+type regionSamples struct {
+	samples []time.Duration // ring buffer of the last `Samples` measurements
+	next    int
+	updated time.Time
+	lastErr error
+}
+
+// Samples every region right away and then every `Interval` until ctx is done.
+func (e *UpstreamEstimator) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+
+	for {
+		e.sampleAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Returns the current estimate for `region`. The boolean is false if no sample has succeeded yet.
+func (e *UpstreamEstimator) Estimate(region string) (UpstreamEstimate, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	rs, ok := e.regions[region]
+	if !ok || len(rs.samples) == 0 {
+		return UpstreamEstimate{}, false
+	}
+
+	return UpstreamEstimate{
+		Latency: median(rs.samples),
+		Samples: len(rs.samples),
+		Updated: rs.updated,
+		Stale:   time.Since(rs.updated) > e.maxAge(),
+		LastErr: rs.lastErr,
+	}, true
+}
+
+// Takes one sample of every region concurrently.
+func (e *UpstreamEstimator) sampleAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for region, prober := range e.Probers {
+		wg.Add(1)
+		go func(region string, prober UpstreamProber) {
+			defer wg.Done()
+			latency, err := prober.Probe(ctx)
+			if err != nil && ctx.Err() == nil {
+				logger := e.Logger
+				if logger == nil {
+					logger = slog.Default()
+				}
+				logger.Warn("upstream sample failed", "region", region, logging.KeyError, err)
+			}
+			e.record(region, latency, err)
+		}(region, prober)
+	}
+	wg.Wait()
+}
+
+func (e *UpstreamEstimator) record(region string, latency time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.regions == nil {
+		e.regions = make(map[string]*regionSamples)
+	}
+	rs, ok := e.regions[region]
+	if !ok {
+		rs = &regionSamples{}
+		e.regions[region] = rs
+	}
+
+	rs.lastErr = err
+	if err != nil {
+		return
+	}
+
+	if len(rs.samples) < e.samples() {
+		rs.samples = append(rs.samples, latency)
+	} else {
+		rs.samples[rs.next] = latency
+	}
+	rs.next = (rs.next + 1) % e.samples()
+	rs.updated = time.Now()
+}
+
+func (e *UpstreamEstimator) interval() time.Duration {
+	if e.Interval <= 0 {
+		return defaultUpstreamInterval
+	}
+	return e.Interval
+}
+
+func (e *UpstreamEstimator) samples() int {
+	if e.Samples <= 0 {
+		return defaultUpstreamSamples
+	}
+	return e.Samples
+}
+
+func (e *UpstreamEstimator) maxAge() time.Duration {
+	if e.MaxAge <= 0 {
+		return 3 * e.interval()
+	}
+	return e.MaxAge
+}
+
+// Returns the median of `samples` without modifying it.
+func median(samples []time.Duration) time.Duration {
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func newUpstreamClient() *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DisableKeepAlives = true
+	return &http.Client{
+		Transport: tr,
+		Timeout:   upstreamRequestTimeout,
+	}
+}
+
+// Sends a HTTP request to `url` (by default one of the League servers' DynamoDB endpoints) and returns how long
+// it took between the request being written and the first response byte arriving. This leaves out the DNS resolution,
+// TCP handshake and everything else that is not the round trip itself (we call it the bloat).
+// Idea taken from https://pingtestlive.com/league-of-legends
+func httpLatency(ctx context.Context, client *http.Client, url string) (time.Duration, error) {
+	var (
+		tWriteDone time.Time
+		tFirstByte time.Time
+	)
+
+	// set up a trace to record exactly when the request is sent
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			tWriteDone = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			tFirstByte = time.Now()
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, fmt.Errorf("reading body: %w", err)
+	}
+
+	if tWriteDone.IsZero() || tFirstByte.IsZero() {
+		return 0, fmt.Errorf("trace events missing")
+	}
+
+	return tFirstByte.Sub(tWriteDone), nil
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// UpstreamProber measures a single round trip from the proxy towards a region's game servers.
+type UpstreamProber interface {
+	Probe(ctx context.Context) (time.Duration, error)
+}
+
+// Builds the prober for an upstream target (see Options and DefaultUpstreamTargets). The scheme picks the implementation:
+//   - http://, https:// time a GET request (the DynamoDB endpoints used by default)
+//   - tcp://host:port times a TCP handshake
+//   - udp://host:port times a datagram echoed back by the target
+//   - icmp://host times an ICMP echo; a port is ignored
+//
+// `client` is only used by the HTTP prober; nil means a client without keep-alives.
+func NewUpstreamProber(target string, client *http.Client) (UpstreamProber, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream target %q: %w", target, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &HTTPProber{Client: client, URL: target}, nil
+	case "tcp":
+		return &TCPProber{Addr: u.Host}, nil
+	case "udp":
+		return &UDPEchoProber{Addr: u.Host}, nil
+	case "icmp":
+		return &ICMPProber{Host: u.Hostname(), Privileged: runtime.GOOS == "windows"}, nil
+	default:
+		return nil, fmt.Errorf("unsupported upstream target scheme %q in %q", u.Scheme, target)
+	}
+}
+
+// Builds a prober for every region in `targets`.
+func NewUpstreamProbers(targets map[string]string, client *http.Client) (map[string]UpstreamProber, error) {
+	probers := make(map[string]UpstreamProber, len(targets))
+	for region, target := range targets {
+		p, err := NewUpstreamProber(target, client)
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+		probers[region] = p
+	}
+	return probers, nil
+}
+
+// HTTPProber times an HTTP GET from the moment the request is written until the first response byte.
+type HTTPProber struct {
+	Client *http.Client
+	URL    string
+}
+
+func (p *HTTPProber) Probe(ctx context.Context) (time.Duration, error) {
+	client := p.Client
+	if client == nil {
+		client = newUpstreamClient()
+	}
+	return httpLatency(ctx, client, p.URL)
+}
+
+// TCPProber times a TCP handshake to Addr. The connection is closed right away.
+type TCPProber struct {
+	Addr string
+}
+
+func (p *TCPProber) Probe(ctx context.Context) (time.Duration, error) {
+	addr, err := resolveBeforeTiming(ctx, p.Addr)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, upstreamRequestTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	t0 := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(t0)
+	_ = conn.Close()
+	return rtt, nil
+}
+
+// UDPEchoProber sends a random datagram to Addr and times how long the target takes to echo it back.
+type UDPEchoProber struct {
+	Addr string
+}
+
+func (p *UDPEchoProber) Probe(ctx context.Context) (time.Duration, error) {
+	addr, err := resolveBeforeTiming(ctx, p.Addr)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(probeDeadline(ctx)); err != nil {
+		return 0, err
+	}
+
+	payload := make([]byte, 16)
+	if _, err := rand.Read(payload); err != nil {
+		return 0, err
+	}
+
+	t0 := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(buf[:n], payload) {
+			return time.Since(t0), nil
+		}
+	}
+}
+
+// ICMPProber times an ICMP echo to Host. Privileged uses a raw socket (admin rights, required on Windows);
+// otherwise an unprivileged datagram socket is used, which on Linux needs `net.ipv4.ping_group_range`.
+type ICMPProber struct {
+	Host       string
+	Privileged bool
+}
+
+func (p *ICMPProber) Probe(ctx context.Context) (time.Duration, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", p.Host)
+	if err != nil {
+		return 0, err
+	}
+	if len(ips) == 0 {
+		return 0, fmt.Errorf("no IPv4 address for %s", p.Host)
+	}
+
+	network, laddr := "udp4", "0.0.0.0"
+	var dst net.Addr = &net.UDPAddr{IP: ips[0]}
+	if p.Privileged {
+		network = "ip4:icmp"
+		dst = &net.IPAddr{IP: ips[0]}
+	}
+
+	conn, err := icmp.ListenPacket(network, laddr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(probeDeadline(ctx)); err != nil {
+		return 0, err
+	}
+
+	seq := int(time.Now().UnixNano() & 0xffff)
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: []byte("lol-multipath")},
+	}
+	wb, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	t0 := time.Now()
+	if _, err := conn.WriteTo(wb, dst); err != nil {
+		return 0, err
+	}
+
+	rb := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(rb)
+		if err != nil {
+			return 0, err
+		}
+		msgBytes := rb[:n]
+		if p.Privileged && n > ipv4.HeaderLen && rb[0]>>4 == 4 {
+			// some raw sockets (e.g. Windows) hand us the IPv4 header as well
+			msgBytes = rb[int(rb[0]&0x0f)*4 : n]
+		}
+		reply, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), msgBytes)
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		// unprivileged sockets get their ID rewritten by the kernel, so only the sequence is matched
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.Seq == seq {
+			return time.Since(t0), nil
+		}
+	}
+}
+
+// Resolves `hostport` so DNS is not part of the measured round trip.
+func resolveBeforeTiming(ctx context.Context, hostport string) (string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return hostport, nil
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0], port), nil
+}
+
+// Returns the earliest of ctx's deadline and the default probe timeout.
+func probeDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(upstreamRequestTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
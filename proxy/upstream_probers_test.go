@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewUpstreamProber(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    UpstreamProber
+		wantErr bool
+	}{
+		{"https://dynamodb.us-east-2.amazonaws.com/ping?x=1", &HTTPProber{URL: "https://dynamodb.us-east-2.amazonaws.com/ping?x=1"}, false},
+		{"tcp://192.0.2.10:5100", &TCPProber{Addr: "192.0.2.10:5100"}, false},
+		{"udp://192.0.2.10:7", &UDPEchoProber{Addr: "192.0.2.10:7"}, false},
+		{"ftp://192.0.2.10", nil, true},
+		{"192.0.2.10:5100", nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.target, func(t *testing.T) {
+			got, err := NewUpstreamProber(tc.target, nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+
+	for _, target := range []string{"icmp://192.0.2.10", "icmp://192.0.2.10:1"} {
+		p, err := NewUpstreamProber(target, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if icmpProber, ok := p.(*ICMPProber); !ok || icmpProber.Host != "192.0.2.10" {
+			t.Errorf("%s: got %#v", target, p)
+		}
+	}
+}
+
+func TestTCPProber(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	p := &TCPProber{Addr: ln.Addr().String()}
+	rtt, err := p.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if rtt <= 0 || rtt > time.Second {
+		t.Errorf("rtt = %v; want a small positive duration", rtt)
+	}
+}
+
+func TestUDPEchoProber(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer echo.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteToUDP([]byte("noise"), addr)
+			_, _ = echo.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	p := &UDPEchoProber{Addr: echo.LocalAddr().String()}
+	rtt, err := p.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if rtt <= 0 || rtt > time.Second {
+		t.Errorf("rtt = %v; want a small positive duration", rtt)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	silent := &UDPEchoProber{Addr: "127.0.0.1:9"}
+	if _, err := silent.Probe(ctx); err == nil {
+		t.Errorf("expected an error probing a silent target")
+	}
+}
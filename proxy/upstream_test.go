@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMedian(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    time.Duration
+	}{
+		{"single", []time.Duration{10 * ms}, 10 * ms},
+		{"odd", []time.Duration{30 * ms, 10 * ms, 500 * ms}, 30 * ms},
+		{"even", []time.Duration{40 * ms, 10 * ms, 20 * ms, 30 * ms}, 25 * ms},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			in := append([]time.Duration(nil), tc.samples...)
+			if got := median(in); got != tc.want {
+				t.Errorf("median(%v) = %v; want %v", tc.samples, got, tc.want)
+			}
+			for i := range in {
+				if in[i] != tc.samples[i] {
+					t.Fatalf("median modified its input: %v", in)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamEstimator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	probers, err := NewUpstreamProbers(map[string]string{
+		"NA":  srv.URL,
+		"EUW": "http://127.0.0.1:1/unreachable",
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("NewUpstreamProbers: %v", err)
+	}
+	e := &UpstreamEstimator{Probers: probers, Samples: 3, MaxAge: time.Hour}
+
+	if _, ok := e.Estimate("NA"); ok {
+		t.Fatalf("expected no estimate before sampling")
+	}
+
+	ctx := context.Background()
+	for range 4 {
+		e.sampleAll(ctx)
+	}
+
+	est, ok := e.Estimate("NA")
+	if !ok {
+		t.Fatalf("expected an estimate for NA")
+	}
+	if est.Samples != 3 {
+		t.Errorf("Samples = %d; want 3 (window size)", est.Samples)
+	}
+	if est.Latency < 20*time.Millisecond || est.Latency > time.Second {
+		t.Errorf("Latency = %v; want around 20ms", est.Latency)
+	}
+	if est.Stale || est.LastErr != nil {
+		t.Errorf("unexpected stale=%v lastErr=%v", est.Stale, est.LastErr)
+	}
+
+	if _, ok := e.Estimate("EUW"); ok {
+		t.Errorf("expected no estimate for an unreachable region")
+	}
+
+	e.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if est, _ := e.Estimate("NA"); !est.Stale {
+		t.Errorf("expected estimate to be stale after MaxAge")
+	}
+}
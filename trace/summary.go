@@ -0,0 +1,166 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Summary is what happened in a trace, as a whole and per path.
+type Summary struct {
+	Session     *Session // nil if the trace lacks its first record
+	Start, End  time.Time
+	Packets     int
+	Bytes       int
+	Unsent      int // packets no path was selected for or every write of failed
+	WriteErrors int
+	Selections  int
+	Changes     int           // selections that changed the candidates or their order
+	MaxGap      time.Duration // longest time without an intercepted packet
+	MaxGapAt    time.Time     // when that gap started
+	Paths       []PathSummary // by path ID
+}
+
+// PathSummary is what happened to a path in a trace.
+type PathSummary struct {
+	ID          int
+	Packets     int // packets written to it
+	Bytes       int
+	WriteErrors int
+	Pings       int
+	Lost        int
+	MinRTT      time.Duration // of the answered pings, client↔proxy
+	AvgRTT      time.Duration
+	MaxRTT      time.Duration
+	AvgPingMs   float64 // expected client↔server ping of the answered pings
+	Probes      int
+	Recoveries  int // probes that got through
+	Selected    int // selections it was active in
+}
+
+// Summarizes `records`, in the order they were written.
+func Summarize(records []Record) Summary {
+	var s Summary
+	paths := make(map[int]*PathSummary)
+	path := func(id int) *PathSummary {
+		p, ok := paths[id]
+		if !ok {
+			p = &PathSummary{ID: id}
+			paths[id] = p
+		}
+		return p
+	}
+	rttSum := make(map[int]time.Duration)
+	pingSum := make(map[int]int64)
+	var lastPacket time.Time
+
+	for i, r := range records {
+		at := r.Time()
+		if i == 0 {
+			s.Start = at
+		}
+		s.End = at
+		switch {
+		case r.Session != nil:
+			s.Session = r.Session
+			for _, p := range r.Session.Paths {
+				path(p.ID)
+			}
+		case r.Packet != nil:
+			s.Packets++
+			s.Bytes += r.Packet.Size
+			if len(r.Packet.Sent) == 0 {
+				s.Unsent++
+			}
+			s.WriteErrors += len(r.Packet.Errors)
+			for _, id := range r.Packet.Sent {
+				p := path(id)
+				p.Packets++
+				p.Bytes += r.Packet.Size
+			}
+			for _, e := range r.Packet.Errors {
+				path(e.Path).WriteErrors++
+			}
+			if !lastPacket.IsZero() && at.Sub(lastPacket) > s.MaxGap {
+				s.MaxGap, s.MaxGapAt = at.Sub(lastPacket), lastPacket
+			}
+			lastPacket = at
+		case r.Ping != nil:
+			p := path(r.Ping.Path)
+			p.Pings++
+			if r.Ping.Lost {
+				p.Lost++
+				continue
+			}
+			rtt := time.Duration(r.Ping.RTTUs) * time.Microsecond
+			if p.Pings-p.Lost == 1 || rtt < p.MinRTT {
+				p.MinRTT = rtt
+			}
+			p.MaxRTT = max(p.MaxRTT, rtt)
+			rttSum[p.ID] += rtt
+			pingSum[p.ID] += r.Ping.PingMs
+		case r.Probe != nil:
+			p := path(r.Probe.Path)
+			p.Probes++
+			if r.Probe.Up {
+				p.Recoveries++
+			}
+		case r.Selection != nil:
+			s.Selections++
+			if r.Selection.Changed {
+				s.Changes++
+			}
+			for _, id := range r.Selection.Active {
+				path(id).Selected++
+			}
+		}
+	}
+
+	for _, p := range paths {
+		if answered := p.Pings - p.Lost; answered > 0 {
+			p.AvgRTT = rttSum[p.ID] / time.Duration(answered)
+			p.AvgPingMs = float64(pingSum[p.ID]) / float64(answered)
+		}
+		s.Paths = append(s.Paths, *p)
+	}
+	slices.SortFunc(s.Paths, func(a, b PathSummary) int { return a.ID - b.ID })
+	return s
+}
+
+// Writes the summary as a human-readable report.
+func (s Summary) Format(w io.Writer) error {
+	if s.Session != nil {
+		fmt.Fprintf(w, "session %s, game server %s\n", s.Session.ID, s.Session.GameAddr)
+	}
+	fmt.Fprintf(w, "%s to %s (%s)\n", s.Start.Format(time.DateTime), s.End.Format(time.DateTime), s.End.Sub(s.Start).Round(time.Second))
+	fmt.Fprintf(w, "%d packets, %d bytes, %d unsent, %d write errors\n", s.Packets, s.Bytes, s.Unsent, s.WriteErrors)
+	fmt.Fprintf(w, "%d selections, %d changed the paths\n", s.Selections, s.Changes)
+	if s.MaxGap > 0 {
+		fmt.Fprintf(w, "longest gap between packets %s at %s\n", s.MaxGap.Round(time.Millisecond), s.MaxGapAt.Format("15:04:05.000"))
+	}
+	fmt.Fprintln(w)
+
+	locals := make(map[int]Path)
+	if s.Session != nil {
+		for _, p := range s.Session.Paths {
+			locals[p.ID] = p
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "ID\tPATH\tPACKETS\tERRORS\tPINGS\tLOST\tRTT MIN/AVG/MAX\tPING AVG\tPROBES\tRECOVERED\tSELECTED\t")
+	for _, p := range s.Paths {
+		name := "?"
+		if info, ok := locals[p.ID]; ok {
+			name = info.Local + " → " + info.Proxy
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%s/%s/%s\t%.0fms\t%d\t%d\t%d\t\n", p.ID, name, p.Packets, p.WriteErrors, p.Pings, p.Lost,
+			ms(p.MinRTT), ms(p.AvgRTT), ms(p.MaxRTT), p.AvgPingMs, p.Probes, p.Recoveries, p.Selected)
+	}
+	return tw.Flush()
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,209 @@
+// Package trace is the format of the session traces the client records: a JSON line per record, the first one
+// describing the session and its paths, then every game packet with the paths it was sent on and the writes
+// that failed, every ping of a path, every probe of a down path and every selection. It writes, reads and
+// summarizes them, so a laggy match can be reconstructed afterwards.
+//
+// Paths are numbered as in the client's status API. Traces hold IP addresses as they are.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a line of a trace, exactly one of its pointers is set.
+type Record struct {
+	T         int64      `json:"t"` // Unix time in microseconds
+	Session   *Session   `json:"session,omitempty"`
+	Packet    *Packet    `json:"packet,omitempty"`
+	Ping      *Ping      `json:"ping,omitempty"`
+	Probe     *Probe     `json:"probe,omitempty"`
+	Selection *Selection `json:"selection,omitempty"`
+}
+
+// Returns the time of the record.
+func (r Record) Time() time.Time {
+	return time.UnixMicro(r.T)
+}
+
+// Session is the first record of a trace.
+type Session struct {
+	ID         string `json:"id"`
+	GameAddr   string `json:"game_addr"`
+	ClientAddr string `json:"client_addr"`
+	Paths      []Path `json:"paths"`
+}
+
+// Path is a candidate interface×proxy path of the session.
+type Path struct {
+	ID    int    `json:"id"`
+	Local string `json:"local"` // IP of the local interface
+	Proxy string `json:"proxy"` // proxy listen address
+}
+
+// Packet is an intercepted game packet.
+type Packet struct {
+	Size   int          `json:"size"`
+	Sent   []int        `json:"sent"` // paths it was written to
+	Errors []WriteError `json:"errors,omitempty"`
+}
+
+// WriteError is a write of a packet that failed, the path is then down until a probe gets through.
+type WriteError struct {
+	Path int    `json:"path"`
+	Err  string `json:"err"`
+}
+
+// Ping is a ping of a path, taken by a selection round.
+type Ping struct {
+	Path       int    `json:"path"`
+	PingMs     int64  `json:"ping_ms"`     // expected client↔server ping through the path
+	RTTUs      int64  `json:"rtt_us"`      // client↔proxy leg
+	UpstreamUs int64  `json:"upstream_us"` // proxy↔server leg as the proxy reports it
+	Lost       bool   `json:"lost,omitempty"`
+	Err        string `json:"err,omitempty"`
+}
+
+// Probe is a probe of a down path.
+type Probe struct {
+	Path int    `json:"path"`
+	Up   bool   `json:"up"`
+	Err  string `json:"err,omitempty"`
+}
+
+// Selection is the outcome of a selection round.
+type Selection struct {
+	Candidates []int `json:"candidates"` // by ascending ping, the paths missing were trimmed for good
+	Active     []int `json:"active"`     // the candidates the next packet goes to, see the client's pin, ban and pause
+	Changed    bool  `json:"changed"`
+}
+
+// Writer writes records to a trace. It is safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	bw     *bufio.Writer
+	enc    *json.Encoder
+	closer io.Closer
+	err    error // first error, later writes are dropped
+}
+
+// Creates a writer to `w`. The records are buffered, see Flush.
+func NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	return &Writer{bw: bw, enc: json.NewEncoder(bw)}
+}
+
+// Creates the trace of `session` in `dir`, named after its start time and ID.
+func Create(dir string, session Session, at time.Time) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s.jsonl", at.UTC().Format("20060102T150405Z"), sanitize(session.ID))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWriter(f)
+	w.closer = f
+	if err := w.Write(Record{T: at.UnixMicro(), Session: &session}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Keeps the characters of a session ID that are safe in a file name.
+func sanitize(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// Writes a record. Packets are buffered, the other records are flushed right away.
+// Once a write failed, the following ones are dropped and return the same error.
+func (w *Writer) Write(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.enc.Encode(r); w.err == nil && r.Packet == nil {
+		w.err = w.bw.Flush()
+	}
+	return w.err
+}
+
+// Writes the buffered records.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	return w.err
+}
+
+// Flushes the records and closes the file of a writer made by Create.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		err = errors.Join(err, w.closer.Close())
+	}
+	return err
+}
+
+// Reader reads the records of a trace.
+type Reader struct {
+	dec  *json.Decoder
+	line int
+}
+
+// Creates a reader of the trace in `r`.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+// Returns the next record, io.EOF at the end of the trace.
+func (r *Reader) Next() (Record, error) {
+	var rec Record
+	if err := r.dec.Decode(&rec); err != nil {
+		if err == io.EOF {
+			return Record{}, err
+		}
+		return Record{}, fmt.Errorf("record %d: %w", r.line+1, err)
+	}
+	r.line++
+	return rec, nil
+}
+
+// Reads every record of the trace at `path`. A record cut short by a crash ends the trace without an error.
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []Record
+	r := NewReader(f)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
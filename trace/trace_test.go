@@ -0,0 +1,108 @@
+package trace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	session := Session{ID: "game/1", GameAddr: "192.0.2.1:5100", Paths: []Path{{ID: 0, Local: "10.0.0.2", Proxy: "A:9029"}, {ID: 1, Local: "10.0.1.2", Proxy: "A:9029"}}}
+	w, err := Create(dir, session, start)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	at := func(ms int) int64 { return start.Add(time.Duration(ms) * time.Millisecond).UnixMicro() }
+	for _, r := range []Record{
+		{T: at(1), Ping: &Ping{Path: 0, PingMs: 40, RTTUs: 20000, UpstreamUs: 20000}},
+		{T: at(1), Ping: &Ping{Path: 1, Lost: true, Err: "timeout"}},
+		{T: at(2), Selection: &Selection{Candidates: []int{0, 1}, Active: []int{0, 1}, Changed: true}},
+		{T: at(10), Packet: &Packet{Size: 100, Sent: []int{0, 1}}},
+		{T: at(20), Packet: &Packet{Size: 50, Sent: []int{0}, Errors: []WriteError{{Path: 1, Err: "unreachable"}}}},
+		{T: at(500), Packet: &Packet{Size: 50, Sent: []int{}}},
+		{T: at(900), Probe: &Probe{Path: 1, Up: true}},
+	} {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 || filepath.Base(files[0]) != "20240501T200000Z-game_1.jsonl" {
+		t.Fatalf("trace files = %v", files)
+	}
+	records, err := ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(records) != 8 || records[0].Session == nil || records[0].Session.ID != "game/1" {
+		t.Fatalf("read %d records, first %+v", len(records), records[0])
+	}
+
+	s := Summarize(records)
+	if s.Packets != 3 || s.Bytes != 200 || s.Unsent != 1 || s.WriteErrors != 1 || s.Selections != 1 || s.Changes != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.MaxGap != 480*time.Millisecond || !s.MaxGapAt.Equal(start.Add(20*time.Millisecond)) {
+		t.Errorf("max gap %v at %v", s.MaxGap, s.MaxGapAt)
+	}
+	if len(s.Paths) != 2 {
+		t.Fatalf("paths = %+v", s.Paths)
+	}
+	p0, p1 := s.Paths[0], s.Paths[1]
+	if p0.Packets != 2 || p0.Bytes != 150 || p0.Pings != 1 || p0.AvgRTT != 20*time.Millisecond || p0.AvgPingMs != 40 || p0.Selected != 1 {
+		t.Errorf("path 0 = %+v", p0)
+	}
+	if p1.Packets != 1 || p1.WriteErrors != 1 || p1.Lost != 1 || p1.Probes != 1 || p1.Recoveries != 1 {
+		t.Errorf("path 1 = %+v", p1)
+	}
+
+	var b strings.Builder
+	if err := s.Format(&b); err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	for _, want := range []string{"session game/1", "3 packets, 200 bytes, 1 unsent, 1 write errors", "10.0.0.2 → A:9029", "longest gap between packets 480ms"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestReadFileTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	content := `{"t":1,"session":{"id":"s","game_addr":"","client_addr":"","paths":[]}}` + "\n" + `{"t":2,"packet":{"size":10,"sent":[0]}}` + "\n" + `{"t":3,"pack`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadFile(path)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("ReadFile = %d records, %v; want 2 records", len(records), err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"t":1}`+"\n"+`not json`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Errorf("ReadFile of a corrupt trace = %v, want an error on record 2", err)
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Now()
+	w, err := Create(dir, Session{ID: "s"}, at)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer w.Close()
+	if _, err := Create(dir, Session{ID: "s"}, at); err == nil {
+		t.Error("Create overwrote an existing trace")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package tui
+
+import "os"
+
+func setupTerminal(f *os.File) (func(), error) {
+	return func() {}, nil
+}
+
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Unix terminals understand the escape sequences already.
+func setupTerminal(f *os.File) (func(), error) {
+	return func() {}, nil
+}
+
+// Returns the size of the terminal of `f`.
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 0, 0, false
+	}
+	return int(ws.Col), int(ws.Row), true
+}
@@ -0,0 +1,29 @@
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Enables the escape sequences on the console of `f` and returns how to restore its mode.
+func setupTerminal(f *os.File) (func(), error) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		return nil, err
+	}
+	return func() { _ = windows.SetConsoleMode(h, mode) }, nil
+}
+
+// Returns the size of the console window of `f`.
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info); err != nil {
+		return 0, 0, false
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, true
+}
@@ -0,0 +1,254 @@
+// Package tui draws a full-screen terminal dashboard of a running client: a header with the game session,
+// and a row per interface×proxy path with its measurements and state, above the latest log lines.
+// It only needs a terminal that understands ANSI escape sequences.
+package tui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SergioFloresCorrea/lol-multipath/udpmultipath"
+)
+
+const (
+	defaultWidth  = 120 // used when the terminal size can't be read
+	defaultHeight = 30
+
+	clearLine   = "\x1b[K"
+	clearBelow  = "\x1b[J"
+	cursorHome  = "\x1b[H"
+	enterScreen = "\x1b[?1049h\x1b[?25l" // alternate screen, hidden cursor
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	reset       = "\x1b[0m"
+	bold        = "\x1b[1m"
+	red         = "\x1b[31m"
+	green       = "\x1b[32m"
+	yellow      = "\x1b[33m"
+	grey        = "\x1b[90m"
+)
+
+// Snapshot is what a frame of the dashboard shows.
+type Snapshot struct {
+	Status     udpmultipath.Status
+	Duplicates *uint64  // duplicates suppressed by the proxies, nil if unknown
+	Logs       []string // latest log lines, oldest first
+}
+
+// Dashboard redraws the snapshot returned by Snapshot every Interval.
+type Dashboard struct {
+	Snapshot func() Snapshot
+	Interval time.Duration // default 250ms
+}
+
+// Takes over `out`, a terminal, and redraws the dashboard on it until ctx is done; the terminal is restored on return.
+func (d *Dashboard) Run(ctx context.Context, out *os.File) error {
+	restore, err := setupTerminal(out)
+	if err != nil {
+		return fmt.Errorf("terminal: %w", err)
+	}
+	defer restore()
+	fmt.Fprint(out, enterScreen)
+	defer fmt.Fprint(out, leaveScreen)
+
+	interval := d.Interval
+	if interval <= 0 {
+		interval = 250 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var frame bytes.Buffer
+	for {
+		width, height, ok := terminalSize(out)
+		if !ok {
+			width, height = defaultWidth, defaultHeight
+		}
+		frame.Reset()
+		Render(&frame, d.Snapshot(), width, height)
+		if _, err := out.Write(frame.Bytes()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Writes a frame showing `snap` on a `width`×`height` terminal, from the top left corner.
+func Render(w io.Writer, snap Snapshot, width, height int) {
+	var lines []string
+	status := snap.Status
+
+	header := bold + "lol-multipath" + reset
+	if status.Session != nil {
+		header += fmt.Sprintf("  session %s  game %s", status.Session.ID, status.Session.GameAddr)
+	} else {
+		header += "  waiting for the game session…"
+	}
+	header += fmt.Sprintf("  max connections %d", status.MaxConnections)
+	if status.Paused {
+		header += "  " + yellow + "paused" + reset
+	}
+	if snap.Duplicates != nil {
+		header += fmt.Sprintf("  duplicates suppressed %d", *snap.Duplicates)
+	}
+	lines = append(lines, header, "")
+
+	lines = append(lines, bold+fmt.Sprintf("%3s  %-15s  %-21s  %-8s  %6s  %6s  %6s  %5s  %-30s  %9s", "ID", "LOCAL", "PROXY", "STATE", "PING", "RTT", "JITTER", "LOSS", "RECENT RTT", "SENT")+reset)
+	for _, p := range status.Paths {
+		lines = append(lines, pathRow(p))
+	}
+	lines = append(lines, "")
+
+	// the logs get whatever height is left, newest at the bottom
+	logs := snap.Logs
+	if room := height - len(lines); room < len(logs) {
+		logs = logs[max(len(logs)-room, 0):]
+	}
+	for _, line := range logs {
+		lines = append(lines, grey+line+reset)
+	}
+
+	var b strings.Builder
+	b.WriteString(cursorHome)
+	for i, line := range lines {
+		if i == height {
+			break
+		}
+		b.WriteString(truncate(line, width))
+		b.WriteString(reset + clearLine)
+		if i < height-1 && i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString(clearBelow)
+	io.WriteString(w, b.String())
+}
+
+func pathRow(p udpmultipath.PathStatus) string {
+	state := string(p.State)
+	switch p.State {
+	case udpmultipath.PathSelected:
+		state = green + fmt.Sprintf("%-8s", state) + reset
+	case udpmultipath.PathStandby:
+		state = yellow + fmt.Sprintf("%-8s", state) + reset
+	case udpmultipath.PathDown:
+		state = red + fmt.Sprintf("%-8s", state) + reset
+	default:
+		state = grey + fmt.Sprintf("%-8s", state) + reset
+	}
+	ping, rtt := "-", "-"
+	if p.PingFailed {
+		ping, rtt = "lost", "lost"
+	} else if !p.MeasuredAt.IsZero() {
+		ping = fmt.Sprintf("%dms", p.PingMs)
+		rtt = fmt.Sprintf("%.0fms", p.ProxyMs)
+	}
+	flags := ""
+	if p.Pinned {
+		flags += " pinned"
+	}
+	if p.Banned {
+		flags += " banned"
+	}
+	return fmt.Sprintf("%3d  %-15s  %-21s  %s  %6s  %6s  %5.1fms  %4.0f%%  %s  %9d%s",
+		p.ID, p.Local, p.Proxy, state, ping, rtt, p.JitterMs, p.LossPct, Sparkline(p.RecentRTTMs, 30), p.PacketsSent, flags)
+}
+
+var levels = []rune("▁▂▃▄▅▆▇█")
+
+// Draws `values`, negative ones as lost, as a sparkline padded to `width` runes; only the last `width` values fit.
+func Sparkline(values []float64, width int) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if v >= 0 {
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		switch {
+		case v < 0:
+			b.WriteString(red + "×" + reset)
+		case hi == lo:
+			b.WriteRune(levels[0])
+		default:
+			b.WriteRune(levels[int((v-lo)/(hi-lo)*float64(len(levels)-1)+0.5)])
+		}
+	}
+	b.WriteString(strings.Repeat(" ", width-len(values)))
+	return b.String()
+}
+
+// Cuts `s` to `width` visible runes, escape sequences don't count.
+func truncate(s string, width int) string {
+	visible := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			end := strings.IndexByte(s[i:], 'm')
+			if end < 0 {
+				return s[:i]
+			}
+			i += end + 1
+			continue
+		}
+		if visible == width {
+			return s[:i]
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		visible++
+	}
+	return s
+}
+
+// LogBuffer keeps the last lines written to it, to send the log to while the dashboard owns the terminal.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	max     int
+	partial []byte
+}
+
+// Creates a buffer keeping the last `n` lines.
+func NewLogBuffer(n int) *LogBuffer {
+	return &LogBuffer{max: n}
+}
+
+func (l *LogBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.lines = append(l.lines, strings.TrimRight(string(l.partial[:i]), "\r"))
+		l.partial = l.partial[i+1:]
+	}
+	if len(l.lines) > l.max {
+		l.lines = append(l.lines[:0], l.lines[len(l.lines)-l.max:]...)
+	}
+	return len(p), nil
+}
+
+// Returns the kept lines, oldest first.
+func (l *LogBuffer) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
@@ -0,0 +1,69 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/udpmultipath"
+)
+
+func TestSparkline(t *testing.T) {
+	if got, want := Sparkline([]float64{10, 20, -1, 45}, 6), "▁▃"+red+"×"+reset+"█  "; got != want {
+		t.Errorf("Sparkline = %q; want %q", got, want)
+	}
+	if got, want := Sparkline([]float64{5, 5, 5}, 2), "▁▁"; got != want {
+		t.Errorf("Sparkline of flat values = %q; want %q", got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := green + "selected" + reset + " ▁▂▃"
+	if got, want := truncate(s, 10), green+"selected"+reset+" ▁"; got != want {
+		t.Errorf("truncate = %q; want %q", got, want)
+	}
+	if got := truncate(s, 100); got != s {
+		t.Errorf("truncate of a short line = %q", got)
+	}
+}
+
+func TestLogBuffer(t *testing.T) {
+	logs := NewLogBuffer(2)
+	fmt.Fprint(logs, "one\ntwo\nthr")
+	fmt.Fprint(logs, "ee\r\n")
+	if got := logs.Lines(); len(got) != 2 || got[0] != "two" || got[1] != "three" {
+		t.Errorf("Lines = %q; want [two three]", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	dups := uint64(7)
+	snap := Snapshot{
+		Status: udpmultipath.Status{
+			Session:        &udpmultipath.SessionStatus{ID: "abcd", GameAddr: "192.0.2.1:5100"},
+			MaxConnections: 2,
+			Paths: []udpmultipath.PathStatus{
+				{ID: 0, Local: "192.168.1.2", Proxy: "203.0.113.7:9029", State: udpmultipath.PathSelected, PingMs: 45, ProxyMs: 12, MeasuredAt: time.Now(), PacketsSent: 1234, Pinned: true},
+				{ID: 1, Local: "10.0.0.2", Proxy: "203.0.113.7:9029", State: udpmultipath.PathDown, PingFailed: true, MeasuredAt: time.Now()},
+			},
+		},
+		Duplicates: &dups,
+		Logs:       []string{"old", "older", "newest"},
+	}
+	var b strings.Builder
+	Render(&b, snap, 200, 7)
+	frame := b.String()
+	for _, want := range []string{"session abcd  game 192.0.2.1:5100", "duplicates suppressed 7", "203.0.113.7:9029", "45ms", "1234 pinned", "lost", "newest"} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("frame lacks %q:\n%s", want, frame)
+		}
+	}
+	// header, blank, column names, two paths and a blank leave a single line for the logs
+	if strings.Contains(frame, "older") {
+		t.Errorf("frame has more log lines than fit:\n%s", frame)
+	}
+	if lines := strings.Count(frame, "\r\n") + 1; lines != 7 {
+		t.Errorf("frame has %d lines; want 7", lines)
+	}
+}
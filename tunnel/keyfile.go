@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Parses a hex-encoded key.
+func ParseKey(s string) ([KeySize]byte, error) {
+	var key [KeySize]byte
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return key, err
+	}
+	if len(b) != KeySize {
+		return key, fmt.Errorf("a key is %d bytes, got %d", KeySize, len(b))
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+// Loads the keypair whose hex-encoded private key is in the file at `path`. Blank lines and lines
+// starting with # are ignored.
+func LoadKeypair(path string) (Keypair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Keypair{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		priv, err := ParseKey(line)
+		if err != nil {
+			return Keypair{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return KeypairFromPrivate(priv[:])
+	}
+	return Keypair{}, fmt.Errorf("%s: no private key", path)
+}
+
+// Writes the private key of `kp` to a new file at `path`, readable only by its owner, in the format
+// LoadKeypair reads. The public key is added as a comment.
+func SaveKeypair(path string, kp Keypair) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "# public %x\n%x\n", kp.Public, kp.Private); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,285 @@
+// Package tunnel implements the optional encrypted transport between the client and its proxies.
+//
+// Per session, the client runs a Noise_IK_25519_ChaChaPoly_BLAKE2s handshake with the proxy: the
+// client knows the proxy's static public key beforehand (see the pair command), the proxy learns
+// the client's and checks it against its authorized clients. The handshake yields ephemeral keys,
+// and every datagram the client sends afterwards is sealed with ChaCha20-Poly1305 under a counter
+// the proxy checks against a sliding replay window. Only that direction is encrypted: the proxy
+// relays the game server's answers to the game client in plaintext.
+package tunnel
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	KeySize = 32
+
+	protocolName = "Noise_IK_25519_ChaChaPoly_BLAKE2s"
+	prologue     = "lol-multipath tunnel v1"
+
+	// initiator: e, encrypted s, encrypted timestamp
+	initSize = KeySize + (KeySize + chacha20poly1305.Overhead) + (8 + chacha20poly1305.Overhead)
+	// responder: e, encrypted empty payload
+	responseSize = KeySize + chacha20poly1305.Overhead
+)
+
+var (
+	ErrHandshake = errors.New("tunnel: handshake failed")
+	ErrDecrypt   = errors.New("tunnel: decryption failed")
+	ErrReplay    = errors.New("tunnel: replayed or too old packet")
+)
+
+// Keypair is a static X25519 keypair.
+type Keypair struct {
+	Private [KeySize]byte
+	Public  [KeySize]byte
+}
+
+// Generates a new random keypair.
+func GenerateKeypair() (Keypair, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Keypair{}, err
+	}
+	return keypairOf(priv), nil
+}
+
+// Returns the keypair of the private key `priv`.
+func KeypairFromPrivate(priv []byte) (Keypair, error) {
+	key, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return Keypair{}, err
+	}
+	return keypairOf(key), nil
+}
+
+func keypairOf(key *ecdh.PrivateKey) Keypair {
+	var kp Keypair
+	copy(kp.Private[:], key.Bytes())
+	copy(kp.Public[:], key.PublicKey().Bytes())
+	return kp
+}
+
+func dh(priv, pub []byte) ([]byte, error) {
+	key, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return key.ECDH(peer)
+}
+
+// symmetricState is the Noise SymmetricState: the chaining key, the handshake hash and the current cipher key.
+type symmetricState struct {
+	ck, h [blake2s.Size]byte
+	k     []byte // nil until the first MixKey
+	n     uint64
+	err   error // first DH failure, checked once the message is complete
+}
+
+func newSymmetricState(responderStatic []byte) *symmetricState {
+	s := &symmetricState{}
+	s.h = blake2s.Sum256([]byte(protocolName)) // the name is longer than the hash, so it is hashed
+	s.ck = s.h
+	s.mixHash([]byte(prologue))
+	s.mixHash(responderStatic) // IK pre-message: <- s
+	return s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h, _ := blake2s.New256(nil)
+	h.Write(s.h[:])
+	h.Write(data)
+	h.Sum(s.h[:0])
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	ck, k := hkdf(s.ck[:], ikm)
+	s.ck, s.k, s.n = ck, k[:], 0
+}
+
+func (s *symmetricState) mixDH(priv, pub []byte) {
+	shared, err := dh(priv, pub)
+	if err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+		shared = make([]byte, KeySize)
+	}
+	s.mixKey(shared)
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	aead, _ := chacha20poly1305.New(s.k)
+	ciphertext := aead.Seal(nil, nonce(s.n), plaintext, s.h[:])
+	s.n++
+	s.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(s.k)
+	plaintext, err := aead.Open(nil, nonce(s.n), ciphertext, s.h[:])
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	s.n++
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// Returns the key for initiator→responder transport messages. The other direction is unused: the proxy
+// sends the game server's packets to the game client as they are, as it couldn't decrypt them.
+func (s *symmetricState) split() []byte {
+	k1, _ := hkdf(s.ck[:], nil)
+	return k1[:]
+}
+
+func newBlake2s() hash.Hash {
+	h, _ := blake2s.New256(nil)
+	return h
+}
+
+// The Noise HKDF with two outputs.
+func hkdf(ck, ikm []byte) ([blake2s.Size]byte, [blake2s.Size]byte) {
+	var out1, out2 [blake2s.Size]byte
+	mac := hmac.New(newBlake2s, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(newBlake2s, temp)
+	mac.Write([]byte{1})
+	mac.Sum(out1[:0])
+
+	mac = hmac.New(newBlake2s, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{2})
+	mac.Sum(out2[:0])
+	return out1, out2
+}
+
+func nonce(n uint64) []byte {
+	b := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(b[4:], n)
+	return b
+}
+
+// Initiator is the client side of a handshake in progress.
+type Initiator struct {
+	s     *symmetricState
+	local Keypair
+	e     *ecdh.PrivateKey
+}
+
+// Starts a handshake from `local` to the proxy whose static public key is `remote`. Returns the
+// message to send in a HandshakeInit; it carries `now` so the proxy can refuse replayed ones.
+func StartHandshake(local Keypair, remote [KeySize]byte, now time.Time) (*Initiator, []byte, error) {
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := newSymmetricState(remote[:])
+
+	// -> e, es, s, ss
+	msg := make([]byte, 0, initSize)
+	msg = append(msg, e.PublicKey().Bytes()...)
+	s.mixHash(e.PublicKey().Bytes())
+	s.mixDH(e.Bytes(), remote[:])
+	msg = append(msg, s.encryptAndHash(local.Public[:])...)
+	s.mixDH(local.Private[:], remote[:])
+	msg = append(msg, s.encryptAndHash(binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano())))...)
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	return &Initiator{s: s, local: local, e: e}, msg, nil
+}
+
+// Completes the handshake with the proxy's answer and returns the sender for the session,
+// tagging every packet with the proxy's `index`. A failed attempt leaves the initiator untouched,
+// so the answer to a retransmitted HandshakeInit can still be tried.
+func (i *Initiator) Finish(index uint32, msg []byte) (*Sender, error) {
+	if len(msg) != responseSize {
+		return nil, ErrHandshake
+	}
+	state := *i.s
+	s := &state
+
+	// <- e, ee, se
+	re := msg[:KeySize]
+	s.mixHash(re)
+	s.mixDH(i.e.Bytes(), re)
+	s.mixDH(i.local.Private[:], re)
+	if _, err := s.decryptAndHash(msg[KeySize:]); err != nil {
+		return nil, err
+	}
+	if s.err != nil {
+		return nil, ErrHandshake
+	}
+	return newSender(index, s.split()), nil
+}
+
+// Accepted is the proxy side of a completed handshake.
+type Accepted struct {
+	Peer     [KeySize]byte // the client's static public key, to be checked against the authorized ones
+	Started  time.Time     // when the client started the handshake
+	Reply    []byte        // message to send back in a HandshakeResponse
+	Receiver *Receiver
+}
+
+// Answers the handshake message `msg` of a HandshakeInit with the static keypair `local`.
+func Accept(local Keypair, msg []byte) (*Accepted, error) {
+	if len(msg) != initSize {
+		return nil, ErrHandshake
+	}
+	s := newSymmetricState(local.Public[:])
+
+	// -> e, es, s, ss
+	re := msg[:KeySize]
+	s.mixHash(re)
+	s.mixDH(local.Private[:], re)
+	rs, err := s.decryptAndHash(msg[KeySize : 2*KeySize+chacha20poly1305.Overhead])
+	if err != nil {
+		return nil, err
+	}
+	s.mixDH(local.Private[:], rs)
+	ts, err := s.decryptAndHash(msg[2*KeySize+chacha20poly1305.Overhead:])
+	if err != nil {
+		return nil, err
+	}
+
+	// <- e, ee, se
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 0, responseSize)
+	reply = append(reply, e.PublicKey().Bytes()...)
+	s.mixHash(e.PublicKey().Bytes())
+	s.mixDH(e.Bytes(), re)
+	s.mixDH(e.Bytes(), rs)
+	reply = append(reply, s.encryptAndHash(nil)...)
+	if s.err != nil {
+		return nil, ErrHandshake
+	}
+
+	accepted := &Accepted{
+		Started:  time.Unix(0, int64(binary.BigEndian.Uint64(ts))),
+		Reply:    reply,
+		Receiver: newReceiver(s.split()),
+	}
+	copy(accepted.Peer[:], rs)
+	return accepted, nil
+}
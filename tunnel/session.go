@@ -0,0 +1,115 @@
+package tunnel
+
+import (
+	"crypto/cipher"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// windowSize is how far behind the newest counter a packet may arrive and still be accepted.
+// Copies sent over slower paths arrive late, so it is generous.
+const windowSize = 1024
+
+// Sender seals the client's packets for one proxy. It is safe for concurrent use.
+type Sender struct {
+	index   uint32
+	aead    cipher.AEAD
+	counter atomic.Uint64
+}
+
+func newSender(index uint32, key []byte) *Sender {
+	aead, _ := chacha20poly1305.New(key)
+	return &Sender{index: index, aead: aead}
+}
+
+// Wraps `plaintext` in a TunnelData under the next counter.
+func (s *Sender) Seal(plaintext []byte) []byte {
+	counter := s.counter.Add(1) - 1
+	b := protocol.TunnelDataHeader(s.index, counter, len(plaintext)+s.aead.Overhead())
+	return s.aead.Seal(b, nonce(counter), plaintext, b)
+}
+
+// Receiver opens the packets of one client. It is safe for concurrent use.
+type Receiver struct {
+	aead   cipher.AEAD
+	window ReplayWindow
+}
+
+func newReceiver(key []byte) *Receiver {
+	aead, _ := chacha20poly1305.New(key)
+	return &Receiver{aead: aead}
+}
+
+// Decrypts the TunnelData `b`. Packets that were already accepted, or whose counter fell out of
+// the replay window, are refused with ErrReplay.
+func (r *Receiver) Open(b []byte) ([]byte, error) {
+	data, err := protocol.DecodeTunnelData(b)
+	if err != nil {
+		return nil, err
+	}
+	if !r.window.Fresh(data.Counter) {
+		return nil, ErrReplay
+	}
+	header := b[:len(b)-len(data.Ciphertext)]
+	plaintext, err := r.aead.Open(nil, nonce(data.Counter), data.Ciphertext, header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	if !r.window.Accept(data.Counter) {
+		return nil, ErrReplay // a concurrent copy won the race
+	}
+	return plaintext, nil
+}
+
+// ReplayWindow remembers the counters accepted recently, to refuse packets replayed by someone else.
+// Counters more than 1024 behind the newest one are refused too. The zero value is an empty window;
+// it is safe for concurrent use.
+type ReplayWindow struct {
+	mu     sync.Mutex
+	newest uint64
+	seen   [windowSize/64 + 1]uint64 // ring of bitmaps, one word per 64 counters; the extra word keeps the oldest counters apart from the newest
+	any    bool
+}
+
+// Reports whether `counter` could be accepted, without recording it. Used to skip decrypting replays.
+func (w *ReplayWindow) Fresh(counter uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.check(counter)
+}
+
+func (w *ReplayWindow) check(counter uint64) bool {
+	if !w.any || counter > w.newest {
+		return true
+	}
+	if w.newest-counter >= windowSize {
+		return false
+	}
+	return w.seen[counter/64%uint64(len(w.seen))]&(1<<(counter%64)) == 0
+}
+
+// Records `counter` as accepted, sliding the window forward if it is the newest. Returns false,
+// recording nothing, if it was already accepted or fell out of the window.
+func (w *ReplayWindow) Accept(counter uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.check(counter) {
+		return false
+	}
+	if !w.any || counter > w.newest {
+		// clear the words between the old newest and the new one, they're now reused for newer counters
+		from := w.newest/64 + 1
+		if !w.any {
+			from = 0
+		}
+		for word := from; word <= counter/64 && word < from+uint64(len(w.seen)); word++ {
+			w.seen[word%uint64(len(w.seen))] = 0
+		}
+		w.newest, w.any = counter, true
+	}
+	w.seen[counter/64%uint64(len(w.seen))] |= 1 << (counter % 64)
+	return true
+}
@@ -0,0 +1,192 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+func handshake(t *testing.T) (*Sender, *Receiver, Keypair) {
+	t.Helper()
+	client, err := GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	initiator, init, err := StartHandshake(client, proxy.Public, now)
+	if err != nil {
+		t.Fatalf("StartHandshake: %v", err)
+	}
+	accepted, err := Accept(proxy, init)
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if accepted.Peer != client.Public || !accepted.Started.Equal(time.Unix(0, now.UnixNano())) {
+		t.Errorf("accepted peer %x started at %v; want %x at %v", accepted.Peer, accepted.Started, client.Public, now)
+	}
+	sender, err := initiator.Finish(7, accepted.Reply)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	return sender, accepted.Receiver, proxy
+}
+
+func TestHandshakeAndTransport(t *testing.T) {
+	sender, receiver, _ := handshake(t)
+
+	b := sender.Seal([]byte("move"))
+	if len(b) != len("move")+protocol.TunnelOverhead {
+		t.Errorf("len = %d; want %d", len(b), len("move")+protocol.TunnelOverhead)
+	}
+	if data, err := protocol.DecodeTunnelData(b); err != nil || data.Index != 7 {
+		t.Errorf("DecodeTunnelData = %+v, %v; want index 7", data, err)
+	}
+
+	got, err := receiver.Open(b)
+	if err != nil || string(got) != "move" {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+	if _, err := receiver.Open(b); !errors.Is(err, ErrReplay) {
+		t.Errorf("replay: err = %v; want %v", err, ErrReplay)
+	}
+
+	tampered := sender.Seal([]byte("move"))
+	tampered[len(tampered)-1] ^= 1
+	if _, err := receiver.Open(tampered); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("tampered: err = %v; want %v", err, ErrDecrypt)
+	}
+}
+
+func TestHandshakeWrongProxyKey(t *testing.T) {
+	client, _ := GenerateKeypair()
+	proxy, _ := GenerateKeypair()
+	impostor, _ := GenerateKeypair()
+
+	_, init, err := StartHandshake(client, proxy.Public, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Accept(impostor, init); !errors.Is(err, ErrHandshake) {
+		t.Errorf("Accept with another key: err = %v; want %v", err, ErrHandshake)
+	}
+	if _, err := Accept(proxy, init[:len(init)-1]); !errors.Is(err, ErrHandshake) {
+		t.Errorf("Accept of a truncated message: err = %v; want %v", err, ErrHandshake)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	sender, receiver, _ := handshake(t)
+
+	packets := make([][]byte, 3*windowSize)
+	for i := range packets {
+		packets[i] = sender.Seal([]byte{byte(i)})
+	}
+
+	// out of order within the window is fine, as happens when paths have different latencies
+	for _, i := range []int{5, 3, 4, 0, windowSize, 1, 2} {
+		if _, err := receiver.Open(packets[i]); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+	}
+	for _, i := range []int{3, windowSize} {
+		if _, err := receiver.Open(packets[i]); !errors.Is(err, ErrReplay) {
+			t.Errorf("packet %d again: err = %v; want %v", i, err, ErrReplay)
+		}
+	}
+
+	// jump ahead: everything a window behind is refused, even if never seen
+	if _, err := receiver.Open(packets[3*windowSize-1]); err != nil {
+		t.Fatalf("newest packet: %v", err)
+	}
+	if _, err := receiver.Open(packets[windowSize+1]); !errors.Is(err, ErrReplay) {
+		t.Errorf("packet older than the window: err = %v; want %v", err, ErrReplay)
+	}
+	if _, err := receiver.Open(packets[3*windowSize-2]); err != nil {
+		t.Errorf("packet right behind the newest: %v", err)
+	}
+}
+
+// Each pair of bytes moves the counter of the next packet ahead of the newest one sent, or behind
+// it when the high bit is set. The receiver must accept every counter exactly once while it is
+// within the window, like a set of the accepted counters does.
+func FuzzReplayWindow(f *testing.F) {
+	f.Add([]byte{0, 5, 0x80, 2, 0x80, 1, 0x80, 2, 0x04, 0x00, 0x84, 0x00, 0x83, 0xff})
+	f.Add([]byte{0, 0, 0, 0, 0, 63, 0, 1, 0x80, 64, 0x80, 65})
+	f.Fuzz(func(t *testing.T, steps []byte) {
+		key := make([]byte, KeySize)
+		sender, receiver := newSender(7, key), newReceiver(key)
+		accepted := make(map[uint64]bool)
+		var newest uint64
+		for i := 0; i+1 < min(len(steps), 1024); i += 2 {
+			step := uint64(steps[i])<<8 | uint64(steps[i+1])
+			counter := newest + step
+			if step&0x8000 != 0 {
+				counter = newest - min(newest, step&0x7fff)
+			}
+			want := !accepted[counter] && (len(accepted) == 0 || counter > newest || newest-counter < windowSize)
+
+			sender.counter.Store(counter)
+			_, err := receiver.Open(sender.Seal([]byte("move")))
+			if got := err == nil; got != want {
+				t.Fatalf("counter %d with newest %d: err = %v; want accepted %v", counter, newest, err, want)
+			}
+			if want {
+				accepted[counter] = true
+				newest = max(newest, counter)
+			}
+		}
+	})
+}
+
+func FuzzAccept(f *testing.F) {
+	client, _ := GenerateKeypair()
+	proxy, _ := GenerateKeypair()
+	_, init, err := StartHandshake(client, proxy.Public, time.Unix(0, 1718000000123456789))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(init)
+	f.Add(init[:KeySize])
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		accepted, err := Accept(proxy, msg)
+		if err != nil {
+			return
+		}
+		// forging a handshake takes the client's key, so only the seed gets here
+		if accepted.Peer != client.Public || len(accepted.Reply) != responseSize {
+			t.Fatalf("accepted %x from %x", msg, accepted.Peer)
+		}
+	})
+}
+
+func TestKeypairFile(t *testing.T) {
+	kp, err := GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "proxy.key")
+	if err := SaveKeypair(path, kp); err != nil {
+		t.Fatalf("SaveKeypair: %v", err)
+	}
+	if err := SaveKeypair(path, kp); err == nil {
+		t.Errorf("SaveKeypair overwrote an existing key")
+	}
+	if got, err := LoadKeypair(path); err != nil || got != kp {
+		t.Errorf("LoadKeypair = %x, %v; want %x", got.Public, err, kp.Public)
+	}
+	if pub, err := ParseKey(hex.EncodeToString(kp.Public[:])); err != nil || pub != kp.Public {
+		t.Errorf("ParseKey = %x, %v", pub, err)
+	}
+	if _, err := ParseKey("abcd"); err == nil {
+		t.Errorf("expected an error for a short key")
+	}
+}
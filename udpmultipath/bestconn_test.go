@@ -3,6 +3,7 @@ package udpmultipath
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 // Helper to pull out just the ping values from a []result.
@@ -143,3 +144,19 @@ func TestGetClosest(t *testing.T) {
 		})
 	}
 }
+
+func TestEstimateUnknownUpstreams(t *testing.T) {
+	legs := func(proxy, upstream int, unknown bool) result {
+		l := pingLegs{proxy: time.Duration(proxy) * time.Millisecond, upstream: time.Duration(upstream) * time.Millisecond, upstreamUnknown: unknown}
+		return result{ping: l.total().Milliseconds(), legs: l}
+	}
+	all := []result{legs(10, 30, false), legs(20, 50, false), legs(5, 0, true), {ping: badPing}}
+
+	estimateUnknownUpstreams(all)
+	if all[2].ping != 55 || all[2].legs.upstream != 50*time.Millisecond {
+		t.Errorf("unknown upstream estimated as %v (ping %d); want the worst known, 50ms", all[2].legs.upstream, all[2].ping)
+	}
+	if all[0].ping != 40 || all[1].ping != 70 || all[3].ping != badPing {
+		t.Errorf("known pings changed: %+v", all)
+	}
+}
@@ -0,0 +1,65 @@
+package udpmultipath
+
+import (
+	"net"
+	"time"
+)
+
+// Clock is the time MultipathProxy runs on: its tickers, ping timings, deadlines and timestamps. Deadlines
+// are set on the paths' connections in its time, so a fake clock goes with connections that follow it,
+// see PathDialer and package netsim.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is a ticker of a Clock.
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// PathDialer opens the connections of the paths, from the IP of a local interface to a proxy address.
+type PathDialer interface {
+	DialPath(local net.IP, remote string) (net.Conn, error)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTicker struct{ *time.Ticker }
+
+func (t systemTicker) Chan() <-chan time.Time { return t.C }
+
+// Dials UDP with a net.Dialer bound to the local IP. Its behaviour is not tested for IPv6.
+type systemDialer struct{}
+
+func (systemDialer) DialPath(local net.IP, remote string) (net.Conn, error) {
+	d := net.Dialer{LocalAddr: &net.UDPAddr{IP: local}}
+	return d.Dial("udp", remote)
+}
+
+func (cfg *Config) clock() Clock {
+	if cfg.Clock == nil {
+		return systemClock{}
+	}
+	return cfg.Clock
+}
+
+func (cfg *Config) dialer() PathDialer {
+	if cfg.Dialer == nil {
+		return systemDialer{}
+	}
+	return cfg.Dialer
+}
+
+// Returns how long a path stays down before it is probed.
+func (cfg *Config) probeDelay() time.Duration {
+	if cfg.ProbeDelay == 0 {
+		return cfg.ProbeInterval
+	}
+	return cfg.ProbeDelay
+}
@@ -1,8 +1,13 @@
 package udpmultipath
 
 import (
-	"fmt"
+	"log/slog"
 	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+	"github.com/SergioFloresCorrea/lol-multipath/metrics"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
 )
 
 const (
@@ -10,32 +15,59 @@ const (
 )
 
 type Config struct {
-	ServerMap       map[string]string // maps league servers to DynamoDB endpoints for ping calculation
-	Server          string
-	Rand            string        // random hex number for ping HTTP queries
-	ThresholdFactor float64       // drop connections whose ping > factor×lowest ping
-	UpdateInterval  time.Duration // how often to refresh ping metrics
-	Timeout         time.Duration // how long to wait for a ping response
-	ProbeInterval   time.Duration // how long to wait for probing down connections
-	CleanupInterval time.Duration // how long to wait before cleaning the packet cache involved in the deduplicating package process
-	MaxConnections  int           // maximum number of multipath connections
-	Dynamic         bool          // enable periodic proxy reselection
+	Server          string            // league server being played on, reported to the proxies when pinging
+	ThresholdFactor float64           // drop connections whose ping > factor×lowest ping
+	UpdateInterval  time.Duration     // how often to refresh ping metrics
+	Timeout         time.Duration     // how long to wait for a ping response
+	ProbeInterval   time.Duration     // how long to wait for probing down connections
+	ProbeDelay      time.Duration     // how long a connection stays down before it is probed; 0 means ProbeInterval
+	MaxConnections  int               // maximum number of multipath connections
+	Dynamic         bool              // enable periodic proxy reselection
+	Key             *keys.Key         // pre-shared key every packet to the proxies is authenticated with; nil sends them as is
+	Tunnel          *TunnelConfig     // encrypted transport to the proxies; proxies it can't be opened with fall back to Key
+	Metrics         *metrics.Registry // registry the path and selection metrics are recorded on; nil records none
+	Controller      *Controller       // reports the state of MultipathProxy and steers it; nil uses a private one
+	Logger          *slog.Logger      // nil means slog.Default()
+	TraceDir        string            // directory a trace of every session is recorded to, see package trace; empty records none
+	CaptureDir      string            // directory a pcapng capture of every session is written to, see package capture; empty writes none
+	Clock           Clock             // nil means the system clock
+	Dialer          PathDialer        // opens the paths' connections; nil dials UDP from the local IPs
+}
+
+// TunnelConfig configures the encrypted transport, see package tunnel.
+type TunnelConfig struct {
+	Key       tunnel.Keypair                  // the client's static keypair
+	ProxyKeys map[string][tunnel.KeySize]byte // static public key of each proxy, by listen address as given
+}
+
+func (cfg *Config) logger() *slog.Logger {
+	if cfg.Logger == nil {
+		return slog.Default()
+	}
+	return cfg.Logger
+}
 
+// Wraps a packet for the proxy of `uc`: encrypted if there's a tunnel to it, authenticated with cfg.Key
+// if there's one, as is otherwise. Sealed packets carry the time they were sealed at or a counter,
+// so they must be sealed right before being sent.
+func (cfg *Config) sealFor(uc *UdpConnection, b []byte) []byte {
+	if uc.sender != nil {
+		return uc.sender.Seal(b)
+	}
+	if uc.auth == nil {
+		return b
+	}
+	return uc.auth.Seal(cfg.clock().Now(), b)
 }
 
-func (cfg *Config) GenerateServerMap() {
-	if cfg.ServerMap == nil {
-		cfg.ServerMap = map[string]string{
-			"NA":   fmt.Sprintf("https://dynamodb.us-east-2.amazonaws.com/ping?x=%s", cfg.Rand),
-			"LAN":  fmt.Sprintf("https://dynamodb.us-east-1.amazonaws.com/ping?x=%s", cfg.Rand),
-			"LAS":  fmt.Sprintf("https://dynamodb.sa-east-1.amazonaws.com/ping?x=%s", cfg.Rand),
-			"EUW":  fmt.Sprintf("https://dynamodb.eu-central-1.amazonaws.com/ping?x=%s", cfg.Rand),
-			"OCE":  fmt.Sprintf("https://dynamodb.ap-southeast-2.amazonaws.com/ping?x=%s", cfg.Rand),
-			"EUNE": fmt.Sprintf("https://dynamodb.eu-central-1.amazonaws.com/ping?x=%s", cfg.Rand),
-			"RU":   fmt.Sprintf("https://dynamodb.eu-north-1.amazonaws.com/ping?x=%s", cfg.Rand),
-			"TR":   fmt.Sprintf("https://dynamodb.eu-south-1.amazonaws.com/ping?x=%s", cfg.Rand),
-			"JP":   fmt.Sprintf("https://dynamodb.ap-northeast-1.amazonaws.com/ping?x=%s", cfg.Rand),
-			"KR":   fmt.Sprintf("https://dynamodb.ap-northeast-2.amazonaws.com/ping?x=%s", cfg.Rand),
-		}
+// Shares one Authenticator of cfg.Key among every data and ping connection, so every proxy sees the
+// counters of the key grow. Does nothing without a key.
+func (cfg *Config) authenticate(connSet ConnectionPort) {
+	if cfg.Key == nil {
+		return
+	}
+	auth := protocol.NewAuthenticator(cfg.Key.Secret, cfg.clock().Now())
+	for _, uc := range append(connSet.UDPConns, connSet.PingConns...) {
+		uc.auth = auth
 	}
 }
@@ -1,68 +1,55 @@
 package udpmultipath
 
 import (
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
 )
 
 type UdpConnection struct {
-	mu   sync.Mutex
-	conn net.Conn
+	mu      sync.Mutex
+	conn    net.Conn
+	path    int                     // index of the data connection of the path in ConnectionPort.UDPConns
+	proxy   string                  // proxy listen address the connection belongs to, as given
+	sender  *tunnel.Sender          // encrypted tunnel to the proxy, nil if there's none
+	auth    *protocol.Authenticator // seals packets with Config.Key when there's no tunnel, nil if there's no key
+	metrics *pathMetrics            // nil if the client has no metrics registry
+	packets atomic.Uint64           // game packets written
+}
+
+// Returns the attributes identifying the path of `uc` in log records.
+func (uc *UdpConnection) attrs() slog.Attr {
+	return slog.Group("", logging.KeyPath, uc.path, logging.KeyInterface, uc.conn.LocalAddr(), logging.KeyProxy, uc.proxy)
 }
 
 type result struct {
 	conn     *UdpConnection
 	pingConn *UdpConnection
 	ping     int64
+	legs     pingLegs
+	err      error // of the ping, which then counts as badPing
 }
 
-type ConnectionPort struct {
-	UDPConns  []*UdpConnection
-	PingConns []*UdpConnection
-}
-
-type ProxyConfig struct {
-	RemoteIP   net.IP
-	RemotePort string
-	ClientIP   string
-	ClientPort int
+// Breakdown of a ping measurement into the client↔proxy and proxy↔game-server legs.
+type pingLegs struct {
+	proxy           time.Duration
+	upstream        time.Duration
+	upstreamUnknown bool // the proxy has no estimate yet, see estimateUnknownUpstreams
 }
 
-type SeenHashTracker struct {
-	mu              sync.Mutex
-	SeenHash        map[uint64]time.Time
-	cleanupInterval time.Duration
+func (l pingLegs) total() time.Duration {
+	return l.proxy + l.upstream
 }
 
-// Creates blank new tracker
-func (cfg *Config) newTracker() *SeenHashTracker {
-	return &SeenHashTracker{SeenHash: make(map[uint64]time.Time), cleanupInterval: cfg.CleanupInterval}
-}
-
-// Cleans entries in the hash tracker older than `cleanupInterval`
-func (tracker *SeenHashTracker) cleanupHash() {
-	tracker.mu.Lock()
-	defer tracker.mu.Unlock()
-	now := time.Now()
-	for hash, ts := range tracker.SeenHash {
-		if now.Sub(ts) >= tracker.cleanupInterval {
-			delete(tracker.SeenHash, hash)
-		}
-	}
-}
-
-// Checks if a hash is was already saved in the hash tracker.
-// If it is, returns true. If it isn't, saves the timestamp with the hash
-// as a key and returns false.
-func (tracker *SeenHashTracker) isHashDuplicate(hash uint64) bool {
-	tracker.mu.Lock()
-	defer tracker.mu.Unlock()
-	if _, exists := tracker.SeenHash[hash]; exists {
-		return true
-	}
-	tracker.SeenHash[hash] = time.Now()
-	return false
+type ConnectionPort struct {
+	UDPConns  []*UdpConnection
+	PingConns []*UdpConnection
 }
 
 // Checks if every connection to a proxy has a corresponding connection
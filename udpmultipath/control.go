@@ -0,0 +1,138 @@
+package udpmultipath
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+const (
+	controlAttempts = 3 // control messages are retransmitted this many times before giving up on a path
+
+	// features requested from every proxy
+	wantedFeatures = protocol.FeatureDedupe | protocol.FeaturePingV2 | protocol.FeatureMultiSession
+)
+
+// GameSession is what the proxies need to know to relay a game. It is delivered to every proxy
+// over the control channel, from each path's own socket.
+type GameSession struct {
+	ID         string       // unique per game
+	GameAddr   *net.UDPAddr // Riot's game server
+	ClientAddr *net.UDPAddr // where the game client listens for the server's packets
+	ClientIPs  []net.IP     // IPs of the local interfaces the paths send from
+}
+
+func (s GameSession) open(nonce uint64) protocol.SessionOpen {
+	return protocol.SessionOpen{
+		Nonce:      nonce,
+		SessionID:  s.ID,
+		GameAddr:   s.GameAddr,
+		ClientAddr: s.ClientAddr,
+		ClientIPs:  s.ClientIPs,
+		Features:   wantedFeatures,
+	}
+}
+
+// Opens `session` over every connection, so each proxy binds each path to it. Returns the connections
+// whose proxy didn't acknowledge the session; it fails only if no connection did. A proxy only takes a
+// session whose game client listens on the IP of one of its paths, so the paths from that IP go first.
+func (cfg *Config) openSessions(session GameSession, conns []*UdpConnection) ([]*UdpConnection, error) {
+	var first, rest []*UdpConnection
+	for _, uc := range conns {
+		if local, ok := uc.conn.LocalAddr().(*net.UDPAddr); ok && session.ClientAddr != nil && local.IP.Equal(session.ClientAddr.IP) {
+			first = append(first, uc)
+		} else {
+			rest = append(rest, uc)
+		}
+	}
+	failed := append(cfg.openSessionsOn(session, first), cfg.openSessionsOn(session, rest)...)
+	if len(conns) > 0 && len(failed) == len(conns) {
+		return failed, fmt.Errorf("no proxy acknowledged session %s", session.ID)
+	}
+	return failed, nil
+}
+
+// Opens `session` over every connection at once. Returns the ones whose proxy didn't acknowledge it.
+func (cfg *Config) openSessionsOn(session GameSession, conns []*UdpConnection) []*UdpConnection {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []*UdpConnection
+
+	for _, uc := range conns {
+		wg.Add(1)
+		go func(uc *UdpConnection) {
+			defer wg.Done()
+			ack, err := cfg.openSession(session, uc)
+			if err != nil {
+				cfg.logger().Warn("couldn't open the session", logging.KeySession, session.ID, uc.attrs(), logging.KeyError, err)
+				mu.Lock()
+				failed = append(failed, uc)
+				mu.Unlock()
+				return
+			}
+			if missing := wantedFeatures &^ ack.Capabilities; missing != 0 {
+				cfg.logger().Warn("proxy lacks features", uc.attrs(), "missing", fmt.Sprintf("%#x", missing))
+			}
+		}(uc)
+	}
+	wg.Wait()
+	return failed
+}
+
+// Opens `session` over `conn` and waits for the proxy to take it.
+func (cfg *Config) openSession(session GameSession, conn *UdpConnection) (protocol.SessionAck, error) {
+	nonce := rand.Uint64()
+	ack, err := cfg.controlExchange(conn, protocol.EncodeSessionOpen(session.open(nonce)), nonce)
+	if err == nil && ack.Status != protocol.StatusOK {
+		err = fmt.Errorf("rejected (status %d): %s", ack.Status, ack.Message)
+	}
+	return ack, err
+}
+
+// Tells every proxy to stop relaying for `session`. Acknowledgements aren't waited for, proxies
+// that miss the message close the session once it's idle.
+func (cfg *Config) closeSessions(session GameSession, conns []*UdpConnection) {
+	msg := protocol.EncodeSessionClose(protocol.SessionClose{Nonce: rand.Uint64(), SessionID: session.ID})
+	for _, uc := range conns {
+		uc.mu.Lock()
+		_, _ = uc.conn.Write(cfg.sealFor(uc, msg))
+		uc.mu.Unlock()
+	}
+}
+
+// Sends a control message on `conn` and waits for the acknowledgement carrying `nonce`. Since either
+// may be lost, the message is sent up to `controlAttempts` times, waiting `cfg.Timeout` each time.
+func (cfg *Config) controlExchange(conn *UdpConnection, msg []byte, nonce uint64) (protocol.SessionAck, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	defer conn.conn.SetDeadline(time.Time{})
+
+	buf := make([]byte, 512)
+	var lastErr error
+	for range controlAttempts {
+		if _, err := conn.conn.Write(cfg.sealFor(conn, msg)); err != nil {
+			return protocol.SessionAck{}, err
+		}
+		if err := conn.conn.SetReadDeadline(cfg.clock().Now().Add(cfg.Timeout)); err != nil {
+			return protocol.SessionAck{}, err
+		}
+		for {
+			n, err := conn.conn.Read(buf)
+			if err != nil {
+				lastErr = err
+				break
+			}
+			ack, err := protocol.DecodeSessionAck(buf[:n])
+			if err != nil || ack.Nonce != nonce {
+				continue // not an answer to this message
+			}
+			return ack, nil
+		}
+	}
+	return protocol.SessionAck{}, fmt.Errorf("no acknowledgement after %d attempts: %w", controlAttempts, lastErr)
+}
@@ -0,0 +1,103 @@
+package udpmultipath
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+// Starts a fake proxy relay listener that acknowledges control messages with `status`
+// and forwards every message it receives on `got`.
+func startFakeRelay(t *testing.T, status uint8) (*net.UDPConn, <-chan []byte) {
+	t.Helper()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	got := make(chan []byte, 16)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			msg := append([]byte(nil), buf[:n]...)
+			select {
+			case got <- msg:
+			default:
+			}
+			open, err := protocol.DecodeSessionOpen(msg)
+			if err != nil {
+				continue
+			}
+			// an unrelated ack first, it must be skipped
+			_, _ = pc.WriteToUDP(protocol.EncodeSessionAck(protocol.SessionAck{Nonce: open.Nonce + 1}), addr)
+			ack := protocol.SessionAck{Nonce: open.Nonce, SessionID: open.SessionID, Status: status, Capabilities: protocol.FeatureDedupe}
+			_, _ = pc.WriteToUDP(protocol.EncodeSessionAck(ack), addr)
+		}
+	}()
+	return pc, got
+}
+
+func dialConn(t *testing.T, addr net.Addr) *UdpConnection {
+	t.Helper()
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &UdpConnection{conn: conn}
+}
+
+func TestOpenSessions(t *testing.T) {
+	okRelay, okGot := startFakeRelay(t, protocol.StatusOK)
+	fullRelay, _ := startFakeRelay(t, protocol.StatusTooManySessions)
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer silent.Close()
+
+	ok := dialConn(t, okRelay.LocalAddr())
+	full := dialConn(t, fullRelay.LocalAddr())
+	lost := dialConn(t, silent.LocalAddr())
+
+	session := GameSession{
+		ID:         "abc",
+		GameAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 5100},
+		ClientAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 50000},
+	}
+	cfg := Config{Timeout: 50 * time.Millisecond}
+
+	failed, err := cfg.openSessions(session, []*UdpConnection{ok, full, lost})
+	if err != nil {
+		t.Fatalf("openSessions: %v", err)
+	}
+	if len(failed) != 2 || (failed[0] != full && failed[1] != full) || (failed[0] != lost && failed[1] != lost) {
+		t.Errorf("failed = %v; want the rejected and the silent connections", failed)
+	}
+
+	open, err := protocol.DecodeSessionOpen(<-okGot)
+	if err != nil || open.SessionID != "abc" || open.GameAddr.Port != 5100 {
+		t.Errorf("relay got %+v, %v", open, err)
+	}
+
+	if _, err := cfg.openSessions(session, []*UdpConnection{full, lost}); err == nil {
+		t.Errorf("expected an error when no proxy acknowledges")
+	}
+
+	cfg.closeSessions(session, []*UdpConnection{ok})
+	select {
+	case msg := <-okGot:
+		if closed, err := protocol.DecodeSessionClose(msg); err != nil || closed.SessionID != "abc" {
+			t.Errorf("relay got %+v, %v; want a close for abc", closed, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("the relay never got the close")
+	}
+}
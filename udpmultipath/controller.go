@@ -0,0 +1,469 @@
+package udpmultipath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PathState is what a path is currently used for.
+type PathState string
+
+const (
+	PathSelected PathState = "selected" // carries the game traffic
+	PathStandby  PathState = "standby"  // up, but not among the paths carrying the traffic
+	PathDown     PathState = "down"     // its last write failed or its proxy didn't take the session, excluded until a probe gets through
+	PathClosed   PathState = "closed"   // trimmed by the first selection for being too slow, it won't be used again
+)
+
+// PathStatus is the JSON view of a candidate path.
+type PathStatus struct {
+	ID              int        `json:"id"`
+	Local           string     `json:"local"` // IP of the local interface
+	Proxy           string     `json:"proxy"` // proxy listen address
+	State           PathState  `json:"state"`
+	Pinned          bool       `json:"pinned"`
+	Banned          bool       `json:"banned"`
+	Encrypted       bool       `json:"encrypted"`
+	PingMs          int64      `json:"ping_ms"` // expected client↔server ping through the path, 0 before the first measurement
+	ProxyMs         float64    `json:"proxy_ms"`
+	UpstreamMs      float64    `json:"upstream_ms"`
+	UpstreamUnknown bool       `json:"upstream_unknown"` // the proxy had no estimate, UpstreamMs is the worst the other proxies reported
+	PingFailed      bool       `json:"ping_failed"`
+	MeasuredAt      time.Time  `json:"measured_at,omitzero"`
+	JitterMs        float64    `json:"jitter_ms"`     // smoothed variation between consecutive client↔proxy RTTs
+	LossPct         float64    `json:"loss_pct"`      // of the recent pings
+	RecentRTTMs     []float64  `json:"recent_rtt_ms"` // client↔proxy RTT of the last pings, oldest first, -1 for lost ones
+	PacketsSent     uint64     `json:"packets_sent"`
+	DownSince       *time.Time `json:"down_since,omitempty"`
+}
+
+// SessionStatus is the JSON view of the game session.
+type SessionStatus struct {
+	ID         string `json:"id"`
+	GameAddr   string `json:"game_addr"` // Riot's game server, as discovered
+	ClientAddr string `json:"client_addr"`
+}
+
+// Status is what the control /status endpoint returns.
+type Status struct {
+	Running        bool           `json:"running"`
+	Session        *SessionStatus `json:"session"` // null until MultipathProxy has opened it
+	MaxConnections int            `json:"max_connections"`
+	Paused         bool           `json:"paused"` // only the best path carries the traffic
+	Paths          []PathStatus   `json:"paths"`
+}
+
+// State of a candidate path, guarded by the Controller's mu.
+type pathState struct {
+	id        int
+	conn      *UdpConnection
+	local     string
+	pinned    bool
+	banned    bool
+	closed    bool
+	downSince time.Time // zero while up
+	last      result
+	measured  time.Time
+	recent    []float64     // see PathStatus.RecentRTTMs
+	samples   []Sample      // see PathHistory
+	lastRTT   time.Duration // RTT of the last answered ping
+	jitter    time.Duration
+}
+
+const recentPings = 30 // pings kept per path in PathStatus.RecentRTTMs
+
+// Controller keeps the state of a running MultipathProxy, reports it and lets it be steered: which paths
+// carry the traffic, how many and when they are reselected. Use one per MultipathProxy, see Config.Controller.
+type Controller struct {
+	mu             sync.Mutex
+	running        bool
+	session        GameSession
+	paths          []*pathState
+	byConn         map[*UdpConnection]*pathState
+	ranked         []*UdpConnection // candidates by ascending ping, as of the last selection
+	maxConnections int
+	paused         bool
+	reselect       chan struct{}
+	events         []Event
+}
+
+// Creates a controller, MultipathProxy fills it in once it has set up its paths.
+func NewController() *Controller {
+	return &Controller{byConn: make(map[*UdpConnection]*pathState), reselect: make(chan struct{}, 1)}
+}
+
+var (
+	errUnknownPath = errors.New("unknown path")
+	errClosedPath  = errors.New("path is closed")
+)
+
+// Registers the paths of `connSet` and the session they carry.
+func (c *Controller) start(session GameSession, connSet ConnectionPort, maxConnections int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = true
+	c.session = session
+	if c.maxConnections == 0 {
+		c.maxConnections = maxConnections
+	}
+	for i, uc := range connSet.UDPConns {
+		local, _, _ := net.SplitHostPort(uc.conn.LocalAddr().String())
+		p := &pathState{id: i, conn: uc, local: local}
+		c.paths = append(c.paths, p)
+		c.byConn[uc] = p
+	}
+}
+
+// Marks the controller as no longer running; it keeps reporting the last state.
+func (c *Controller) stop() {
+	c.mu.Lock()
+	c.running = false
+	c.mu.Unlock()
+}
+
+// Records the measurements of a selection round.
+func (c *Controller) measured(all []result, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range all {
+		p, ok := c.byConn[r.conn]
+		if !ok {
+			continue
+		}
+		p.last = r
+		p.measured = at
+		p.sample(r, at)
+		if r.ping == badPing {
+			p.recent = appendCapped(p.recent, -1, recentPings)
+			continue
+		}
+		rtt := r.legs.proxy
+		p.recent = appendCapped(p.recent, float64(rtt)/float64(time.Millisecond), recentPings)
+		if p.lastRTT != 0 {
+			d := rtt - p.lastRTT
+			if d < 0 {
+				d = -d
+			}
+			p.jitter += (d - p.jitter) / 16
+		}
+		p.lastRTT = rtt
+	}
+}
+
+// Records the paths the first selection trimmed and closed.
+func (c *Controller) closed(conns []*UdpConnection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, uc := range conns {
+		if p, ok := c.byConn[uc]; ok {
+			p.closed = true
+		}
+	}
+}
+
+// Replaces the candidates by ascending ping at `at`; returns false if they didn't change.
+func (c *Controller) setRanked(conns []*UdpConnection, at time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sameConnections(c.ranked, conns) {
+		return false
+	}
+	if c.ranked == nil {
+		c.eventLocked(EventSelected, -1, at)
+	} else {
+		c.eventLocked(EventReselected, -1, at)
+	}
+	c.ranked = conns
+	return true
+}
+
+// Appends to buf[:0] the paths that carry the next packet: pinned candidates first, then the others
+// by ascending ping, skipping banned and down ones, up to the max connections (one while paused).
+func (c *Controller) active(buf []*UdpConnection) []*UdpConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.activeLocked(buf[:0])
+}
+
+func (c *Controller) activeLocked(buf []*UdpConnection) []*UdpConnection {
+	n := c.maxConnections
+	if c.paused {
+		n = 1
+	}
+	for _, pinned := range []bool{true, false} {
+		for _, uc := range c.ranked {
+			if len(buf) >= n {
+				return buf
+			}
+			p := c.byConn[uc]
+			if p == nil || p.pinned != pinned || p.banned || p.closed || !p.downSince.IsZero() {
+				continue
+			}
+			buf = append(buf, uc)
+		}
+	}
+	return buf
+}
+
+// Marks `uc` as down; returns false if it already was.
+func (c *Controller) markDown(uc *UdpConnection, at time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.byConn[uc]
+	if !ok || !p.downSince.IsZero() {
+		return false
+	}
+	p.downSince = at
+	c.eventLocked(EventDown, p.id, at)
+	return true
+}
+
+// Marks `uc` as up again at `at`.
+func (c *Controller) markUp(uc *UdpConnection, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.byConn[uc]; ok && !p.downSince.IsZero() {
+		p.downSince = time.Time{}
+		c.eventLocked(EventUp, p.id, at)
+	}
+}
+
+// Returns the paths that have been down for at least `d`.
+func (c *Controller) downFor(d time.Duration, now time.Time) []*UdpConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var conns []*UdpConnection
+	for _, p := range c.paths {
+		if !p.closed && !p.downSince.IsZero() && now.Sub(p.downSince) >= d {
+			conns = append(conns, p.conn)
+		}
+	}
+	return conns
+}
+
+// Returns the state of the session and of every candidate path.
+func (c *Controller) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := Status{Running: c.running, MaxConnections: c.maxConnections, Paused: c.paused, Paths: make([]PathStatus, 0, len(c.paths))}
+	if c.session.ID != "" {
+		status.Session = &SessionStatus{ID: c.session.ID, GameAddr: c.session.GameAddr.String(), ClientAddr: c.session.ClientAddr.String()}
+	}
+
+	selected := make(map[*UdpConnection]bool)
+	for _, uc := range c.activeLocked(nil) {
+		selected[uc] = true
+	}
+	for _, p := range c.paths {
+		ps := PathStatus{
+			ID:          p.id,
+			Local:       p.local,
+			Proxy:       p.conn.proxy,
+			State:       PathStandby,
+			Pinned:      p.pinned,
+			Banned:      p.banned,
+			Encrypted:   p.conn.sender != nil,
+			JitterMs:    float64(p.jitter) / float64(time.Millisecond),
+			RecentRTTMs: slices.Clone(p.recent),
+			PacketsSent: p.conn.packets.Load(),
+		}
+		if len(p.recent) > 0 {
+			lost := 0
+			for _, rtt := range p.recent {
+				if rtt < 0 {
+					lost++
+				}
+			}
+			ps.LossPct = 100 * float64(lost) / float64(len(p.recent))
+		}
+		if !p.measured.IsZero() {
+			ps.MeasuredAt = p.measured
+			ps.PingFailed = p.last.ping == badPing
+			if !ps.PingFailed {
+				ps.PingMs = p.last.ping
+				ps.ProxyMs = float64(p.last.legs.proxy) / float64(time.Millisecond)
+				ps.UpstreamMs = float64(p.last.legs.upstream) / float64(time.Millisecond)
+				ps.UpstreamUnknown = p.last.legs.upstreamUnknown
+			}
+		}
+		switch {
+		case p.closed:
+			ps.State = PathClosed
+		case !p.downSince.IsZero():
+			ps.State = PathDown
+			since := p.downSince
+			ps.DownSince = &since
+		case selected[p.conn]:
+			ps.State = PathSelected
+		}
+		status.Paths = append(status.Paths, ps)
+	}
+	return status
+}
+
+// Asks for the paths to be pinged and reselected now, as the dynamic reselection does periodically.
+func (c *Controller) Reselect() {
+	select {
+	case c.reselect <- struct{}{}:
+	default: // one is already pending
+	}
+}
+
+// Pins path `id` or unpins it. Pinned paths carry the traffic before any other, while they are up.
+func (c *Controller) Pin(id int, pinned bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.pathLocked(id)
+	if err != nil {
+		return err
+	}
+	if pinned && p.closed {
+		return errClosedPath
+	}
+	p.pinned = pinned
+	return nil
+}
+
+// Bans path `id` or lifts its ban. Banned paths don't carry any traffic.
+func (c *Controller) Ban(id int, banned bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.pathLocked(id)
+	if err != nil {
+		return err
+	}
+	p.banned = banned
+	return nil
+}
+
+func (c *Controller) pathLocked(id int) (*pathState, error) {
+	if id < 0 || id >= len(c.paths) {
+		return nil, fmt.Errorf("%w %d", errUnknownPath, id)
+	}
+	return c.paths[id], nil
+}
+
+// Changes how many paths carry the traffic at once.
+func (c *Controller) SetMaxConnections(n int) error {
+	if n < 1 {
+		return fmt.Errorf("max connections must be at least 1, got %d", n)
+	}
+	c.mu.Lock()
+	c.maxConnections = n
+	c.mu.Unlock()
+	return nil
+}
+
+// Returns how many paths carry the traffic at once.
+func (c *Controller) MaxConnections() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxConnections
+}
+
+// Pauses multipath, sending over the best path only, or resumes it.
+func (c *Controller) Pause(paused bool) {
+	c.mu.Lock()
+	c.paused = paused
+	c.mu.Unlock()
+}
+
+// Returns the handler of the control endpoints. Every action answers with the resulting Status.
+//   - GET /status returns the Status as JSON.
+//   - GET /history returns the History as JSON.
+//   - POST /reselect pings every path and reselects them now.
+//   - POST /pause and /resume pause and resume multipath.
+//   - POST /max-connections sets the max connections to the "max_connections" of the JSON body.
+//   - POST /paths/{id}/pin, /unpin, /ban and /unban pin, unpin, ban and unban a path.
+//
+// POST requests must have the Content-Type application/json, even without a body. Browsers can't send
+// that cross-site without the server's consent, so a web page the player opens can't steer the paths.
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+	writeStatus := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(c.Status())
+	}
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) { writeStatus(w) })
+	mux.HandleFunc("GET /history", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c.History())
+	})
+	mux.HandleFunc("POST /reselect", func(w http.ResponseWriter, r *http.Request) {
+		c.Reselect()
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		c.Pause(true)
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		c.Pause(false)
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /max-connections", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MaxConnections int `json:"max_connections"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := c.SetMaxConnections(body.MaxConnections); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeStatus(w)
+	})
+	actions := map[string]func(id int) error{
+		"pin":   func(id int) error { return c.Pin(id, true) },
+		"unpin": func(id int) error { return c.Pin(id, false) },
+		"ban":   func(id int) error { return c.Ban(id, true) },
+		"unban": func(id int) error { return c.Ban(id, false) },
+	}
+	mux.HandleFunc("POST /paths/{id}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		action, ok := actions[r.PathValue("action")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid path id %q", r.PathValue("id")), http.StatusBadRequest)
+			return
+		}
+		if err := action(id); err != nil {
+			code := http.StatusConflict
+			if errors.Is(err, errUnknownPath) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeStatus(w)
+	})
+	return requireJSON(mux)
+}
+
+// Refuses POST requests whose Content-Type isn't application/json with 415 Unsupported Media Type.
+func requireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				http.Error(w, "the Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
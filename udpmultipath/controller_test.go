@@ -0,0 +1,196 @@
+package udpmultipath
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a controller over three paths ranked a, b, c with max connections 2.
+func startTestController(t *testing.T) (*Controller, []*UdpConnection) {
+	t.Helper()
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+
+	var connSet ConnectionPort
+	for _, proxy := range []string{"a:1", "b:1", "c:1"} {
+		uc := dialConn(t, sink.LocalAddr())
+		uc.proxy = proxy
+		connSet.UDPConns = append(connSet.UDPConns, uc)
+		connSet.PingConns = append(connSet.PingConns, uc)
+	}
+	ctl := NewController()
+	ctl.start(GameSession{
+		ID:         "s",
+		GameAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5100},
+		ClientAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000},
+	}, connSet, 2)
+	ctl.setRanked(connSet.UDPConns, time.Now())
+	ctl.measured([]result{{conn: connSet.UDPConns[0], ping: 30, legs: pingLegs{proxy: 10 * time.Millisecond, upstream: 20 * time.Millisecond}}}, time.Now())
+	return ctl, connSet.UDPConns
+}
+
+func TestControllerActive(t *testing.T) {
+	ctl, conns := startTestController(t)
+	a, b, c := conns[0], conns[1], conns[2]
+
+	steps := []struct {
+		name   string
+		action func()
+		want   []*UdpConnection
+	}{
+		{"ranked", func() {}, []*UdpConnection{a, b}},
+		{"pin c", func() { ctl.Pin(2, true) }, []*UdpConnection{c, a}},
+		{"ban a", func() { ctl.Ban(0, true) }, []*UdpConnection{c, b}},
+		{"c down", func() { ctl.markDown(c, time.Now()) }, []*UdpConnection{b}},
+		{"unban a", func() { ctl.Ban(0, false) }, []*UdpConnection{a, b}},
+		{"pause", func() { ctl.Pause(true) }, []*UdpConnection{a}},
+		{"resume, 3 connections", func() { ctl.Pause(false); ctl.SetMaxConnections(3) }, []*UdpConnection{a, b}},
+		{"c up", func() { ctl.markUp(c, time.Now()) }, []*UdpConnection{c, a, b}},
+	}
+	for _, step := range steps {
+		step.action()
+		if got := ctl.active(nil); !slices.Equal(got, step.want) {
+			t.Errorf("%s: active = %v; want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestControllerHandler(t *testing.T) {
+	ctl, conns := startTestController(t)
+	ctl.markDown(conns[2], time.Now())
+	handler := ctl.Handler()
+
+	do := func(method, path, body string) (int, Status) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == http.MethodPost {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		handler.ServeHTTP(rec, req)
+		var status Status
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return rec.Code, status
+	}
+
+	code, status := do(http.MethodGet, "/status", "")
+	if code != http.StatusOK || !status.Running || status.Session == nil || status.Session.GameAddr != "192.0.2.1:5100" {
+		t.Fatalf("GET /status = %d, %+v", code, status)
+	}
+	var states []PathState
+	for _, p := range status.Paths {
+		states = append(states, p.State)
+	}
+	if want := []PathState{PathSelected, PathSelected, PathDown}; !slices.Equal(states, want) {
+		t.Errorf("states = %v; want %v", states, want)
+	}
+	if p := status.Paths[0]; p.PingMs != 30 || p.ProxyMs != 10 || p.UpstreamMs != 20 || p.MeasuredAt.IsZero() {
+		t.Errorf("path 0 = %+v; want its measurement", p)
+	}
+	if status.Paths[2].DownSince == nil {
+		t.Errorf("path 2 has no down_since")
+	}
+
+	ctl.measured([]result{{conn: conns[0], ping: badPing}}, time.Now())
+	ctl.measured([]result{{conn: conns[0], ping: 34, legs: pingLegs{proxy: 14 * time.Millisecond, upstream: 20 * time.Millisecond}}}, time.Now())
+	_, status = do(http.MethodGet, "/status", "")
+	if p := status.Paths[0]; !slices.Equal(p.RecentRTTMs, []float64{10, -1, 14}) || p.LossPct < 33 || p.LossPct > 34 || p.JitterMs != 0.25 {
+		t.Errorf("path 0 = %+v; want recent [10 -1 14], a third lost and 4ms/16 jitter", p)
+	}
+
+	if code, status = do(http.MethodPost, "/paths/0/ban", ""); code != http.StatusOK || !status.Paths[0].Banned || status.Paths[0].State != PathStandby {
+		t.Errorf("ban = %d, %+v", code, status.Paths[0])
+	}
+	if code, status = do(http.MethodPost, "/max-connections", `{"max_connections": 1}`); code != http.StatusOK || status.MaxConnections != 1 {
+		t.Errorf("max-connections = %d, %d", code, status.MaxConnections)
+	}
+	if code, status = do(http.MethodPost, "/pause", ""); code != http.StatusOK || !status.Paused {
+		t.Errorf("pause = %d, paused %v", code, status.Paused)
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/paths/7/pin", "", http.StatusNotFound},
+		{"/paths/x/pin", "", http.StatusBadRequest},
+		{"/paths/0/frobnicate", "", http.StatusNotFound},
+		{"/max-connections", `{"max_connections": 0}`, http.StatusBadRequest},
+	} {
+		if code, _ := do(http.MethodPost, tc.path, tc.body); code != tc.want {
+			t.Errorf("POST %s = %d; want %d", tc.path, code, tc.want)
+		}
+	}
+
+	do(http.MethodPost, "/reselect", "")
+	select {
+	case <-ctl.reselect:
+	default:
+		t.Errorf("POST /reselect didn't ask for a reselection")
+	}
+
+	// what any web page can send cross-site without a preflight is refused
+	for _, contentType := range []string{"application/x-www-form-urlencoded", "text/plain", ""} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/resume", strings.NewReader("x=1"))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("POST /resume as %q = %d; want %d", contentType, rec.Code, http.StatusUnsupportedMediaType)
+		}
+	}
+	if _, status = do(http.MethodGet, "/status", ""); !status.Paused {
+		t.Errorf("a refused POST /resume resumed multipath")
+	}
+}
+
+func TestControllerHistory(t *testing.T) {
+	ctl, conns := startTestController(t)
+	ctl.measured([]result{{conn: conns[0], ping: badPing}}, time.Now())
+	ctl.markDown(conns[2], time.Now())
+	ctl.markDown(conns[2], time.Now()) // already down
+	ctl.markUp(conns[2], time.Now())
+	ctl.markUp(conns[2], time.Now()) // already up
+	ctl.setRanked([]*UdpConnection{conns[1], conns[0], conns[2]}, time.Now())
+
+	history := ctl.History()
+	var kinds []string
+	for _, e := range history.Events {
+		kinds = append(kinds, fmt.Sprintf("%s %d", e.Kind, e.PathID))
+	}
+	if want := []string{"selected -1", "down 2", "up 2", "reselected -1"}; !slices.Equal(kinds, want) {
+		t.Errorf("events = %v; want %v", kinds, want)
+	}
+	samples := history.Paths[0].Samples
+	if len(samples) != 2 || samples[0].PingMs != 30 || samples[0].RTTMs != 10 || !samples[1].Lost {
+		t.Errorf("path 0 samples = %+v; want 30ms then lost", samples)
+	}
+	if len(history.Paths[1].Samples) != 0 {
+		t.Errorf("path 1 samples = %+v; want none", history.Paths[1].Samples)
+	}
+}
+
+func TestAppendCapped(t *testing.T) {
+	var s []int
+	for i := range 5 {
+		s = appendCapped(s, i, 3)
+	}
+	if !slices.Equal(s, []int{2, 3, 4}) {
+		t.Errorf("appendCapped = %v; want [2 3 4]", s)
+	}
+}
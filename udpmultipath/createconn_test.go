@@ -5,46 +5,42 @@ import (
 	"testing"
 )
 
-func TestCreateDialers(t *testing.T) {
+func TestSystemDialer(t *testing.T) {
 	localIPs := []net.IP{
 		net.ParseIP("127.0.0.1"),
 		net.ParseIP("127.0.0.2"),
 	}
-	ds, err := createDialers(localIPs)
-	if err != nil {
-		t.Fatalf("createDialers: unexpected error: %v", err)
-	}
-	if len(ds) != len(localIPs) {
-		t.Fatalf("len(dialers) = %d; want %d", len(ds), len(localIPs))
-	}
-	for i, d := range ds {
-		udpAddr, ok := d.LocalAddr.(*net.UDPAddr)
+	for i, ip := range localIPs {
+		conn, err := systemDialer{}.DialPath(ip, "127.0.0.1:40000")
+		if err != nil {
+			t.Fatalf("DialPath %d: unexpected error: %v", i, err)
+		}
+		defer conn.Close()
+		udpAddr, ok := conn.LocalAddr().(*net.UDPAddr)
 		if !ok {
-			t.Errorf("dialer %d: LocalAddr is %T, want *net.UDPAddr", i, d.LocalAddr)
+			t.Errorf("conn %d: LocalAddr is %T, want *net.UDPAddr", i, conn.LocalAddr())
 			continue
 		}
 		if !udpAddr.IP.Equal(localIPs[i]) {
-			t.Errorf("dialer %d: IP = %v; want %v", i, udpAddr.IP, localIPs[i])
+			t.Errorf("conn %d: IP = %v; want %v", i, udpAddr.IP, localIPs[i])
 		}
-		if udpAddr.Port != 0 {
-			t.Errorf("dialer %d: Port = %d; want 0", i, udpAddr.Port)
+		if udpAddr.Port == 0 {
+			t.Errorf("conn %d: Port = 0; want an ephemeral port", i)
 		}
 	}
 }
 
 func TestCreateConnections_Success(t *testing.T) {
 	localIPs := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.1")}
-	dialers, _ := createDialers(localIPs)
-
 	targets := []string{"127.0.0.1:40000", "127.0.0.1:40001"}
 	pings := []string{"127.0.0.1:50000", "127.0.0.1:50001"}
 
-	connPort, err := createConnections(dialers, targets, pings)
+	connPort, err := createConnections(systemDialer{}, localIPs, targets, pings)
 	if err != nil {
 		t.Fatalf("createConnections returned error: %v", err)
 	}
-	// 2 dialers × 2 targets = 4 each
-	want := len(dialers) * len(targets)
+	// 2 local IPs × 2 targets = 4 each
+	want := len(localIPs) * len(targets)
 	if got := len(connPort.UDPConns); got != want {
 		t.Errorf("UDPConns length = %d; want %d", got, want)
 	}
@@ -0,0 +1,399 @@
+package udpmultipath
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/proxy"
+)
+
+// End-to-end test of the client and the proxies over loopback: two local "interfaces", 127.0.0.2 and
+// 127.0.0.3, send through two proxies, 127.0.0.10 and 127.0.0.11, to a fake game server at 127.0.0.100.
+// Game packets enter the client through a plain UDP ingress instead of WinDivert. Needs a loopback
+// answering the whole 127.0.0.0/8, as Linux's does.
+
+var (
+	e2eLocalIPs = []net.IP{net.IPv4(127, 0, 0, 2), net.IPv4(127, 0, 0, 3)}
+	e2eGameIP   = net.IPv4(127, 0, 0, 100)
+)
+
+// A proxy that can be killed and restarted on the same addresses.
+type e2eProxy struct {
+	t        *testing.T
+	listen   string
+	ping     string
+	upstream string // URL its latency to the game server is measured against
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// Picks free ports on `ip` and starts a proxy on them whose upstream latency is `latency`.
+func startE2EProxy(t *testing.T, ip net.IP, latency time.Duration) *e2eProxy {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+	}))
+	t.Cleanup(upstream.Close)
+	p := &e2eProxy{t: t, listen: freeUDPAddr(t, ip), ping: freeUDPAddr(t, ip), upstream: upstream.URL}
+	p.start()
+	t.Cleanup(p.kill)
+	return p
+}
+
+func freeUDPAddr(t *testing.T, ip net.IP) string {
+	t.Helper()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Skipf("can't listen on %v, the loopback doesn't answer 127.0.0.0/8: %v", ip, err)
+	}
+	defer c.Close()
+	return c.LocalAddr().String()
+}
+
+func (p *e2eProxy) start() {
+	p.t.Helper()
+	srv, err := proxy.NewServer(proxy.Options{
+		ListenAddr:       p.listen,
+		PingListenAddr:   p.ping,
+		Regions:          []string{"NA"},
+		UpstreamTargets:  map[string]string{"NA": p.upstream},
+		UpstreamInterval: 50 * time.Millisecond,
+		Logger:           slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		p.t.Fatalf("NewServer: %v", err)
+	}
+	if err := srv.Listen(); err != nil {
+		p.t.Fatalf("Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+	p.mu.Lock()
+	p.cancel, p.done = cancel, done
+	p.mu.Unlock()
+	waitForEstimate(p.t, p.ping)
+}
+
+// Stops the proxy and waits for its sockets to be closed.
+func (p *e2eProxy) kill() {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	if err := <-done; err != nil {
+		p.t.Errorf("Serve: %v", err)
+	}
+}
+
+// Pings `addr` until the proxy knows its upstream latency, so the client ranks the proxies by it.
+func waitForEstimate(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 512)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		req := protocol.EncodePingRequest(protocol.PingRequest{Nonce: 1, ClientSend: time.Now(), Region: "NA"})
+		if _, err := conn.Write(req); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if n, err := conn.Read(buf); err == nil {
+			if reply, err := protocol.DecodePingResponse(buf[:n]); err == nil && reply.Flags&protocol.FlagUpstreamUnknown == 0 {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("proxy %s has no upstream estimate", addr)
+}
+
+// A fake game server answering every packet with "re:" and the packet, remembering who sent what.
+type e2eGame struct {
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	received map[uint64][]netip.AddrPort // senders of every copy of each packet, by sequence number
+	first    map[netip.AddrPort]time.Time
+}
+
+func startE2EGame(t *testing.T) *e2eGame {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: e2eGameIP})
+	if err != nil {
+		t.Skipf("can't listen on %v: %v", e2eGameIP, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	g := &e2eGame{conn: conn, received: make(map[uint64][]netip.AddrPort), first: make(map[netip.AddrPort]time.Time)}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if n < 8 {
+				continue
+			}
+			g.mu.Lock()
+			seq := binary.BigEndian.Uint64(buf)
+			g.received[seq] = append(g.received[seq], from)
+			if _, ok := g.first[from]; !ok {
+				g.first[from] = time.Now()
+			}
+			g.mu.Unlock()
+			_, _ = conn.WriteToUDPAddrPort(append([]byte("re:"), buf[:n]...), from)
+		}
+	}()
+	return g
+}
+
+// Returns the senders of every copy of packet `seq`.
+func (g *e2eGame) copies(seq uint64) []netip.AddrPort {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.received[seq]
+}
+
+// Returns the proxies' sockets that have sent packets so far.
+func (g *e2eGame) senders() map[netip.AddrPort]bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[netip.AddrPort]bool)
+	for from := range g.first {
+		out[from] = true
+	}
+	return out
+}
+
+// A fake game client: it sends its packets through the client's ingress and counts the replies
+// the proxies relay back to it.
+type e2eClient struct {
+	t       *testing.T
+	conn    *net.UDPConn // where the game client listens, the session's ClientAddr
+	ingress *net.UDPConn
+	next    uint64
+
+	mu      sync.Mutex
+	replies map[uint64]int
+}
+
+func startE2EClient(t *testing.T, packetChan chan<- []byte) *e2eClient {
+	t.Helper()
+	listen := func(ip net.IP) *net.UDPConn {
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	// the game client listens on one of the interfaces, as the proxies require
+	c := &e2eClient{t: t, conn: listen(e2eLocalIPs[0]), replies: make(map[uint64]int)}
+
+	// the ingress stands in for WinDivert: whatever reaches it goes to the client
+	ingress := listen(net.IPv4(127, 0, 0, 1))
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, err := ingress.Read(buf)
+			if err != nil {
+				return
+			}
+			packetChan <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	out, err := net.DialUDP("udp", nil, ingress.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { out.Close() })
+	c.ingress = out
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, err := c.conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if n < 11 || string(buf[:3]) != "re:" {
+				continue
+			}
+			c.mu.Lock()
+			c.replies[binary.BigEndian.Uint64(buf[3:])]++
+			c.mu.Unlock()
+		}
+	}()
+	return c
+}
+
+// Sends the next `n` packets, one every `gap`; returns the sequence number of the first.
+func (c *e2eClient) send(n int, gap time.Duration) uint64 {
+	c.t.Helper()
+	first := c.next
+	for range n {
+		pkt := binary.BigEndian.AppendUint64(nil, c.next)
+		pkt = fmt.Appendf(pkt, " game packet %d", c.next)
+		if _, err := c.ingress.Write(pkt); err != nil {
+			c.t.Fatalf("write to the ingress: %v", err)
+		}
+		c.next++
+		time.Sleep(gap)
+	}
+	return first
+}
+
+func (c *e2eClient) repliesTo(seq uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.replies[seq]
+}
+
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end test")
+	}
+	game := startE2EGame(t)
+	// the client prefers the paths through the first proxy, which is closer to the game server
+	near := startE2EProxy(t, net.IPv4(127, 0, 0, 10), 5*time.Millisecond)
+	far := startE2EProxy(t, net.IPv4(127, 0, 0, 11), 80*time.Millisecond)
+
+	packetChan := make(chan []byte, 64)
+	client := startE2EClient(t, packetChan)
+	ctl := NewController()
+	cfg := Config{
+		Server:          "NA",
+		ThresholdFactor: 100, // keep the far paths as candidates
+		UpdateInterval:  time.Hour,
+		Timeout:         500 * time.Millisecond,
+		ProbeInterval:   100 * time.Millisecond,
+		ProbeDelay:      100 * time.Millisecond,
+		MaxConnections:  2,
+		Controller:      ctl,
+		Logger:          slog.New(slog.DiscardHandler),
+	}
+	session := GameSession{
+		ID:         "e2e",
+		GameAddr:   game.conn.LocalAddr().(*net.UDPAddr),
+		ClientAddr: client.conn.LocalAddr().(*net.UDPAddr),
+		ClientIPs:  e2eLocalIPs,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- cfg.MultipathProxy(ctx, session, e2eLocalIPs, []string{near.listen, far.listen}, []string{near.ping, far.ping}, packetChan)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("MultipathProxy: %v", err)
+		}
+	}()
+
+	// paths 0 and 2 go through the near proxy, 1 and 3 through the far one
+	nearPaths := func() bool {
+		status := ctl.Status()
+		if len(status.Paths) != 4 {
+			return false
+		}
+		for _, p := range status.Paths {
+			if want := p.Proxy == near.listen; (p.State == PathSelected) != want {
+				return false
+			}
+		}
+		return true
+	}
+	waitFor(t, "the near proxy's paths to be selected", nearPaths)
+
+	// 1) both paths go through the near proxy, which forwards a single copy of each packet
+	first := client.send(50, 2*time.Millisecond)
+	waitFor(t, "the packets to reach the game server", func() bool { return len(game.copies(client.next-1)) > 0 })
+	time.Sleep(50 * time.Millisecond) // let any duplicate arrive
+	nearSenders := game.senders()
+	for seq := first; seq < client.next; seq++ {
+		if got := len(game.copies(seq)); got != 1 {
+			t.Errorf("packet %d reached the game server %d times; want once", seq, got)
+		}
+	}
+	waitFor(t, "the replies to reach the client", func() bool { return client.repliesTo(client.next-1) > 0 })
+	time.Sleep(50 * time.Millisecond)
+	for seq := first; seq < client.next; seq++ {
+		if got := client.repliesTo(seq); got != 1 {
+			t.Errorf("the reply to packet %d reached the client %d times; want once", seq, got)
+		}
+	}
+
+	// 2) the near proxy dies mid-stream: its paths go down on the next writes and the far ones take over
+	killed := time.Now()
+	near.kill()
+	first = client.send(200, 2*time.Millisecond)
+	var failover time.Duration
+	game.mu.Lock()
+	for from, at := range game.first {
+		if !nearSenders[from] && (failover == 0 || at.Sub(killed) < failover) {
+			failover = at.Sub(killed)
+		}
+	}
+	game.mu.Unlock()
+	if failover == 0 || failover > time.Second {
+		t.Fatalf("failed over after %v; want within 1s", failover)
+	}
+	lost := 0
+	for seq := first; seq < client.next; seq++ {
+		switch copies := len(game.copies(seq)); {
+		case copies == 0:
+			lost++
+		case copies > 1:
+			t.Errorf("packet %d reached the game server %d times during the failover", seq, copies)
+		}
+	}
+	if lost > 20 {
+		t.Errorf("%d of 200 packets lost in the failover", lost)
+	}
+	for _, p := range ctl.Status().Paths {
+		if p.Proxy == near.listen && p.State != PathDown {
+			t.Errorf("path %d through the dead proxy is %s", p.ID, p.State)
+		}
+	}
+
+	// 3) the near proxy comes back: a probe reopens the session on it and its paths carry the traffic again
+	farSenders := game.senders()
+	near.start()
+	deadline := time.Now().Add(5 * time.Second)
+	for !nearPaths() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the near proxy's paths weren't re-added: %+v", ctl.Status().Paths)
+		}
+		client.send(5, 2*time.Millisecond)
+	}
+	first = client.send(50, 2*time.Millisecond)
+	waitFor(t, "the packets to reach the game server", func() bool { return len(game.copies(client.next-1)) > 0 })
+	time.Sleep(50 * time.Millisecond)
+	for seq := first; seq < client.next; seq++ {
+		copies := game.copies(seq)
+		if len(copies) != 1 || farSenders[copies[0]] {
+			t.Errorf("packet %d reached the game server from %v; want once from the restarted proxy", seq, copies)
+		}
+	}
+}
@@ -1,10 +1,11 @@
 package udpmultipath
 
 import (
-	"errors"
-	"log"
+	"log/slog"
 	"net"
 	"strings"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
 )
 
 // Checks for every interface that is not down and not a loopback interface. It also filters
@@ -46,34 +47,11 @@ func GetLocalAddresses() ([]net.IP, error) {
 				continue
 			}
 
-			addrRedacted, err := redactAddress(addr.String())
-			if err != nil {
-				continue
-			}
-
 			if ip.To4() != nil {
-				log.Printf("Found interface %v with IPv4: %s\n", iface.Name, addrRedacted)
+				slog.Info("found interface", "name", iface.Name, logging.KeyInterface, addr)
 				localIPv4 = append(localIPv4, ip)
 			}
 		}
 	}
 	return localIPv4, nil
 }
-
-// Redacts an address string to 192.0.1.x/x. Only works for IPv4
-func redactAddress(addr string) (string, error) {
-	parts := strings.SplitN(addr, "/", 2)
-	ipStringParts := strings.Split(parts[0], ".")
-	if len(ipStringParts) != 4 {
-		return "", errors.New("invalid IPv4 address: " + parts[0])
-	}
-	ipStringParts[3] = "x"
-
-	redacted := strings.Join(ipStringParts, ".")
-	if len(parts) == 2 {
-		parts[1] = "x"
-		redacted += "/" + parts[1]
-	}
-
-	return redacted, nil
-}
@@ -0,0 +1,86 @@
+package udpmultipath
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	maxSamples = 2880 // per path, a day of pings at the default update interval
+	maxEvents  = 1000
+)
+
+// EventKind is what happened to the paths.
+type EventKind string
+
+const (
+	EventSelected   EventKind = "selected"   // the first selection
+	EventReselected EventKind = "reselected" // a reselection changed the candidates or their order
+	EventDown       EventKind = "down"       // a path went down
+	EventUp         EventKind = "up"         // a probe got through a down path
+)
+
+// Event is the JSON view of something that happened to the paths.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Kind   EventKind `json:"kind"`
+	PathID int       `json:"path_id"` // -1 for selections
+}
+
+// Sample is the JSON view of a ping measurement of a path.
+type Sample struct {
+	Time   time.Time `json:"time"`
+	PingMs int64     `json:"ping_ms"` // expected client↔server ping
+	RTTMs  float64   `json:"rtt_ms"`  // client↔proxy leg
+	Lost   bool      `json:"lost"`
+}
+
+// PathHistory is the JSON view of the measurements of a path over the session.
+type PathHistory struct {
+	ID      int      `json:"id"`
+	Local   string   `json:"local"`
+	Proxy   string   `json:"proxy"`
+	Samples []Sample `json:"samples"` // oldest first
+}
+
+// History is what the control /history endpoint returns.
+type History struct {
+	Paths  []PathHistory `json:"paths"`
+	Events []Event       `json:"events"` // oldest first
+}
+
+// Returns the measurements of every path and the events since the session started, the oldest are dropped
+// past a day of pings or a thousand events.
+func (c *Controller) History() History {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	history := History{Paths: make([]PathHistory, 0, len(c.paths)), Events: slices.Clone(c.events)}
+	for _, p := range c.paths {
+		history.Paths = append(history.Paths, PathHistory{ID: p.id, Local: p.local, Proxy: p.conn.proxy, Samples: slices.Clone(p.samples)})
+	}
+	if history.Events == nil {
+		history.Events = []Event{}
+	}
+	return history
+}
+
+func (c *Controller) eventLocked(kind EventKind, id int, at time.Time) {
+	c.events = appendCapped(c.events, Event{Time: at, Kind: kind, PathID: id}, maxEvents)
+}
+
+func (p *pathState) sample(r result, at time.Time) {
+	s := Sample{Time: at, Lost: r.ping == badPing}
+	if !s.Lost {
+		s.PingMs = r.ping
+		s.RTTMs = float64(r.legs.proxy) / float64(time.Millisecond)
+	}
+	p.samples = appendCapped(p.samples, s, maxSamples)
+}
+
+// Appends `v` to `s`, dropping the oldest element if it already has `n`.
+func appendCapped[T any](s []T, v T, n int) []T {
+	if len(s) >= n {
+		s = slices.Delete(s, 0, len(s)-n+1)
+	}
+	return append(s, v)
+}
@@ -3,8 +3,9 @@ package udpmultipath
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/lysShub/divert-go"
@@ -37,7 +38,7 @@ func InterceptOngoingConnection(ctx context.Context, port int, packetChan chan<-
 				if errors.Is(err, windows.ERROR_INSUFFICIENT_BUFFER) {
 					continue
 				}
-				log.Printf("intercept ongoing error: %v", err)
+				slog.Error("failed to receive intercepted packets", logging.KeyError, err)
 				close(packetChan)
 				return
 			}
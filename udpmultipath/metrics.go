@@ -0,0 +1,162 @@
+package udpmultipath
+
+import (
+	"net"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/metrics"
+)
+
+// Upper bounds of the RTT histogram buckets, in seconds.
+var rttBuckets = []float64{.005, .01, .02, .03, .05, .075, .1, .15, .2, .3, .5, 1}
+
+// Metrics of the client, registered on Config.Metrics. A nil *clientMetrics records nothing.
+type clientMetrics struct {
+	registry         *metrics.Registry
+	rtt              metrics.HistogramVec
+	jitter           metrics.GaugeVec
+	pings            metrics.CounterVec
+	pingsLost        metrics.CounterVec
+	packets          metrics.CounterVec
+	bytes            metrics.CounterVec
+	writeErrors      metrics.CounterVec
+	transitions      metrics.CounterVec
+	down             metrics.GaugeVec
+	selectionChanges metrics.Counter
+	selected         metrics.Gauge
+}
+
+// Registers the client's metrics on `r`, returns nil if `r` is.
+func newClientMetrics(r *metrics.Registry) *clientMetrics {
+	if r == nil {
+		return nil
+	}
+	path := []string{"local", "proxy"}
+	return &clientMetrics{
+		registry:         r,
+		rtt:              r.Histogram("lolmp_client_path_rtt_seconds", "Client to proxy round trip time of the path's pings, without the proxy hold.", rttBuckets, path...),
+		jitter:           r.Gauge("lolmp_client_path_jitter_seconds", "Smoothed variation between consecutive RTTs of the path, as in RFC 3550.", path...),
+		pings:            r.Counter("lolmp_client_path_pings_total", "Pings sent over the path.", path...),
+		pingsLost:        r.Counter("lolmp_client_path_pings_lost_total", "Pings of the path that got no answer.", path...),
+		packets:          r.Counter("lolmp_client_path_packets_sent_total", "Game packets sent over the path.", path...),
+		bytes:            r.Counter("lolmp_client_path_bytes_sent_total", "Bytes of the game packets sent over the path, before sealing.", path...),
+		writeErrors:      r.Counter("lolmp_client_path_write_errors_total", "Writes to the path that failed.", path...),
+		transitions:      r.Counter("lolmp_client_path_transitions_total", "Times the path went down or came back up.", append(path, "to")...),
+		down:             r.Gauge("lolmp_client_path_down", "1 while the path is excluded until a probe gets through.", path...),
+		selectionChanges: r.Counter("lolmp_client_selection_changes_total", "Times reselection changed the selected paths.").With(),
+		selected:         r.Gauge("lolmp_client_selected_paths", "Paths currently selected to carry the game traffic.").With(),
+	}
+}
+
+// Metrics of a single path, shared by its data and ping connections. A nil *pathMetrics records nothing.
+type pathMetrics struct {
+	labels      []string
+	m           *clientMetrics
+	rtt         *metrics.Histogram
+	jitter      metrics.Gauge
+	pings       metrics.Counter
+	pingsLost   metrics.Counter
+	packets     metrics.Counter
+	bytes       metrics.Counter
+	writeErrors metrics.Counter
+	down        metrics.Gauge
+
+	lastRTT time.Duration // previous RTT, guarded by the ping connection's mu
+	jit     time.Duration
+}
+
+// Attaches path metrics to every connection of `connSet`, the paths are labeled by local IP and proxy.
+func (m *clientMetrics) instrument(connSet ConnectionPort) {
+	if m == nil {
+		return
+	}
+	for i, uc := range connSet.UDPConns {
+		local, _, _ := net.SplitHostPort(uc.conn.LocalAddr().String())
+		uc.metrics = m.path(local, uc.proxy)
+		connSet.PingConns[i].metrics = m.path(local, uc.proxy)
+	}
+}
+
+func (m *clientMetrics) path(local, proxy string) *pathMetrics {
+	return &pathMetrics{
+		labels:      []string{local, proxy},
+		m:           m,
+		rtt:         m.rtt.With(local, proxy),
+		jitter:      m.jitter.With(local, proxy),
+		pings:       m.pings.With(local, proxy),
+		pingsLost:   m.pingsLost.With(local, proxy),
+		packets:     m.packets.With(local, proxy),
+		bytes:       m.bytes.With(local, proxy),
+		writeErrors: m.writeErrors.With(local, proxy),
+		down:        m.down.With(local, proxy),
+	}
+}
+
+// Records the outcome of a ping: its client↔proxy RTT, or its loss if `err` isn't nil.
+func (p *pathMetrics) ping(rtt time.Duration, err error) {
+	if p == nil {
+		return
+	}
+	p.pings.Inc()
+	if err != nil {
+		p.pingsLost.Inc()
+		return
+	}
+	p.rtt.Observe(rtt.Seconds())
+	if p.lastRTT != 0 {
+		d := rtt - p.lastRTT
+		if d < 0 {
+			d = -d
+		}
+		p.jit += (d - p.jit) / 16
+		p.jitter.Set(p.jit.Seconds())
+	}
+	p.lastRTT = rtt
+}
+
+// Records a game packet of `n` bytes written to the path, or the failure to.
+func (p *pathMetrics) sent(n int, err error) {
+	if p == nil {
+		return
+	}
+	if err != nil {
+		p.writeErrors.Inc()
+		return
+	}
+	p.packets.Inc()
+	p.bytes.Add(float64(n))
+}
+
+// Records the path going down, or back up.
+func (p *pathMetrics) setDown(down bool) {
+	if p == nil {
+		return
+	}
+	if down {
+		p.down.Set(1)
+		p.m.transitions.With(append(p.labels, "down")...).Inc()
+	} else {
+		p.down.Set(0)
+		p.m.transitions.With(append(p.labels, "up")...).Inc()
+	}
+}
+
+// Records the paths selected to carry the traffic, and whether they changed.
+func (m *clientMetrics) selection(n int, changed bool) {
+	if m == nil {
+		return
+	}
+	m.selected.Set(float64(n))
+	if changed {
+		m.selectionChanges.Inc()
+	}
+}
+
+// Exposes the number of intercepted packets waiting to be sent.
+func (m *clientMetrics) queue(packetChan <-chan []byte) {
+	if m == nil {
+		return
+	}
+	m.registry.Func("lolmp_client_intercept_queue_depth", "Intercepted game packets waiting to be sent to the proxies.", metrics.GaugeType, nil,
+		func(emit func(float64, ...string)) { emit(float64(len(packetChan))) })
+}
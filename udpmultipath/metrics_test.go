@@ -0,0 +1,61 @@
+package udpmultipath
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/metrics"
+)
+
+func TestPathMetrics(t *testing.T) {
+	pinger := startFakePinger(t, 0, 0)
+	conn := dialConn(t, pinger.LocalAddr())
+	conn.proxy = "proxy:9029"
+
+	registry := metrics.NewRegistry()
+	m := newClientMetrics(registry)
+	m.instrument(ConnectionPort{UDPConns: []*UdpConnection{conn}, PingConns: []*UdpConnection{conn}})
+
+	cfg := Config{Timeout: time.Second}
+	for range 2 {
+		if _, err := cfg.udping(conn); err != nil {
+			t.Fatalf("udping: %v", err)
+		}
+	}
+	conn.metrics.sent(100, nil)
+	conn.metrics.sent(100, errors.New("unreachable"))
+	conn.metrics.setDown(true)
+	conn.metrics.setDown(false)
+	m.selection(1, true)
+
+	var b strings.Builder
+	if _, err := registry.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	labels := `local="127.0.0.1",proxy="proxy:9029"`
+	for _, want := range []string{
+		"lolmp_client_path_rtt_seconds_count{" + labels + "} 2\n",
+		"lolmp_client_path_pings_total{" + labels + "} 2\n",
+		"lolmp_client_path_pings_lost_total{" + labels + "} 0\n",
+		"lolmp_client_path_jitter_seconds{" + labels + "} ",
+		"lolmp_client_path_packets_sent_total{" + labels + "} 1\n",
+		"lolmp_client_path_bytes_sent_total{" + labels + "} 100\n",
+		"lolmp_client_path_write_errors_total{" + labels + "} 1\n",
+		"lolmp_client_path_transitions_total{" + labels + `,to="down"} 1` + "\n",
+		"lolmp_client_path_transitions_total{" + labels + `,to="up"} 1` + "\n",
+		"lolmp_client_path_down{" + labels + "} 0\n",
+		"lolmp_client_selection_changes_total 1\n",
+		"lolmp_client_selected_paths 1\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, b.String())
+		}
+	}
+
+	// without a registry nothing is recorded, and nothing breaks
+	none := newClientMetrics(nil)
+	none.instrument(ConnectionPort{UDPConns: []*UdpConnection{dialConn(t, pinger.LocalAddr())}})
+	none.selection(1, true)
+}
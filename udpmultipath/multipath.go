@@ -3,131 +3,134 @@ package udpmultipath
 import (
 	"context"
 	"fmt"
-	"log"
 	"net"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
 )
 
-// MultipathProxy spins up a single send loop and, if dynamic==true,
-// also a background ticker that updates the set of best connections.
-func (cfg *Config) MultipathProxy(ctx context.Context, localIPs []net.IP, proxyAddrs, proxyPingAddrs []string, packetChan <-chan []byte) error {
+// MultipathProxy opens `session` on the proxies, spins up a single send loop and a background reselection
+// loop, run every UpdateInterval if dynamic==true and whenever the controller asks for it. The session is
+// closed on return.
+func (cfg *Config) MultipathProxy(ctx context.Context, session GameSession, localIPs []net.IP, proxyAddrs, proxyPingAddrs []string, packetChan <-chan []byte) error {
 	// 1) Initial setup & first selection
-	connSet, err := multipathSetup(localIPs, proxyAddrs, proxyPingAddrs)
+	connSet, err := createConnections(cfg.dialer(), localIPs, proxyAddrs, proxyPingAddrs)
 	if err != nil {
 		return err
 	}
 	if !connSet.CheckLengths() {
 		return fmt.Errorf("a proxy has no corresponding ping port or listen port")
 	}
+	cfg.authenticate(connSet)
+	if cfg.Tunnel != nil {
+		cfg.openTunnels(connSet)
+	}
+	m := newClientMetrics(cfg.Metrics)
+	m.instrument(connSet)
+	m.queue(packetChan)
+	ctl := cfg.Controller
+	if ctl == nil {
+		ctl = NewController()
+	}
+	ctl.start(session, connSet, cfg.MaxConnections)
+	defer ctl.stop()
+	rec := cfg.startRecording(session, connSet)
+	defer rec.close()
 
 	firstTime := true
-	bestConns := cfg.selectBestConnections(connSet.UDPConns, connSet.PingConns, &firstTime)
+	bestConns := cfg.selectBestConnections(ctl, rec, connSet.UDPConns, connSet.PingConns, &firstTime)
 	if err != nil {
 		return err
 	}
-	if len(bestConns) > cfg.MaxConnections {
-		bestConns = bestConns[:cfg.MaxConnections]
-	}
-
 	defer closeConnections(connSet.UDPConns)
 	defer closeConnections(connSet.PingConns)
 
-	// bestConns is the slice sendMultipathData will use;
-	var mu sync.RWMutex
-
-	// 2) If dynamic reselection is turned on, start a ticker goroutine
-	if cfg.Dynamic {
-		go func() {
-			ticker := time.NewTicker(cfg.UpdateInterval)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					newSel := cfg.selectBestConnections(connSet.UDPConns, connSet.PingConns, &firstTime)
-					if err != nil {
-						log.Printf("warning: reselection error: %v", err)
-						continue
-					}
-					if !sameConnections(bestConns, newSel) {
-						mu.Lock()
-						bestConns = newSel
-						mu.Unlock()
-						log.Printf("updated best connections: %d", len(newSel))
-					}
-					// else: no change, do nothing
-				}
-			}
-		}()
+	// Paths whose proxy doesn't acknowledge the session start as down: probing them re-sends the session.
+	unopened, err := cfg.openSessions(session, bestConns)
+	if err != nil {
+		return err
 	}
+	defer cfg.closeSessions(session, bestConns)
 
-	return cfg.sendMultipathData(ctx, packetChan, &bestConns, &mu)
-}
+	// The controller sends over the first max connections of the candidates that are up.
+	now := cfg.clock().Now()
+	ctl.setRanked(bestConns, now)
+	m.selection(min(len(bestConns), ctl.MaxConnections()), false)
+	rec.selection(now, bestConns, ctl.active(nil), true)
 
-// Creates and returns the connections from the local IPs to the target addresses and ping addresses.
-func multipathSetup(localIPs []net.IP, targetsAddr, targetPingAddr []string) (ConnectionPort, error) {
-	dialers, err := createDialers(localIPs)
-	if err != nil {
-		return ConnectionPort{}, err
-	}
+	// 2) Start the reselection goroutine, ticking only if dynamic reselection is turned on
+	go func() {
+		var tick <-chan time.Time
+		if cfg.Dynamic {
+			ticker := cfg.clock().NewTicker(cfg.UpdateInterval)
+			defer ticker.Stop()
+			tick = ticker.Chan()
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			case <-ctl.reselect:
+			}
+			newSel := cfg.selectBestConnections(ctl, rec, connSet.UDPConns, connSet.PingConns, &firstTime)
+			now := cfg.clock().Now()
+			changed := ctl.setRanked(newSel, now)
+			rec.selection(now, newSel, ctl.active(nil), changed)
+			if changed {
+				m.selection(min(len(newSel), ctl.MaxConnections()), true)
+				cfg.logger().Info("updated best connections", logging.KeySession, session.ID, "candidates", len(newSel))
+			}
+			// else: no change, do nothing
+		}
+	}()
 
-	localToTargetsConn, err := createConnections(dialers, targetsAddr, targetPingAddr)
-	if err != nil {
-		return ConnectionPort{}, err
-	}
-	return localToTargetsConn, nil
+	return cfg.sendMultipathData(ctx, packetChan, ctl, rec, session, unopened)
 }
 
 // sendMultipathData reads from packetChan until closed,
-// and fan-outs each packet to the current bestConns slice.
+// and fan-outs each packet to the paths the controller currently selects.
 // It uses each UdpConnection’s own mu to serialize .Write calls.
-func (cfg *Config) sendMultipathData(ctx context.Context, packetChan <-chan []byte, selConnsPtr *[]*UdpConnection, bestMu *sync.RWMutex) error {
-	downSince := make(map[*UdpConnection]time.Time)
-	var downSinceMu sync.RWMutex
+// Connections in `down` start excluded. Connections down for cfg.ProbeDelay are probed every cfg.ProbeInterval
+// by opening `session` over them again, which re-binds the path; they are back up once the proxy acknowledges it.
+// Packets and probes are recorded to `rec`.
+func (cfg *Config) sendMultipathData(ctx context.Context, packetChan <-chan []byte, ctl *Controller, rec *recorder, session GameSession, down []*UdpConnection) error {
+	clock := cfg.clock()
+	for _, uc := range down {
+		if ctl.markDown(uc, clock.Now()) {
+			uc.metrics.setDown(true)
+		}
+	}
 	var wgProbe sync.WaitGroup
 	wgProbe.Add(1)
 
-	// Attempts a "probe" write to see if it's back up every 10 seconds.
+	// Probes the down paths every ProbeInterval to see if they are back up. A write getting through
+	// isn't enough, the proxy must answer: it may still be down, or have restarted without the session.
 	go func() {
 		defer wgProbe.Done()
-		ticker := time.NewTicker(cfg.ProbeInterval)
+		ticker := clock.NewTicker(cfg.ProbeInterval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				downSinceMu.RLock()
-				toProbe := make([]*UdpConnection, 0, len(downSince))
-				for uc, t0 := range downSince {
-					if time.Since(t0) >= 10*time.Second {
-						toProbe = append(toProbe, uc)
-					}
-				}
-				downSinceMu.RUnlock()
+			case now := <-ticker.Chan():
+				toProbe := ctl.downFor(cfg.probeDelay(), now)
 
 				var wg sync.WaitGroup
 				for _, uc := range toProbe {
 					wg.Add(1)
 					go func(udpConn *UdpConnection) {
 						defer wg.Done()
-						udpConn.mu.Lock()
-						defer udpConn.mu.Unlock()
-
-						deadline := time.Now().Add(min(1*time.Second, cfg.ProbeInterval))
-						if err := udpConn.conn.SetWriteDeadline(deadline); err != nil {
-							log.Printf("Failed to set write deadline: %v", err)
-						}
-
-						if _, err := udpConn.conn.Write([]byte{0}); err == nil {
-							log.Printf("Recovered connection %s->%s (after probe)",
-								udpConn.conn.LocalAddr(), udpConn.conn.RemoteAddr())
-							downSinceMu.Lock()
-							delete(downSince, udpConn)
-							downSinceMu.Unlock()
+						_, err := cfg.openSession(session, udpConn)
+						rec.probe(udpConn, now, err)
+						if err == nil {
+							cfg.logger().Info("path recovered after a probe", udpConn.attrs())
+							ctl.markUp(udpConn, clock.Now())
+							udpConn.metrics.setDown(false)
 						}
 					}(uc)
 				}
@@ -136,95 +139,75 @@ func (cfg *Config) sendMultipathData(ctx context.Context, packetChan <-chan []by
 		}
 	}()
 
-	selconns := make([]*UdpConnection, cfg.MaxConnections) // reusable buffer
+	var conns []*UdpConnection // reusable buffers
+	var errs []error
 	for {
 		select {
 		case <-ctx.Done():
 			wgProbe.Wait()
 			return nil
 		case pkt := <-packetChan:
-			// grab a snapshot of the current bestConns
-			bestMu.RLock()
-			n := copy(selconns, *selConnsPtr) // copy returns #elements copied
-			bestMu.RUnlock()
-			conns := make([]*UdpConnection, 0, cfg.MaxConnections)
-			for _, uc := range selconns[:n] {
-				downSinceMu.RLock()
-				_, down := downSince[uc]
-				downSinceMu.RUnlock()
-				if !down {
-					conns = append(conns, uc)
-					if len(conns) == cfg.MaxConnections {
-						break
-					}
-				}
-			}
+			at := clock.Now()
+			rec.intercepted(at, pkt)
+			// grab a snapshot of the paths currently selected
+			conns = ctl.active(conns)
+			errs = slices.Grow(errs[:0], len(conns))[:len(conns)] // every write sets its own error
 
 			var wg sync.WaitGroup
-			for _, uc := range conns {
+			for i, uc := range conns {
 				wg.Add(1)
-				go func(udpConn *UdpConnection, packet []byte) {
+				go func(i int, udpConn *UdpConnection, packet []byte) {
 					defer wg.Done()
 					udpConn.mu.Lock()
 					defer udpConn.mu.Unlock()
 
-					if _, err := udpConn.conn.Write(packet); err != nil {
-						downSinceMu.RLock()
-						_, down := downSince[udpConn]
-						downSinceMu.RUnlock()
-						if !down { // first time we see it is not down
-							log.Printf("Error writing to %v: %v, connection is down; excluding until probe recovers", udpConn.conn.RemoteAddr(), err)
-							downSinceMu.Lock()
-							downSince[udpConn] = time.Now()
-							downSinceMu.Unlock()
+					wire := cfg.sealFor(udpConn, packet)
+					_, err := udpConn.conn.Write(wire)
+					errs[i] = err
+					udpConn.metrics.sent(len(packet), err)
+					if err == nil {
+						udpConn.packets.Add(1)
+						rec.sent(udpConn, clock.Now(), wire)
+					}
+					if err != nil {
+						if ctl.markDown(udpConn, clock.Now()) { // first time we see it is not down
+							cfg.logger().Warn("path is down, excluding it until a probe gets through", udpConn.attrs(), logging.KeyError, err)
+							udpConn.metrics.setDown(true)
 						}
 						return
 					}
-				}(uc, pkt)
+				}(i, uc, pkt)
 			}
 			wg.Wait()
+			rec.packet(at, len(pkt), conns, errs)
 		}
 	}
 }
 
-// Creates dialers for every local IP. Its behaviour is not tested for IPv6
-func createDialers(localIPs []net.IP) ([]net.Dialer, error) {
-	dialers := make([]net.Dialer, 0)
-
-	for _, localIP := range localIPs {
-		localAddr := &net.UDPAddr{IP: localIP}
-		dialer := net.Dialer{
-			LocalAddr: localAddr,
-		}
-		dialers = append(dialers, dialer)
-	}
-
-	return dialers, nil
-}
-
-// Creates connections from the dialers to the target's addresses and target's ping addresses (must use IPv4 to work).
+// Creates connections with `dialer` from every local IP to the target's addresses and target's ping addresses (must use IPv4 to work).
 // It returns the connections in a single struct that stores them one-to-one with the same index.
 // If `len(targetsAddr) != len(targetsPingAddr)`, a further check (`CheckLengths`) will fail
 // This function assumes a one-to-one correspondence between `targetsAddr` and `targetsPingAddr`.
-func createConnections(dialers []net.Dialer, targetsAddr, targetsPingAddr []string) (ConnectionPort, error) {
+func createConnections(dialer PathDialer, localIPs []net.IP, targetsAddr, targetsPingAddr []string) (ConnectionPort, error) {
 	localToTargetsConn := ConnectionPort{}
 	numAddr := len(targetsAddr)
 
-	for _, localDialer := range dialers {
+	for _, localIP := range localIPs {
 		for idx := range numAddr {
-			conn, err := localDialer.Dial("udp", targetsAddr[idx])
+			conn, err := dialer.DialPath(localIP, targetsAddr[idx])
 			if err != nil {
 				closeConnections(localToTargetsConn.UDPConns)
 				return ConnectionPort{}, fmt.Errorf("connection to address %v couldn't be resolved: %w", targetsAddr[idx], err)
 			}
-			connPing, err := localDialer.Dial("udp", targetsPingAddr[idx])
+			connPing, err := dialer.DialPath(localIP, targetsPingAddr[idx])
 			if err != nil {
 				closeConnections(localToTargetsConn.UDPConns)
 				closeConnections(localToTargetsConn.PingConns)
 				return ConnectionPort{}, fmt.Errorf("connection to ping address %v couldn't be resolved: %w", targetsPingAddr[idx], err)
 			}
-			localToTargetsConn.UDPConns = append(localToTargetsConn.UDPConns, &UdpConnection{mu: sync.Mutex{}, conn: conn})
-			localToTargetsConn.PingConns = append(localToTargetsConn.PingConns, &UdpConnection{mu: sync.Mutex{}, conn: connPing})
+			path := len(localToTargetsConn.UDPConns)
+			localToTargetsConn.UDPConns = append(localToTargetsConn.UDPConns, &UdpConnection{mu: sync.Mutex{}, conn: conn, path: path, proxy: targetsAddr[idx]})
+			localToTargetsConn.PingConns = append(localToTargetsConn.PingConns, &UdpConnection{mu: sync.Mutex{}, conn: connPing, path: path, proxy: targetsAddr[idx]})
 		}
 	}
 
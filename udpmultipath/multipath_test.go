@@ -0,0 +1,69 @@
+package udpmultipath
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/keys"
+)
+
+// A connection dropping whatever is written to it, so only the scheduling is measured.
+type discardConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func (c discardConn) LocalAddr() net.Addr { return c.local }
+
+// Measures the fan-out of every packet: taking the snapshot of the selected paths, sealing the packet
+// for each and writing it to all of them concurrently.
+func BenchmarkSendMultipath(b *testing.B) {
+	key := keys.Key{Name: "bench", Secret: []byte("a pre-shared key, long enough")}
+	for _, bc := range []struct {
+		paths int
+		key   *keys.Key
+	}{{1, nil}, {2, nil}, {4, nil}, {8, nil}, {2, &key}} {
+		name := fmt.Sprintf("paths=%d", bc.paths)
+		if bc.key != nil {
+			name += "/auth"
+		}
+		b.Run(name, func(b *testing.B) {
+			var connSet ConnectionPort
+			for i := range bc.paths {
+				local := &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i+1)), Port: 50000}
+				uc := &UdpConnection{conn: discardConn{local: local}, path: i, proxy: fmt.Sprintf("proxy%d:9029", i)}
+				connSet.UDPConns = append(connSet.UDPConns, uc)
+				connSet.PingConns = append(connSet.PingConns, uc)
+			}
+			ctl := NewController()
+			ctl.start(GameSession{ID: "bench"}, connSet, bc.paths)
+			ctl.setRanked(connSet.UDPConns, time.Now())
+
+			cfg := Config{ProbeInterval: time.Hour, Key: bc.key, Logger: slog.New(slog.DiscardHandler)}
+			cfg.authenticate(connSet)
+			ctx, cancel := context.WithCancel(context.Background())
+			packetChan := make(chan []byte)
+			done := make(chan error)
+			go func() { done <- cfg.sendMultipathData(ctx, packetChan, ctl, nil, ctl.session, nil) }()
+
+			pkt := make([]byte, 100)
+			b.SetBytes(int64(len(pkt)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				packetChan <- pkt
+			}
+			b.StopTimer()
+			cancel()
+			if err := <-done; err != nil {
+				b.Fatalf("sendMultipathData: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package udpmultipath
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/capture"
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/trace"
+)
+
+// Records a session to its trace in Config.TraceDir and its capture in Config.CaptureDir.
+// A nil *recorder records nothing, and so does a nil trace or capture.
+type recorder struct {
+	trace   *trace.Writer
+	capture *capture.Writer
+	game    [2]netip.AddrPort   // client and game server, the ends of the intercepted packets
+	paths   [][2]netip.AddrPort // local and proxy address of each path, by ID
+	log     *slog.Logger
+	failed  sync.Once
+}
+
+// Creates the trace and the capture of `session` if cfg.TraceDir or cfg.CaptureDir are set; those that
+// can't be created are logged and skipped.
+func (cfg *Config) startRecording(session GameSession, connSet ConnectionPort) *recorder {
+	if cfg.TraceDir == "" && cfg.CaptureDir == "" {
+		return nil
+	}
+	now := cfg.clock().Now()
+	rec := &recorder{game: [2]netip.AddrPort{addrPort(session.ClientAddr), addrPort(session.GameAddr)}, log: cfg.logger().With(logging.KeySession, session.ID)}
+	info := trace.Session{ID: session.ID, GameAddr: addrString(session.GameAddr), ClientAddr: addrString(session.ClientAddr)}
+	interfaces := []capture.Interface{{Name: "game", Description: "game packets intercepted from the client"}}
+	for _, uc := range connSet.UDPConns {
+		local, _ := uc.conn.LocalAddr().(*net.UDPAddr)
+		remote, _ := uc.conn.RemoteAddr().(*net.UDPAddr)
+		rec.paths = append(rec.paths, [2]netip.AddrPort{addrPort(local), addrPort(remote)})
+		info.Paths = append(info.Paths, trace.Path{ID: uc.path, Local: local.IP.String(), Proxy: uc.proxy})
+		interfaces = append(interfaces, capture.Interface{Name: "path " + strconv.Itoa(uc.path), Description: local.IP.String() + " → " + uc.proxy})
+	}
+
+	if cfg.TraceDir != "" {
+		w, err := trace.Create(cfg.TraceDir, info, now)
+		if err != nil {
+			rec.log.Error("failed to create the session trace", logging.KeyError, err)
+		}
+		rec.trace = w
+	}
+	if cfg.CaptureDir != "" {
+		w, err := capture.Create(cfg.CaptureDir, session.ID, now, interfaces)
+		if err != nil {
+			rec.log.Error("failed to create the session capture", logging.KeyError, err)
+		}
+		rec.capture = w
+	}
+	if rec.trace == nil && rec.capture == nil {
+		return nil
+	}
+	return rec
+}
+
+func addrString(addr *net.UDPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func addrPort(addr *net.UDPAddr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	return addr.AddrPort()
+}
+
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	if r.trace != nil {
+		if err := r.trace.Close(); err != nil {
+			r.log.Error("failed to close the session trace", logging.KeyError, err)
+		}
+	}
+	if r.capture != nil {
+		if err := r.capture.Close(); err != nil {
+			r.log.Error("failed to close the session capture", logging.KeyError, err)
+		}
+	}
+}
+
+func (r *recorder) write(rec trace.Record) {
+	if err := r.trace.Write(rec); err != nil {
+		r.failed.Do(func() { r.log.Error("failed to write the session trace, recording stopped", logging.KeyError, err) })
+	}
+}
+
+func (r *recorder) writePacket(iface int, at time.Time, src, dst netip.AddrPort, payload []byte) {
+	if err := r.capture.WritePacket(iface, at, src, dst, payload); err != nil {
+		r.log.Debug("failed to capture a packet", logging.KeyError, err)
+	}
+}
+
+// Captures a game packet intercepted at `at`.
+func (r *recorder) intercepted(at time.Time, pkt []byte) {
+	if r == nil || r.capture == nil {
+		return
+	}
+	r.writePacket(0, at, r.game[0], r.game[1], pkt)
+}
+
+// Captures `wire`, the packet written to the path of `uc` at `at`.
+func (r *recorder) sent(uc *UdpConnection, at time.Time, wire []byte) {
+	if r == nil || r.capture == nil || uc.path >= len(r.paths) {
+		return
+	}
+	r.writePacket(1+uc.path, at, r.paths[uc.path][0], r.paths[uc.path][1], wire)
+}
+
+// Traces a packet intercepted at `at`, written to `conns`; errs[i] is the error of the write to conns[i].
+func (r *recorder) packet(at time.Time, size int, conns []*UdpConnection, errs []error) {
+	if r == nil || r.trace == nil {
+		return
+	}
+	p := &trace.Packet{Size: size, Sent: make([]int, 0, len(conns))}
+	for i, uc := range conns {
+		if errs[i] != nil {
+			p.Errors = append(p.Errors, trace.WriteError{Path: uc.path, Err: errs[i].Error()})
+			continue
+		}
+		p.Sent = append(p.Sent, uc.path)
+	}
+	r.write(trace.Record{T: at.UnixMicro(), Packet: p})
+}
+
+// Traces the pings of a selection round.
+func (r *recorder) pings(all []result, at time.Time) {
+	if r == nil || r.trace == nil {
+		return
+	}
+	for _, res := range all {
+		p := &trace.Ping{Path: res.conn.path, Lost: res.err != nil}
+		if res.err != nil {
+			p.Err = res.err.Error()
+		} else {
+			p.PingMs = res.ping
+			p.RTTUs = res.legs.proxy.Microseconds()
+			p.UpstreamUs = res.legs.upstream.Microseconds()
+		}
+		r.write(trace.Record{T: at.UnixMicro(), Ping: p})
+	}
+}
+
+// Traces the probe of a down path at `at`, err is nil if it got through.
+func (r *recorder) probe(uc *UdpConnection, at time.Time, err error) {
+	if r == nil || r.trace == nil {
+		return
+	}
+	p := &trace.Probe{Path: uc.path, Up: err == nil}
+	if err != nil {
+		p.Err = err.Error()
+	}
+	r.write(trace.Record{T: at.UnixMicro(), Probe: p})
+}
+
+// Traces the outcome of a selection round at `at`.
+func (r *recorder) selection(at time.Time, candidates, active []*UdpConnection, changed bool) {
+	if r == nil || r.trace == nil {
+		return
+	}
+	ids := func(conns []*UdpConnection) []int {
+		out := make([]int, len(conns))
+		for i, uc := range conns {
+			out[i] = uc.path
+		}
+		return out
+	}
+	r.write(trace.Record{T: at.UnixMicro(), Selection: &trace.Selection{Candidates: ids(candidates), Active: ids(active), Changed: changed}})
+}
@@ -0,0 +1,134 @@
+package udpmultipath
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/trace"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestRecorder(t *testing.T) {
+	ctl, conns := startTestController(t)
+	for i, uc := range conns {
+		uc.path = i
+	}
+	cfg := Config{ProbeInterval: time.Hour, TraceDir: t.TempDir(), CaptureDir: t.TempDir()}
+	rec := cfg.startRecording(ctl.session, ConnectionPort{UDPConns: conns})
+	if rec == nil {
+		t.Fatal("no recording started")
+	}
+
+	rec.pings([]result{
+		{conn: conns[0], ping: 30, legs: pingLegs{proxy: 10 * time.Millisecond, upstream: 20 * time.Millisecond}},
+		{conn: conns[1], ping: badPing, err: errors.New("timeout")},
+	}, time.Now())
+	rec.selection(time.Now(), conns, ctl.active(nil), true)
+
+	// the second path fails, its write is recorded as an error
+	conns[1].conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	packetChan := make(chan []byte)
+	done := make(chan error)
+	go func() { done <- cfg.sendMultipathData(ctx, packetChan, ctl, rec, ctl.session, nil) }()
+	packetChan <- make([]byte, 100)
+	packetChan <- make([]byte, 50)
+	packetChan <- make([]byte, 1) // sent once the second packet has been recorded
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("sendMultipathData: %v", err)
+	}
+	rec.close()
+
+	files, _ := filepath.Glob(filepath.Join(cfg.TraceDir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("trace files = %v", files)
+	}
+	records, err := trace.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(records) < 6 {
+		t.Fatalf("got %d records, want at least 6", len(records))
+	}
+
+	session := records[0].Session
+	if session == nil || session.ID != "s" || session.GameAddr != "192.0.2.1:5100" || len(session.Paths) != 3 || session.Paths[2].Proxy != "c:1" {
+		t.Errorf("session record = %+v", session)
+	}
+	if p := records[1].Ping; p == nil || p.Path != 0 || p.PingMs != 30 || p.RTTUs != 10000 || p.UpstreamUs != 20000 {
+		t.Errorf("first ping = %+v", p)
+	}
+	if p := records[2].Ping; p == nil || p.Path != 1 || !p.Lost || p.Err != "timeout" {
+		t.Errorf("lost ping = %+v", p)
+	}
+	if s := records[3].Selection; s == nil || !slices.Equal(s.Candidates, []int{0, 1, 2}) || !slices.Equal(s.Active, []int{0, 1}) || !s.Changed {
+		t.Errorf("selection = %+v", s)
+	}
+	if p := records[4].Packet; p == nil || p.Size != 100 || !slices.Equal(p.Sent, []int{0}) || len(p.Errors) != 1 || p.Errors[0].Path != 1 {
+		t.Errorf("first packet = %+v", p)
+	}
+	// the failed path is down, the next packet goes to the next candidate
+	if p := records[5].Packet; p == nil || p.Size != 50 || !slices.Equal(p.Sent, []int{0, 2}) || len(p.Errors) != 0 {
+		t.Errorf("second packet = %+v", p)
+	}
+
+	// the capture has the intercepted packets and a copy per successful write
+	captures, _ := filepath.Glob(filepath.Join(cfg.CaptureDir, "*.pcapng"))
+	if len(captures) != 1 {
+		t.Fatalf("capture files = %v", captures)
+	}
+	f, err := os.Open(captures[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatalf("NewNgReader: %v", err)
+	}
+	perInterface := make(map[int][]int) // sizes of the UDP payloads
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		udp, _ := gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default).Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if udp == nil {
+			t.Fatalf("captured packet without UDP: %x", data)
+		}
+		perInterface[ci.InterfaceIndex] = append(perInterface[ci.InterfaceIndex], len(udp.Payload))
+	}
+	if r.NInterfaces() != 4 {
+		t.Errorf("capture has %d interfaces, want the game and one per path", r.NInterfaces())
+	}
+	if got := perInterface[0]; len(got) < 2 || got[0] != 100 || got[1] != 50 {
+		t.Errorf("intercepted packets = %v", got)
+	}
+	if got := perInterface[1]; len(got) < 2 || got[0] != 100 || got[1] != 50 {
+		t.Errorf("packets of path 0 = %v", got)
+	}
+	if got := perInterface[2]; len(got) != 0 {
+		t.Errorf("packets of the failed path 1 = %v", got)
+	}
+	if got := perInterface[3]; len(got) < 1 || got[0] != 50 {
+		t.Errorf("packets of path 2 = %v", got)
+	}
+
+	// without a directory nothing is recorded, and nothing breaks
+	none := (&Config{}).startRecording(ctl.session, ConnectionPort{UDPConns: conns})
+	none.pings([]result{{conn: conns[0]}}, time.Now())
+	none.packet(time.Now(), 1, conns[:1], []error{nil})
+	none.probe(conns[0], time.Now(), nil)
+	none.intercepted(time.Now(), nil)
+	none.sent(conns[0], time.Now(), nil)
+	none.selection(time.Now(), conns, conns, false)
+	none.close()
+}
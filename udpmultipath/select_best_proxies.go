@@ -1,21 +1,18 @@
 package udpmultipath
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"net/http/httptrace"
+	"math/rand/v2"
 	"sort"
 	"sync"
 	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
 )
 
 // Pings every connection and returns them in ascending order. Depending on `firstTime` it trims them depending
-// on whether their ping exceeds 40% from the least ping.
-func (cfg *Config) selectBestConnections(conns []*UdpConnection, pingConn []*UdpConnection, firstTime *bool) []*UdpConnection {
+// on whether their ping exceeds 40% from the least ping. The measurements and trimmed paths are reported to `ctl`,
+// the pings are recorded to `rec`.
+func (cfg *Config) selectBestConnections(ctl *Controller, rec *recorder, conns []*UdpConnection, pingConn []*UdpConnection, firstTime *bool) []*UdpConnection {
 	var wg sync.WaitGroup
 	results := make(chan result, len(conns))
 
@@ -23,11 +20,12 @@ func (cfg *Config) selectBestConnections(conns []*UdpConnection, pingConn []*Udp
 		wg.Add(1)
 		go func(c *UdpConnection, pingConn *UdpConnection) {
 			defer wg.Done()
-			p, err := cfg.udping(pingConn)
+			legs, err := cfg.udping(pingConn)
+			p := legs.total().Milliseconds()
 			if err != nil {
 				p = badPing
 			}
-			results <- result{c, pingConn, p}
+			results <- result{conn: c, pingConn: pingConn, ping: p, legs: legs, err: err}
 		}(conns[index], pingConn[index])
 	}
 
@@ -40,6 +38,10 @@ func (cfg *Config) selectBestConnections(conns []*UdpConnection, pingConn []*Udp
 	for r := range results {
 		all = append(all, r)
 	}
+	estimateUnknownUpstreams(all)
+	now := cfg.clock().Now()
+	ctl.measured(all, now)
+	rec.pings(all, now)
 
 	selected, toBeClosed := cfg.selectAndCloseConnections(all, firstTime)
 
@@ -49,6 +51,7 @@ func (cfg *Config) selectBestConnections(conns []*UdpConnection, pingConn []*Udp
 		toBeClosedConnections[index] = toBeClosed[index].conn
 	}
 	closeConnections(toBeClosedConnections)
+	ctl.closed(toBeClosedConnections)
 
 	cfg.showPings(selected)
 
@@ -60,117 +63,77 @@ func (cfg *Config) selectBestConnections(conns []*UdpConnection, pingConn []*Udp
 	return bestConnections
 }
 
-// Sends a HTTP request to the League `server` (i.e LAN, LAS, NA, etc) and calculates the time it needs for
-// the DNS resolution + TCP handshake + (things that do are not how long the packet sent takes to make a roundtrip)
-// to occur. Idea taken from https://pingtestlive.com/league-of-legends
-func GetBloat(serverMap map[string]string, server string) (time.Duration, error) {
-	t0 := time.Now()
-	url, ok := serverMap[server]
-	if !ok {
-		return 0, fmt.Errorf("unknown server %q. Please use flag -servers to see which are available", server)
-	}
-
-	var (
-		tWriteDone time.Time
-		tFirstByte time.Time
-	)
+// udping sends a v2 ping request over conn and waits for the reply carrying the same nonce;
+// replies to earlier, timed out requests are skipped. The client↔proxy leg is the RTT minus the
+// time the proxy held the request, the proxy↔server leg is whatever the proxy reports.
+func (cfg *Config) udping(conn *UdpConnection) (pingLegs, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
 
-	// set up a trace to record exactly when the request is sent
-	trace := &httptrace.ClientTrace{
-		WroteRequest: func(info httptrace.WroteRequestInfo) {
-			tWriteDone = time.Now()
-		},
-		GotFirstResponseByte: func() {
-			tFirstByte = time.Now()
-		},
+	if err := conn.conn.SetDeadline(cfg.clock().Now().Add(cfg.Timeout)); err != nil {
+		return pingLegs{}, err
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return 0, err
+	nonce := rand.Uint64()
+	t0 := cfg.clock().Now()
+	req := protocol.EncodePingRequest(protocol.PingRequest{Nonce: nonce, ClientSend: t0, Region: cfg.Server})
+	if _, err := conn.conn.Write(cfg.sealFor(conn, req)); err != nil {
+		conn.metrics.ping(0, err)
+		return pingLegs{}, err
 	}
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 
-	tr := http.DefaultTransport.(*http.Transport).Clone()
-	tr.DisableKeepAlives = true
-	client := &http.Client{
-		Transport: tr,
-		Timeout:   2 * time.Second,
-	}
+	resp := make([]byte, 512)
+	for {
+		n, err := conn.conn.Read(resp)
+		if err != nil {
+			conn.metrics.ping(0, err)
+			return pingLegs{}, err
+		}
+		total := cfg.clock().Now().Sub(t0)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
+		reply, err := protocol.DecodePingResponse(resp[:n])
+		if err != nil || reply.Nonce != nonce {
+			continue // stale or foreign reply
+		}
 
-	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-		return 0, fmt.Errorf("reading body: %w", err)
+		legs := legsOf(reply, total)
+		conn.metrics.ping(legs.proxy, nil)
+		cfg.logger().Debug("ping answered", conn.attrs(), "hold", reply.Hold(), "rtt", total, "client_proxy", legs.proxy, "proxy_server", legs.upstream)
+		if legs.upstreamUnknown {
+			cfg.logger().Info("proxy doesn't know its proxy↔server latency yet", conn.attrs())
+		} else if reply.Flags&protocol.FlagUpstreamStale != 0 {
+			cfg.logger().Info("proxy reports a stale proxy↔server estimate", conn.attrs())
+		}
+		return legs, nil
 	}
+}
 
-	if tWriteDone.IsZero() || tFirstByte.IsZero() {
-		return 0, fmt.Errorf("trace events missing")
+// Splits the round trip `total` of a ping answered by `reply` into its legs. The proxy's numbers are
+// kept within bounds, so a broken proxy can't get a negative or overflowing latency: the hold within
+// the round trip, the upstream latency between zero and badPing.
+func legsOf(reply protocol.PingResponse, total time.Duration) pingLegs {
+	return pingLegs{
+		proxy:           total - min(max(reply.Hold(), 0), total),
+		upstream:        min(max(reply.Upstream, 0), badPing*time.Millisecond),
+		upstreamUnknown: reply.Flags&protocol.FlagUpstreamUnknown != 0,
 	}
-
-	t1 := time.Now()
-	latency := tFirstByte.Sub(tWriteDone)
-	totalTime := t1.Sub(t0)
-	bloat := totalTime - latency
-
-	return bloat, nil
 }
 
-// UDPing sends an 8-byte dummy packet over conn, waits for the 8-byte
-// “bloat” reply, and returns the true UDP RTT (total – bloat) in ms.
-func (cfg *Config) udping(conn *UdpConnection) (int64, error) {
-	conn.mu.Lock()
-	defer conn.mu.Unlock()
-
-	// Drain any packets already queued in the socket
-	drainBuf := make([]byte, 8)
-	if err := conn.conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
-		return 0, err
-	}
-	for {
-		if _, err := conn.conn.Read(drainBuf); err != nil {
-			break
+// Gives the paths whose proxy doesn't know its upstream latency the worst one the other proxies
+// report, so they are only preferred if they are that much closer.
+func estimateUnknownUpstreams(all []result) {
+	var worst time.Duration
+	for _, r := range all {
+		if r.ping != badPing && !r.legs.upstreamUnknown {
+			worst = max(worst, r.legs.upstream)
 		}
 	}
-	// Clear the read deadline
-	if err := conn.conn.SetReadDeadline(time.Time{}); err != nil {
-		return 0, err
-	}
-
-	// Do the real ping with a full timeout
-	if err := conn.conn.SetDeadline(time.Now().Add(cfg.Timeout)); err != nil {
-		return 0, err
-	}
-
-	t0 := time.Now()
-	dummy := make([]byte, 8)
-	if _, err := conn.conn.Write(dummy); err != nil {
-		return 0, err
-	}
-
-	resp := make([]byte, 8)
-	if _, err := conn.conn.Read(resp); err != nil {
-		return 0, err
-	}
-	total := time.Since(t0)
-
-	// Decode the “bloat” (HTTP proxy delay) in ms
-	var bloatMs int64
-	r := bytes.NewReader(resp)
-	if err := binary.Read(r, binary.BigEndian, &bloatMs); err != nil {
-		return 0, fmt.Errorf("decode int64: %w", err)
+	for i := range all {
+		if all[i].ping != badPing && all[i].legs.upstreamUnknown {
+			all[i].legs.upstream = worst
+			all[i].ping = all[i].legs.total().Milliseconds()
+		}
 	}
-	bloat := time.Duration(bloatMs) * time.Millisecond
-
-	// True UDP RTT
-	pingDur := total - bloat
-	log.Printf("Bloat: %v  Total RTT: %v  UDP-only ping: %v", bloat, total, pingDur)
-
-	return pingDur.Milliseconds(), nil
 }
 
 // Sorts the connections based on ping in ascending order.
@@ -236,10 +199,9 @@ func absInt64(x int64) int64 {
 	return x
 }
 
-// Logs in console the current ping for the best `maxConnections` connections.
+// Logs the current ping for the best `maxConnections` connections.
 func (cfg *Config) showPings(objs []result) {
 	var showObjs []result
-	show := ""
 	if len(objs) > cfg.MaxConnections {
 		showObjs = objs[:cfg.MaxConnections]
 	} else {
@@ -247,9 +209,7 @@ func (cfg *Config) showPings(objs []result) {
 	}
 
 	for _, obj := range showObjs {
-		redactedLocal, _ := redactAddress(obj.conn.conn.LocalAddr().String())
-		redactedRemote, _ := redactAddress(obj.conn.conn.RemoteAddr().String())
-		show += fmt.Sprintf("Expected ping for connection %s->%s: %d (ms)\n", redactedLocal, redactedRemote, obj.ping)
+		cfg.logger().Info("expected ping", obj.conn.attrs(), "ping_ms", obj.ping,
+			"client_proxy_ms", obj.legs.proxy.Milliseconds(), "proxy_server_ms", obj.legs.upstream.Milliseconds())
 	}
-	log.Printf("%v", show)
 }
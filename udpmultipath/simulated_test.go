@@ -0,0 +1,328 @@
+package udpmultipath
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/netsim"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+var (
+	simEpoch  = time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	simClient = netip.MustParseAddr("10.0.0.2")
+)
+
+// Runs the client on a netsim clock, remembering its tickers so the tests can tell when a tick was taken.
+type simClock struct {
+	*netsim.Clock
+	mu      sync.Mutex
+	tickers []*netsim.Ticker
+}
+
+func (c *simClock) NewTicker(d time.Duration) Ticker {
+	t := c.Clock.NewTicker(d)
+	c.mu.Lock()
+	c.tickers = append(c.tickers, t)
+	c.mu.Unlock()
+	return simTicker{t}
+}
+
+// Advances the clock by `d` and waits for every tick it sent to be taken.
+func (c *simClock) tick(t *testing.T, d time.Duration) {
+	t.Helper()
+	c.Advance(d)
+	c.mu.Lock()
+	tickers := c.tickers
+	c.mu.Unlock()
+	waitFor(t, "the ticks to be taken", func() bool {
+		for _, tk := range tickers {
+			if len(tk.C) > 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+type simTicker struct{ *netsim.Ticker }
+
+func (t simTicker) Chan() <-chan time.Time { return t.C }
+
+type simDialer struct{ net *netsim.Network }
+
+func (d simDialer) DialPath(local net.IP, remote string) (net.Conn, error) {
+	la, _ := netip.AddrFromSlice(local)
+	ra, err := netip.ParseAddrPort(remote)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.net.Dial(netip.AddrPortFrom(la.Unmap(), 0), ra)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Waits, in real time, for `cond` to hold; the code under test runs in its own goroutines.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Starts a fake ping listener at `addr` answering at once with `upstream` as its proxy↔server leg;
+// returns how many requests it has answered.
+func startSimPinger(t *testing.T, n *netsim.Network, addr string, upstream time.Duration) *atomic.Int32 {
+	t.Helper()
+	pc, err := n.ListenPacket(netip.MustParseAddrPort(addr))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	var answered atomic.Int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			size, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := protocol.DecodePingRequest(buf[:size])
+			if err != nil {
+				continue
+			}
+			now := n.Clock().Now()
+			reply := protocol.PingResponse{Nonce: req.Nonce, ClientSend: req.ClientSend, ProxyRecv: now, ProxySend: now, Upstream: upstream}
+			_, _ = pc.WriteTo(protocol.EncodePingResponse(reply), from)
+			answered.Add(1)
+		}
+	}()
+	return &answered
+}
+
+// Starts a fake relay listener at `addr` forwarding every packet it receives on the returned channel,
+// session opens as "open <session ID>" once it has acknowledged them.
+func startSimRelay(t *testing.T, n *netsim.Network, addr string) <-chan string {
+	t.Helper()
+	pc, err := n.ListenPacket(netip.MustParseAddrPort(addr))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	got := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			size, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			open, err := protocol.DecodeSessionOpen(buf[:size])
+			if err != nil {
+				got <- string(buf[:size])
+				continue
+			}
+			ack := protocol.SessionAck{Nonce: open.Nonce, SessionID: open.SessionID, Status: protocol.StatusOK}
+			_, _ = pc.WriteTo(protocol.EncodeSessionAck(ack), from)
+			got <- "open " + open.SessionID
+		}
+	}()
+	return got
+}
+
+func expectPackets(t *testing.T, relay string, got <-chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case p := <-got:
+			if p != w {
+				t.Errorf("relay %s got %q; want %q", relay, p, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("relay %s never got %q", relay, w)
+		}
+	}
+	select {
+	case p := <-got:
+		t.Errorf("relay %s got an unexpected %q", relay, p)
+	default:
+	}
+}
+
+func TestUdpingSimulated(t *testing.T) {
+	clock := &simClock{Clock: netsim.NewClock(simEpoch)}
+	n := netsim.New(clock.Clock, 1)
+	proxy := netip.MustParseAddr("198.51.100.1")
+	n.SetPath(simClient, proxy, netsim.Link{Latency: 25 * time.Millisecond})
+	startSimPinger(t, n, "198.51.100.1:9030", 40*time.Millisecond)
+
+	cfg := Config{Timeout: time.Second, Clock: clock, Dialer: simDialer{n}}
+	conn, err := cfg.dialer().DialPath(simClient.AsSlice(), "198.51.100.1:9030")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	uc := &UdpConnection{conn: conn}
+
+	type answer struct {
+		legs pingLegs
+		err  error
+	}
+	done := make(chan answer)
+	ping := func() {
+		legs, err := cfg.udping(uc)
+		done <- answer{legs, err}
+	}
+
+	go ping()
+	clock.BlockUntil(2) // the read deadline and the request in flight
+	clock.Step()
+	clock.BlockUntil(2) // the read deadline and the reply in flight
+	clock.Step()
+	got := <-done
+	if got.err != nil {
+		t.Fatalf("udping: %v", got.err)
+	}
+	if got.legs.proxy != 50*time.Millisecond || got.legs.upstream != 40*time.Millisecond {
+		t.Errorf("legs = %+v; want a 50ms round trip and a 40ms upstream", got.legs)
+	}
+
+	// a ping over a link that went down times out after exactly cfg.Timeout
+	n.SetPath(simClient, proxy, netsim.Link{Down: true})
+	start := clock.Now()
+	go ping()
+	waitFor(t, "the ping to be sent", func() bool { return n.Stats(simClient, proxy).Lost == 1 })
+	clock.Advance(time.Second)
+	got = <-done
+	if !errors.Is(got.err, os.ErrDeadlineExceeded) || clock.Since(start) != time.Second {
+		t.Errorf("udping = %v after %v; want a timeout after 1s", got.err, clock.Since(start))
+	}
+}
+
+func TestSelectBestConnectionsSimulated(t *testing.T) {
+	clock := &simClock{Clock: netsim.NewClock(simEpoch)}
+	n := netsim.New(clock.Clock, 1)
+	var answered []*atomic.Int32
+	var targets, pings []string
+	for i, upstream := range []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 0} {
+		ip := netip.AddrFrom4([4]byte{198, 51, 100, byte(i + 1)})
+		targets = append(targets, netip.AddrPortFrom(ip, 9029).String())
+		pings = append(pings, netip.AddrPortFrom(ip, 9030).String())
+		answered = append(answered, startSimPinger(t, n, pings[i], upstream))
+	}
+	n.SetPath(simClient, netip.MustParseAddr("198.51.100.4"), netsim.Link{Down: true})
+
+	cfg := Config{Timeout: time.Second, MaxConnections: 2, ThresholdFactor: 1.4, Clock: clock, Dialer: simDialer{n}}
+	connSet, err := createConnections(cfg.dialer(), []net.IP{simClient.AsSlice()}, targets, pings)
+	if err != nil {
+		t.Fatalf("createConnections: %v", err)
+	}
+	defer closeConnections(connSet.UDPConns)
+	defer closeConnections(connSet.PingConns)
+	ctl := NewController()
+	ctl.start(GameSession{ID: "s"}, connSet, cfg.MaxConnections)
+
+	done := make(chan []*UdpConnection)
+	firstTime := false
+	go func() { done <- cfg.selectBestConnections(ctl, nil, connSet.UDPConns, connSet.PingConns, &firstTime) }()
+	waitFor(t, "the pings to be answered", func() bool {
+		return answered[0].Load()+answered[1].Load()+answered[2].Load() == 3
+	})
+	clock.BlockUntil(4) // the read deadlines
+	clock.Advance(time.Second)
+	best := <-done
+
+	var order []int
+	for _, uc := range best {
+		order = append(order, uc.path)
+	}
+	if len(order) != 4 || order[0] != 1 || order[1] != 2 || order[2] != 0 || order[3] != 3 {
+		t.Errorf("paths by ping = %v; want [1 2 0 3]", order)
+	}
+	status := ctl.Status()
+	if p := status.Paths[3]; !p.PingFailed || !p.MeasuredAt.Equal(simEpoch.Add(time.Second)) {
+		t.Errorf("path 3 = %+v; want its ping failed at 1s", p)
+	}
+	if p := status.Paths[1]; p.PingMs != 10 || p.ProxyMs != 0 {
+		t.Errorf("path 1 = %+v; want a 10ms ping", p)
+	}
+}
+
+func TestProbeRecoverySimulated(t *testing.T) {
+	clock := &simClock{Clock: netsim.NewClock(simEpoch)}
+	n := netsim.New(clock.Clock, 1)
+	a := startSimRelay(t, n, "198.51.100.1:9029")
+	b := startSimRelay(t, n, "198.51.100.2:9029")
+	proxyB := netip.MustParseAddr("198.51.100.2")
+	n.SetPath(simClient, proxyB, netsim.Link{Unreachable: true})
+
+	cfg := Config{Timeout: time.Second, ProbeInterval: time.Second, ProbeDelay: 3 * time.Second, Clock: clock, Dialer: simDialer{n}}
+	connSet, err := createConnections(cfg.dialer(), []net.IP{simClient.AsSlice()},
+		[]string{"198.51.100.1:9029", "198.51.100.2:9029"}, []string{"198.51.100.1:9030", "198.51.100.2:9030"})
+	if err != nil {
+		t.Fatalf("createConnections: %v", err)
+	}
+	defer closeConnections(connSet.UDPConns)
+	defer closeConnections(connSet.PingConns)
+	ctl := NewController()
+	session := GameSession{
+		ID:         "s",
+		GameAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5100},
+		ClientAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000},
+	}
+	ctl.start(session, connSet, 2)
+	ctl.setRanked(connSet.UDPConns, clock.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	packetChan := make(chan []byte)
+	done := make(chan error)
+	go func() { done <- cfg.sendMultipathData(ctx, packetChan, ctl, nil, session, nil) }()
+	clock.BlockUntil(1) // the probe ticker
+
+	// the write to b fails, the next packet only goes to a
+	packetChan <- []byte("p1")
+	packetChan <- []byte("p2")
+	expectPackets(t, "a", a, "p1", "p2")
+	expectPackets(t, "b", b)
+	if p := ctl.Status().Paths[1]; p.State != PathDown || !p.DownSince.Equal(simEpoch) {
+		t.Fatalf("path 1 = %+v; want down since the start", p)
+	}
+
+	// b is reachable again, but it is only probed once it has been down for the probe delay,
+	// and it is up once its proxy acknowledges the session
+	n.SetPath(simClient, proxyB, netsim.Link{})
+	clock.tick(t, time.Second)
+	clock.tick(t, time.Second)
+	expectPackets(t, "b", b)
+	clock.tick(t, time.Second)
+	expectPackets(t, "b", b, "open s")
+	waitFor(t, "path 1 to be up", func() bool { return ctl.Status().Paths[1].State == PathSelected })
+	events := ctl.History().Events
+	if last := events[len(events)-1]; last.Kind != EventUp || last.PathID != 1 || !last.Time.Equal(simEpoch.Add(3*time.Second)) {
+		t.Errorf("last event = %+v; want path 1 up at 3s", last)
+	}
+
+	packetChan <- []byte("p3")
+	expectPackets(t, "a", a, "p3")
+	expectPackets(t, "b", b, "p3")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("sendMultipathData: %v", err)
+	}
+}
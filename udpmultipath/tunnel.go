@@ -0,0 +1,95 @@
+package udpmultipath
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/logging"
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+	"github.com/SergioFloresCorrea/lol-multipath/tunnel"
+)
+
+// Opens an encrypted tunnel to every proxy with a known public key, over the first of its paths that
+// answers, and shares it with every data and ping connection to that proxy. Proxies without a key
+// or whose handshake fails are logged and keep the unencrypted transport.
+func (cfg *Config) openTunnels(connSet ConnectionPort) {
+	byProxy := make(map[string][]*UdpConnection)
+	for _, uc := range connSet.UDPConns {
+		byProxy[uc.proxy] = append(byProxy[uc.proxy], uc)
+	}
+
+	var wg sync.WaitGroup
+	senders := make(map[string]*tunnel.Sender)
+	var mu sync.Mutex
+	for proxy, paths := range byProxy {
+		remote, ok := cfg.Tunnel.ProxyKeys[proxy]
+		if !ok {
+			cfg.logger().Warn("no public key for the proxy, its packets won't be encrypted", logging.KeyProxy, proxy)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			for _, uc := range paths {
+				var sender *tunnel.Sender
+				if sender, err = cfg.handshake(uc, remote); err == nil {
+					mu.Lock()
+					senders[proxy] = sender
+					mu.Unlock()
+					return
+				}
+			}
+			cfg.logger().Warn("couldn't open an encrypted tunnel", logging.KeyProxy, proxy, logging.KeyError, err)
+		}()
+	}
+	wg.Wait()
+
+	for _, uc := range append(connSet.UDPConns, connSet.PingConns...) {
+		uc.sender = senders[uc.proxy]
+	}
+}
+
+// Runs the handshake of a tunnel to the proxy with public key `remote` over `conn`. A HandshakeInit is
+// sent up to `controlAttempts` times, waiting `cfg.Timeout` for the answer each time. Each attempt starts
+// a new handshake, as the proxy refuses a copy of one it already accepted.
+func (cfg *Config) handshake(conn *UdpConnection, remote [tunnel.KeySize]byte) (*tunnel.Sender, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	defer conn.conn.SetDeadline(time.Time{})
+
+	buf := make([]byte, 512)
+	var initiators []*tunnel.Initiator // a late answer to an earlier attempt is as good
+	var lastErr error
+	for range controlAttempts {
+		initiator, noise, err := tunnel.StartHandshake(cfg.Tunnel.Key, remote, cfg.clock().Now())
+		if err != nil {
+			return nil, err
+		}
+		initiators = append(initiators, initiator)
+		if _, err := conn.conn.Write(protocol.EncodeHandshakeInit(noise)); err != nil {
+			return nil, err
+		}
+		if err := conn.conn.SetReadDeadline(cfg.clock().Now().Add(cfg.Timeout)); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.conn.Read(buf)
+			if err != nil {
+				lastErr = err
+				break
+			}
+			index, reply, err := protocol.DecodeHandshakeResponse(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, initiator := range initiators {
+				if sender, err := initiator.Finish(index, reply); err == nil {
+					return sender, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no handshake response after %d attempts: %w", controlAttempts, lastErr)
+}
@@ -0,0 +1,109 @@
+package udpmultipath
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SergioFloresCorrea/lol-multipath/protocol"
+)
+
+// Starts a fake ping listener that answers every request with a stale reply first and then the real one.
+func startFakePinger(t *testing.T, hold, upstream time.Duration) *net.UDPConn {
+	t.Helper()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			recv := time.Now()
+			req, err := protocol.DecodePingRequest(buf[:n])
+			if err != nil {
+				continue
+			}
+			stale := protocol.PingResponse{Nonce: req.Nonce + 1, ProxyRecv: recv, ProxySend: recv}
+			_, _ = pc.WriteToUDP(protocol.EncodePingResponse(stale), addr)
+			_, _ = pc.WriteToUDP(protocol.EncodePingV1Response(time.Second), addr)
+
+			time.Sleep(hold)
+			reply := protocol.PingResponse{
+				Nonce:      req.Nonce,
+				ClientSend: req.ClientSend,
+				ProxyRecv:  recv,
+				ProxySend:  time.Now(),
+				Upstream:   upstream,
+			}
+			_, _ = pc.WriteToUDP(protocol.EncodePingResponse(reply), addr)
+		}
+	}()
+	return pc
+}
+
+func TestUdpingMatchesNonce(t *testing.T) {
+	pinger := startFakePinger(t, 20*time.Millisecond, 40*time.Millisecond)
+
+	conn, err := net.Dial("udp", pinger.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	cfg := Config{Timeout: time.Second}
+	legs, err := cfg.udping(&UdpConnection{conn: conn})
+	if err != nil {
+		t.Fatalf("udping: %v", err)
+	}
+
+	if legs.upstream != 40*time.Millisecond {
+		t.Errorf("upstream = %v; want 40ms", legs.upstream)
+	}
+	// the proxy hold is subtracted, so the loopback leg should be well below it
+	if legs.proxy < 0 || legs.proxy >= 20*time.Millisecond {
+		t.Errorf("proxy leg = %v; want within [0, 20ms)", legs.proxy)
+	}
+}
+
+func TestUdpingTimeout(t *testing.T) {
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer silent.Close()
+
+	conn, err := net.Dial("udp", silent.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	cfg := Config{Timeout: 50 * time.Millisecond}
+	if _, err := cfg.udping(&UdpConnection{conn: conn}); err == nil {
+		t.Fatalf("udping: expected timeout error")
+	}
+}
+
+func FuzzPingLegs(f *testing.F) {
+	recv := time.Unix(0, 1718000000123456789)
+	f.Add(protocol.EncodePingResponse(protocol.PingResponse{Nonce: 1, ProxyRecv: recv, ProxySend: recv.Add(2 * time.Millisecond), Upstream: 40 * time.Millisecond}), int64(30*time.Millisecond))
+	f.Add(protocol.EncodePingResponse(protocol.PingResponse{ProxyRecv: recv, ProxySend: recv.Add(-time.Hour), Upstream: -time.Hour}), int64(time.Millisecond))
+	f.Add(protocol.EncodePingResponse(protocol.PingResponse{ProxySend: recv, Upstream: 1<<63 - 1, Flags: protocol.FlagUpstreamUnknown}), int64(0))
+	f.Fuzz(func(t *testing.T, b []byte, rtt int64) {
+		reply, err := protocol.DecodePingResponse(b)
+		if err != nil {
+			return
+		}
+		total := time.Duration(uint64(rtt) % uint64(time.Hour)) // the round trip is bounded by cfg.Timeout
+		legs := legsOf(reply, total)
+		if legs.proxy < 0 || legs.proxy > total || legs.upstream < 0 || legs.total() < legs.proxy {
+			t.Fatalf("legs of %+v after %v: %+v", reply, total, legs)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+// Package webui serves a self-contained page charting the latency of a running client's paths over the
+// session, with its reselections and down/up transitions, so the link can be watched from another device.
+package webui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/SergioFloresCorrea/lol-multipath/udpmultipath"
+)
+
+//go:embed static
+var static embed.FS
+
+// Returns the handler of the read-only dashboard: the page at /, and the controller's /status and /history,
+// which it polls. None of the controller's actions are reachable through it.
+func Handler(ctl *udpmultipath.Controller) http.Handler {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // the embedded directory is always there
+	}
+	api := ctl.Handler()
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(assets))
+	mux.Handle("GET /status", api)
+	mux.Handle("GET /history", api)
+	return mux
+}
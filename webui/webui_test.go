@@ -0,0 +1,38 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SergioFloresCorrea/lol-multipath/udpmultipath"
+)
+
+func TestHandler(t *testing.T) {
+	handler := Handler(udpmultipath.NewController())
+	get := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := get(http.MethodGet, "/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<svg id="chart"`) {
+		t.Errorf("GET / = %d, %.100q; want the page", rec.Code, rec.Body.String())
+	}
+	rec := get(http.MethodGet, "/history")
+	var history udpmultipath.History
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); rec.Code != http.StatusOK || err != nil || history.Events == nil {
+		t.Errorf("GET /history = %d, %v, %+v", rec.Code, err, history)
+	}
+	if rec := get(http.MethodGet, "/status"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"running": false`) {
+		t.Errorf("GET /status = %d, %s", rec.Code, rec.Body.String())
+	}
+	// the dashboard is read-only
+	for _, path := range []string{"/pause", "/reselect", "/paths/0/ban"} {
+		if rec := get(http.MethodPost, path); rec.Code == http.StatusOK {
+			t.Errorf("POST %s = 200; want it refused", path)
+		}
+	}
+}